@@ -0,0 +1,648 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"golang.org/x/text/unicode/bidi"
+)
+
+// bidiMaxDepth is the deepest explicit embedding level of the Unicode Bidirectional Algorithm.
+const bidiMaxDepth = 125
+
+// bidiParagraph resolves the embedding levels of the runes of a paragraph with the Unicode Bidirectional Algorithm, see
+// https://unicode.org/reports/tr9/. The paragraph level is found from the first strong character, and is 0, left to
+// right, if there is none. Line breaks are not paragraph separators here: each line is resolved on its own.
+type bidiParagraph struct {
+	runes   []rune
+	classes []bidi.Class // the original classes of the runes
+	types   []bidi.Class // the classes of the runes as they are resolved
+	levels  []int8
+	level   int8 // the paragraph embedding level
+
+	matchingPDI       []int // for each isolate initiator, the index of its matching PDI, or len(runes)
+	matchingInitiator []int // for each PDI, the index of its matching isolate initiator, or -1
+}
+
+// bidiLevels returns the resolved embedding levels of a line of runes and the paragraph embedding level.
+func bidiLevels(runes []rune) ([]int8, int8) {
+	p := &bidiParagraph{runes: runes}
+	p.classes = make([]bidi.Class, len(runes))
+	for i, r := range runes {
+		props, _ := bidi.LookupRune(r)
+		p.classes[i] = props.Class()
+	}
+	p.types = append([]bidi.Class(nil), p.classes...)
+	p.levels = make([]int8, len(runes))
+
+	p.matchIsolates()
+	p.level = p.firstStrongLevel(0, len(runes), 0)
+	p.explicitLevels()
+	for _, seq := range p.runSequences() {
+		seq.resolveWeakTypes()
+		seq.resolvePairedBrackets()
+		seq.resolveNeutralTypes()
+		seq.resolveImplicitLevels()
+	}
+	p.resetWhitespaceLevels()
+
+	return p.levels, p.level
+}
+
+// isRemovedByX9 tells whether a class is ignored by the rules after X9, like explicit embeddings and boundary neutrals.
+func isRemovedByX9(c bidi.Class) bool {
+	switch c {
+	case bidi.LRE, bidi.RLE, bidi.LRO, bidi.RLO, bidi.PDF, bidi.BN:
+		return true
+	}
+
+	return false
+}
+
+// isIsolateInitiator tells whether a class starts a directional isolate.
+func isIsolateInitiator(c bidi.Class) bool {
+	return c == bidi.LRI || c == bidi.RLI || c == bidi.FSI
+}
+
+// matchIsolates finds the matching PDI of every isolate initiator, BD9.
+func (p *bidiParagraph) matchIsolates() {
+	n := len(p.runes)
+	p.matchingPDI = make([]int, n)
+	p.matchingInitiator = make([]int, n)
+	var stack []int
+	for i, c := range p.classes {
+		p.matchingPDI[i] = n
+		p.matchingInitiator[i] = -1
+		switch {
+		case isIsolateInitiator(c):
+			stack = append(stack, i)
+		case c == bidi.PDI && len(stack) > 0:
+			j := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			p.matchingPDI[j] = i
+			p.matchingInitiator[i] = j
+		}
+	}
+}
+
+// firstStrongLevel returns the level of the first strong character between start and end, skipping isolates, P2 and
+// P3, or def if there is none.
+func (p *bidiParagraph) firstStrongLevel(start, end int, def int8) int8 {
+	for i := start; i < end; i++ {
+		switch c := p.classes[i]; {
+		case c == bidi.L:
+			return 0
+		case c == bidi.R || c == bidi.AL:
+			return 1
+		case isIsolateInitiator(c):
+			i = p.matchingPDI[i]
+		}
+	}
+
+	return def
+}
+
+// explicitLevels applies the explicit embeddings, overrides and isolates, X1 to X8.
+func (p *bidiParagraph) explicitLevels() {
+	type status struct {
+		level    int8
+		override bidi.Class // L, R, or ON for no override
+		isolate  bool
+	}
+	stack := []status{{p.level, bidi.ON, false}}
+	overflowIsolates, overflowEmbeddings, validIsolates := 0, 0, 0
+
+	nextLevel := func(rtl bool) int8 {
+		level := stack[len(stack)-1].level
+		if rtl {
+			return (level + 1) | 1
+		}
+		return (level + 2) &^ 1
+	}
+
+	for i, c := range p.classes {
+		last := stack[len(stack)-1]
+		switch c {
+		case bidi.RLE, bidi.LRE, bidi.RLO, bidi.LRO, bidi.RLI, bidi.LRI, bidi.FSI:
+			isolate := isIsolateInitiator(c)
+			rtl := c == bidi.RLE || c == bidi.RLO || c == bidi.RLI
+			if c == bidi.FSI {
+				rtl = p.firstStrongLevel(i+1, p.matchingPDI[i], 0) == 1
+			}
+			p.levels[i] = last.level
+			if isolate && last.override != bidi.ON {
+				p.types[i] = last.override
+			}
+
+			level := nextLevel(rtl)
+			if level <= bidiMaxDepth && overflowIsolates == 0 && overflowEmbeddings == 0 {
+				override := bidi.ON
+				if c == bidi.RLO {
+					override = bidi.R
+				} else if c == bidi.LRO {
+					override = bidi.L
+				}
+				if isolate {
+					validIsolates++
+				}
+				stack = append(stack, status{level, override, isolate})
+			} else if isolate {
+				overflowIsolates++
+			} else if overflowIsolates == 0 {
+				overflowEmbeddings++
+			}
+		case bidi.PDI:
+			if overflowIsolates > 0 {
+				overflowIsolates--
+			} else if validIsolates > 0 {
+				overflowEmbeddings = 0
+				for !stack[len(stack)-1].isolate {
+					stack = stack[:len(stack)-1]
+				}
+				stack = stack[:len(stack)-1]
+				validIsolates--
+			}
+			last = stack[len(stack)-1]
+			p.levels[i] = last.level
+			if last.override != bidi.ON {
+				p.types[i] = last.override
+			}
+		case bidi.PDF:
+			p.levels[i] = last.level
+			switch {
+			case overflowIsolates > 0:
+				// the PDF is within an overflowing isolate
+			case overflowEmbeddings > 0:
+				overflowEmbeddings--
+			case !last.isolate && len(stack) >= 2:
+				stack = stack[:len(stack)-1]
+			}
+		case bidi.B:
+			p.levels[i] = p.level
+		case bidi.BN:
+			p.levels[i] = last.level
+		default:
+			p.levels[i] = last.level
+			if last.override != bidi.ON {
+				p.types[i] = last.override
+			}
+		}
+	}
+}
+
+// bidiRunSequence is an isolating run sequence, X10: the indexes of the runes that are resolved together, and the
+// classes at its start and end.
+type bidiRunSequence struct {
+	p        *bidiParagraph
+	indexes  []int
+	level    int8
+	sos, eos bidi.Class
+}
+
+// runSequences splits the paragraph into level runs and links them into isolating run sequences, BD13 and X10.
+func (p *bidiParagraph) runSequences() []*bidiRunSequence {
+	// level runs, ignoring the characters removed by X9
+	var runs [][]int
+	var current []int
+	currentLevel := int8(-1)
+	for i, c := range p.classes {
+		if isRemovedByX9(c) {
+			continue
+		}
+		if p.levels[i] != currentLevel && len(current) > 0 {
+			runs = append(runs, current)
+			current = nil
+		}
+		current = append(current, i)
+		currentLevel = p.levels[i]
+	}
+	if len(current) > 0 {
+		runs = append(runs, current)
+	}
+
+	runOf := make(map[int]int)
+	for k, run := range runs {
+		runOf[run[0]] = k
+	}
+
+	var sequences []*bidiRunSequence
+	for _, run := range runs {
+		first := run[0]
+		if p.classes[first] == bidi.PDI && p.matchingInitiator[first] >= 0 {
+			// the run continues the sequence of its isolate initiator
+			continue
+		}
+		var indexes []int
+		for {
+			indexes = append(indexes, run...)
+			last := run[len(run)-1]
+			if !isIsolateInitiator(p.classes[last]) || p.matchingPDI[last] >= len(p.runes) {
+				break
+			}
+			k, ok := runOf[p.matchingPDI[last]]
+			if !ok {
+				break
+			}
+			run = runs[k]
+		}
+		sequences = append(sequences, p.newRunSequence(indexes))
+	}
+
+	return sequences
+}
+
+// newRunSequence returns the isolating run sequence of the runes at the given indexes, with its sos and eos.
+func (p *bidiParagraph) newRunSequence(indexes []int) *bidiRunSequence {
+	seq := &bidiRunSequence{p: p, indexes: indexes, level: p.levels[indexes[0]]}
+
+	prevLevel := p.level
+	for i := indexes[0] - 1; i >= 0; i-- {
+		if !isRemovedByX9(p.classes[i]) {
+			prevLevel = p.levels[i]
+			break
+		}
+	}
+	last := indexes[len(indexes)-1]
+	nextLevel := p.level
+	if !isIsolateInitiator(p.classes[last]) {
+		for i := last + 1; i < len(p.runes); i++ {
+			if !isRemovedByX9(p.classes[i]) {
+				nextLevel = p.levels[i]
+				break
+			}
+		}
+	}
+
+	seq.sos = directionOf(max(prevLevel, seq.level))
+	seq.eos = directionOf(max(nextLevel, seq.level))
+
+	return seq
+}
+
+// directionOf returns the strong class of the direction of an embedding level.
+func directionOf(level int8) bidi.Class {
+	if level%2 == 1 {
+		return bidi.R
+	}
+
+	return bidi.L
+}
+
+// typeAt returns the resolved class of the k-th rune of the sequence.
+func (s *bidiRunSequence) typeAt(k int) bidi.Class {
+	return s.p.types[s.indexes[k]]
+}
+
+// setType sets the resolved class of the k-th rune of the sequence.
+func (s *bidiRunSequence) setType(k int, c bidi.Class) {
+	s.p.types[s.indexes[k]] = c
+}
+
+// resolveWeakTypes resolves European and Arabic numbers, separators, terminators and nonspacing marks, W1 to W7.
+func (s *bidiRunSequence) resolveWeakTypes() {
+	n := len(s.indexes)
+
+	// W1: nonspacing marks take the class of the previous character
+	prev := s.sos
+	for k := 0; k < n; k++ {
+		t := s.typeAt(k)
+		if t == bidi.NSM {
+			s.setType(k, prev)
+			t = prev
+		}
+		if isIsolateInitiator(t) || t == bidi.PDI {
+			prev = bidi.ON
+		} else {
+			prev = t
+		}
+	}
+
+	// W2 and W3: European numbers after Arabic letters are Arabic numbers, and Arabic letters are right to left
+	strong := s.sos
+	for k := 0; k < n; k++ {
+		switch t := s.typeAt(k); t {
+		case bidi.EN:
+			if strong == bidi.AL {
+				s.setType(k, bidi.AN)
+			}
+		case bidi.L, bidi.R, bidi.AL:
+			strong = t
+		}
+	}
+	for k := 0; k < n; k++ {
+		if s.typeAt(k) == bidi.AL {
+			s.setType(k, bidi.R)
+		}
+	}
+
+	// W4: a single separator between two numbers of the same kind takes their class
+	for k := 1; k < n-1; k++ {
+		t, before, after := s.typeAt(k), s.typeAt(k-1), s.typeAt(k+1)
+		if t == bidi.ES && before == bidi.EN && after == bidi.EN {
+			s.setType(k, bidi.EN)
+		} else if t == bidi.CS && before == after && (before == bidi.EN || before == bidi.AN) {
+			s.setType(k, before)
+		}
+	}
+
+	// W5: terminators next to European numbers are European numbers
+	for k := 0; k < n; k++ {
+		if s.typeAt(k) != bidi.ET {
+			continue
+		}
+		end := k
+		for end < n && s.typeAt(end) == bidi.ET {
+			end++
+		}
+		if k > 0 && s.typeAt(k-1) == bidi.EN || end < n && s.typeAt(end) == bidi.EN {
+			for j := k; j < end; j++ {
+				s.setType(j, bidi.EN)
+			}
+		}
+		k = end
+	}
+
+	// W6: the remaining separators and terminators are neutral
+	for k := 0; k < n; k++ {
+		switch s.typeAt(k) {
+		case bidi.ES, bidi.ET, bidi.CS:
+			s.setType(k, bidi.ON)
+		}
+	}
+
+	// W7: European numbers after left to right text are left to right
+	strong = s.sos
+	for k := 0; k < n; k++ {
+		switch t := s.typeAt(k); t {
+		case bidi.EN:
+			if strong == bidi.L {
+				s.setType(k, bidi.L)
+			}
+		case bidi.L, bidi.R:
+			strong = t
+		}
+	}
+}
+
+// strongOf returns the strong direction of a resolved class for the neutral rules, or ON for a neutral.
+func strongOf(c bidi.Class) bidi.Class {
+	switch c {
+	case bidi.L:
+		return bidi.L
+	case bidi.R, bidi.EN, bidi.AN:
+		return bidi.R
+	}
+
+	return bidi.ON
+}
+
+// resolvePairedBrackets resolves the pairs of brackets to the direction of their content or context, N0.
+func (s *bidiRunSequence) resolvePairedBrackets() {
+	type pair struct{ open, close int }
+	var pairs []pair
+	type opener struct {
+		k     int
+		close rune
+	}
+	var stack []opener
+	for k, i := range s.indexes {
+		if s.typeAt(k) != bidi.ON {
+			continue
+		}
+		r := s.p.runes[i]
+		if close, ok := bidiBrackets[r]; ok {
+			if len(stack) == 63 {
+				break
+			}
+			stack = append(stack, opener{k, close})
+			continue
+		}
+		for j := len(stack) - 1; j >= 0; j-- {
+			if stack[j].close == r || stack[j].close == '〉' && r == '〉' || stack[j].close == '〉' && r == '〉' {
+				pairs = append(pairs, pair{stack[j].k, k})
+				stack = stack[:j]
+				break
+			}
+		}
+	}
+	for i := 1; i < len(pairs); i++ {
+		for j := i; j > 0 && pairs[j].open < pairs[j-1].open; j-- {
+			pairs[j], pairs[j-1] = pairs[j-1], pairs[j]
+		}
+	}
+
+	embedding := directionOf(s.level)
+	for _, pr := range pairs {
+		found := bidi.ON
+		for k := pr.open + 1; k < pr.close; k++ {
+			switch d := strongOf(s.typeAt(k)); d {
+			case embedding:
+				found = d
+			case bidi.L, bidi.R:
+				if found == bidi.ON {
+					found = d
+				}
+			}
+			if found == embedding {
+				break
+			}
+		}
+		if found == bidi.ON {
+			continue
+		}
+		if found != embedding {
+			// the content is opposite to the embedding: use the context before the brackets if it agrees
+			context := s.sos
+			for k := pr.open - 1; k >= 0; k-- {
+				if d := strongOf(s.typeAt(k)); d != bidi.ON {
+					context = d
+					break
+				}
+			}
+			if context != found {
+				found = embedding
+			}
+		}
+		s.setType(pr.open, found)
+		s.setType(pr.close, found)
+		// nonspacing marks after the brackets follow them
+		for _, k := range []int{pr.open, pr.close} {
+			for j := k + 1; j < len(s.indexes) && s.p.classes[s.indexes[j]] == bidi.NSM; j++ {
+				s.setType(j, found)
+			}
+		}
+	}
+}
+
+// resolveNeutralTypes resolves the neutrals between strong characters, N1 and N2.
+func (s *bidiRunSequence) resolveNeutralTypes() {
+	n := len(s.indexes)
+	isNeutral := func(c bidi.Class) bool {
+		switch c {
+		case bidi.B, bidi.S, bidi.WS, bidi.ON, bidi.LRI, bidi.RLI, bidi.FSI, bidi.PDI:
+			return true
+		}
+		return false
+	}
+
+	for k := 0; k < n; k++ {
+		if !isNeutral(s.typeAt(k)) {
+			continue
+		}
+		end := k
+		for end < n && isNeutral(s.typeAt(end)) {
+			end++
+		}
+
+		before := s.sos
+		if k > 0 {
+			before = strongOf(s.typeAt(k - 1))
+		}
+		after := s.eos
+		if end < n {
+			after = strongOf(s.typeAt(end))
+		}
+		resolved := directionOf(s.level)
+		if before == after && before != bidi.ON {
+			resolved = before
+		}
+		for j := k; j < end; j++ {
+			s.setType(j, resolved)
+		}
+		k = end
+	}
+}
+
+// resolveImplicitLevels raises the levels of the characters against the direction of their embedding, I1 and I2.
+func (s *bidiRunSequence) resolveImplicitLevels() {
+	for k, i := range s.indexes {
+		t := s.typeAt(k)
+		level := s.p.levels[i]
+		if level%2 == 0 {
+			switch t {
+			case bidi.R:
+				level++
+			case bidi.AN, bidi.EN:
+				level += 2
+			}
+		} else if t == bidi.L || t == bidi.EN || t == bidi.AN {
+			level++
+		}
+		s.p.levels[i] = level
+	}
+}
+
+// resetWhitespaceLevels resets separators and the whitespace before them and at the end of the line to the paragraph
+// level, L1, and gives the characters removed by X9 the level of the character before them.
+func (p *bidiParagraph) resetWhitespaceLevels() {
+	for i, c := range p.classes {
+		if isRemovedByX9(c) {
+			if i > 0 {
+				p.levels[i] = p.levels[i-1]
+			} else {
+				p.levels[i] = p.level
+			}
+		}
+	}
+
+	trailing := true
+	for i := len(p.classes) - 1; i >= 0; i-- {
+		switch c := p.classes[i]; {
+		case c == bidi.B || c == bidi.S:
+			p.levels[i] = p.level
+			trailing = true
+		case c == bidi.WS || isIsolateInitiator(c) || c == bidi.PDI || isRemovedByX9(c):
+			if trailing {
+				p.levels[i] = p.level
+			}
+		default:
+			trailing = false
+		}
+	}
+}
+
+// visualOrder returns the indexes of runes in the order they are displayed, from left to right, given their embedding
+// levels, L2.
+func visualOrder(levels []int8) []int {
+	order := make([]int, len(levels))
+	highest, lowestOdd := int8(0), int8(bidiMaxDepth+2)
+	for i, level := range levels {
+		order[i] = i
+		highest = max(highest, level)
+		if level%2 == 1 {
+			lowestOdd = min(lowestOdd, level)
+		}
+	}
+
+	for level := highest; level >= lowestOdd; level-- {
+		for i := 0; i < len(levels); i++ {
+			if levels[order[i]] < level {
+				continue
+			}
+			j := i
+			for j < len(levels) && levels[order[j]] >= level {
+				j++
+			}
+			for a, b := i, j-1; a < b; a, b = a+1, b-1 {
+				order[a], order[b] = order[b], order[a]
+			}
+			i = j
+		}
+	}
+
+	return order
+}
+
+// bidiBrackets maps the opening paired brackets of BidiBrackets.txt to their closing brackets.
+var bidiBrackets = map[rune]rune{
+	'(': ')', '[': ']', '{': '}', '༺': '༻', '༼': '༽', '᚛': '᚜', '⁅': '⁆',
+	'⁽': '⁾', '₍': '₎', '⌈': '⌉', '⌊': '⌋', '〈': '〉',
+	'❨': '❩', '❪': '❫', '❬': '❭', '❮': '❯', '❰': '❱',
+	'❲': '❳', '❴': '❵', '⟅': '⟆', '⟦': '⟧', '⟨': '⟩',
+	'⟪': '⟫', '⟬': '⟭', '⟮': '⟯', '⦃': '⦄', '⦅': '⦆',
+	'⦇': '⦈', '⦉': '⦊', '⦋': '⦌', '⦍': '⦐', '⦏': '⦎',
+	'⦑': '⦒', '⦓': '⦔', '⦕': '⦖', '⦗': '⦘', '⧘': '⧙',
+	'⧚': '⧛', '⧼': '⧽', '⸢': '⸣', '⸤': '⸥', '⸦': '⸧',
+	'⸨': '⸩', '〈': '〉', '《': '》', '「': '」', '『': '』',
+	'【': '】', '〔': '〕', '〖': '〗', '〘': '〙', '〚': '〛',
+	'﹙': '﹚', '﹛': '﹜', '﹝': '﹞', '（': '）', '［': '］',
+	'｛': '｝', '｟': '｠', '｢': '｣',
+}
+
+// canonicalBracket returns the canonical equivalent of the angle brackets that have one, so that they pair with each
+// other.
+func canonicalBracket(r rune) rune {
+	switch r {
+	case '\u2329':
+		return '\u3008'
+	case '\u232a':
+		return '\u3009'
+	}
+
+	return r
+}
+
+// bidiMirror returns the mirrored form of a rune drawn right to left, L4, for the common mirrored characters.
+func bidiMirror(r rune) rune {
+	if m, ok := bidiMirrors[r]; ok {
+		return m
+	}
+
+	return r
+}
+
+// bidiMirrors maps the common characters of BidiMirroring.txt to their mirrored forms.
+var bidiMirrors = func() map[rune]rune {
+	m := map[rune]rune{
+		'<': '>', '>': '<', '«': '»', '»': '«', '‹': '›', '›': '‹',
+		'≤': '≥', '≥': '≤', '∈': '∋', '∋': '∈', '⊂': '⊃',
+		'⊃': '⊂', '⊆': '⊇', '⊇': '⊆',
+	}
+	for open, close := range bidiBrackets {
+		m[open] = close
+		m[close] = open
+	}
+
+	return m
+}()
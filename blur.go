@@ -0,0 +1,107 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"image"
+	"math"
+)
+
+// BlurRGBA returns a copy of an image blurred with an approximation of a Gaussian blur.
+//
+// Like the shadowBlur of the HTML canvas, the standard deviation of the Gaussian is half the radius. The blur is
+// approximated with three successive box blurs, and pixels outside the image are treated as transparent.
+func BlurRGBA(im *image.RGBA, radius float64) *image.RGBA {
+	b := im.Bounds()
+	dst := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		copy(dst.Pix[dst.PixOffset(b.Min.X, y):], im.Pix[im.PixOffset(b.Min.X, y):im.PixOffset(b.Max.X, y)])
+	}
+	blurPix(dst.Pix, b.Dx(), b.Dy(), dst.Stride, 4, radius)
+
+	return dst
+}
+
+// BlurAlpha returns a copy of an alpha mask blurred with an approximation of a Gaussian blur, like BlurRGBA.
+func BlurAlpha(im *image.Alpha, radius float64) *image.Alpha {
+	b := im.Bounds()
+	dst := image.NewAlpha(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		copy(dst.Pix[dst.PixOffset(b.Min.X, y):], im.Pix[im.PixOffset(b.Min.X, y):im.PixOffset(b.Max.X, y)])
+	}
+	blurPix(dst.Pix, b.Dx(), b.Dy(), dst.Stride, 1, radius)
+
+	return dst
+}
+
+// blurPix blurs pixel data in place with three horizontal and vertical box blurs, one channel at a time.
+func blurPix(pix []uint8, w, h, stride, channels int, radius float64) {
+	if radius <= 0 || w == 0 || h == 0 {
+		return
+	}
+
+	buf := make([]int, max(w, h))
+	for _, size := range gaussianBoxes(radius/2, 3) {
+		r := size / 2
+		if r == 0 {
+			continue
+		}
+		for c := 0; c < channels; c++ {
+			for y := 0; y < h; y++ {
+				boxBlur(pix, y*stride+c, channels, w, r, buf)
+			}
+			for x := 0; x < w; x++ {
+				boxBlur(pix, x*channels+c, stride, h, r, buf)
+			}
+		}
+	}
+}
+
+// gaussianBoxes returns the widths of n successive box blurs that approximate a Gaussian blur with standard deviation sigma.
+//
+// See http://www.peterkovesi.com/papers/FastGaussianSmoothing.pdf.
+func gaussianBoxes(sigma float64, n int) []int {
+	ideal := math.Sqrt(12*sigma*sigma/float64(n) + 1)
+	wl := int(math.Floor(ideal))
+	if wl%2 == 0 {
+		wl--
+	}
+	wu := wl + 2
+	m := int(math.Round((12*sigma*sigma - float64(n*wl*wl+4*n*wl+3*n)) / float64(-4*wl-4)))
+
+	sizes := make([]int, n)
+	for i := range sizes {
+		if i < m {
+			sizes[i] = wl
+		} else {
+			sizes[i] = wu
+		}
+	}
+
+	return sizes
+}
+
+// boxBlur replaces n values of pix, starting at start and step apart, with their average over a window of 2r+1 values,
+// using a running sum. Values outside the line count as zero.
+func boxBlur(pix []uint8, start, step, n, r int, buf []int) {
+	for i := 0; i < n; i++ {
+		buf[i] = int(pix[start+i*step])
+	}
+
+	size := 2*r + 1
+	sum := 0
+	for i := 0; i < r && i < n; i++ {
+		sum += buf[i]
+	}
+	for i := 0; i < n; i++ {
+		if j := i + r; j < n {
+			sum += buf[j]
+		}
+		if j := i - r - 1; j >= 0 {
+			sum -= buf[j]
+		}
+		pix[start+i*step] = uint8((sum + size/2) / size)
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"math"
+	"sort"
+)
+
+// booleanOp identifies a boolean operation between the areas of two paths.
+type booleanOp int
+
+const (
+	booleanUnion        booleanOp = iota // Inside either path.
+	booleanIntersection                  // Inside both paths.
+	booleanDifference                    // Inside the first path but not the second.
+	booleanXor                           // Inside exactly one of the paths.
+)
+
+// booleanSnap is the grid that the vertices of a boolean operation are snapped to, so that the intersection points
+// computed for two crossing edges end up as the same vertex.
+const booleanSnap = 1e-6
+
+// booleanEdge is a straight edge of a flattened operand.
+type booleanEdge struct {
+	p, q    Point
+	operand int
+}
+
+// Union returns the area covered by either p or q, both interpreted with the given fill rule.
+//
+// Like the other boolean operations, curves are flattened first, so the result is made of straight lines only.
+// Its subpaths never cross each other and are oriented so that it can be filled with either fill rule.
+func (p Path) Union(q Path, fillRule FillRule) Path {
+	return combinePaths(p, q, booleanUnion, fillRule)
+}
+
+// Intersect returns the area covered by both p and q, both interpreted with the given fill rule.
+func (p Path) Intersect(q Path, fillRule FillRule) Path {
+	return combinePaths(p, q, booleanIntersection, fillRule)
+}
+
+// Difference returns the area covered by p but not by q, both interpreted with the given fill rule.
+func (p Path) Difference(q Path, fillRule FillRule) Path {
+	return combinePaths(p, q, booleanDifference, fillRule)
+}
+
+// Xor returns the area covered by exactly one of p and q, both interpreted with the given fill rule.
+func (p Path) Xor(q Path, fillRule FillRule) Path {
+	return combinePaths(p, q, booleanXor, fillRule)
+}
+
+// combinePaths computes a boolean operation between two paths.
+//
+// Both operands are flattened into closed polygons and their edges are split at every intersection. Each resulting
+// edge is kept if the result is inside on exactly one of its sides, oriented with the inside on its left, and the
+// kept edges are finally linked back into closed subpaths.
+func combinePaths(a, b Path, op booleanOp, fillRule FillRule) Path {
+	edges := booleanEdges(a, 0)
+	edges = append(edges, booleanEdges(b, 1)...)
+
+	inside := func(pt Point) bool {
+		var wa, wb int
+		for _, e := range edges {
+			w := windingContribution(e, pt)
+			if e.operand == 0 {
+				wa += w
+			} else {
+				wb += w
+			}
+		}
+		ia, ib := fillRuleInside(wa, fillRule), fillRuleInside(wb, fillRule)
+		switch op {
+		case booleanIntersection:
+			return ia && ib
+		case booleanDifference:
+			return ia && !ib
+		case booleanXor:
+			return ia != ib
+		default:
+			return ia || ib
+		}
+	}
+
+	var kept []booleanEdge
+	for _, e := range splitEdges(edges) {
+		d := Point{e.q.X - e.p.X, e.q.Y - e.p.Y}
+		l := math.Hypot(d.X, d.Y)
+		eps := math.Min(l*0.01, 1e-3)
+		n := Point{-d.Y / l * eps, d.X / l * eps}
+		m := e.p.Interpolate(e.q, 0.5)
+		left := inside(Point{m.X + n.X, m.Y + n.Y})
+		right := inside(Point{m.X - n.X, m.Y - n.Y})
+		switch {
+		case left && !right:
+			kept = append(kept, e)
+		case right && !left:
+			kept = append(kept, booleanEdge{p: e.q, q: e.p})
+		}
+	}
+
+	return linkEdges(kept)
+}
+
+// booleanEdges flattens a path into the edges of closed polygons, tagged with the operand they belong to.
+func booleanEdges(path Path, operand int) []booleanEdge {
+	var result []booleanEdge
+
+	for _, points := range path.flatten() {
+		for i := range points {
+			p := snapPoint(points[i])
+			q := snapPoint(points[(i+1)%len(points)])
+			if p != q {
+				result = append(result, booleanEdge{p: p, q: q, operand: operand})
+			}
+		}
+	}
+
+	return result
+}
+
+// splitEdges splits edges at all the points where they cross or touch another edge and removes duplicates,
+// so that the resulting edges only meet at their end points.
+func splitEdges(edges []booleanEdge) []booleanEdge {
+	sorted := make([]int, len(edges))
+	for i := range sorted {
+		sorted[i] = i
+	}
+	minY := func(e booleanEdge) float64 { return math.Min(e.p.Y, e.q.Y) }
+	maxY := func(e booleanEdge) float64 { return math.Max(e.p.Y, e.q.Y) }
+	sort.Slice(sorted, func(i, j int) bool { return minY(edges[sorted[i]]) < minY(edges[sorted[j]]) })
+
+	splits := make([][]Point, len(edges))
+	for i, ei := range sorted {
+		a := edges[ei]
+		for _, ej := range sorted[i+1:] {
+			b := edges[ej]
+			if minY(b) > maxY(a) {
+				break
+			}
+			if math.Max(a.p.X, a.q.X) < math.Min(b.p.X, b.q.X) || math.Max(b.p.X, b.q.X) < math.Min(a.p.X, a.q.X) {
+				continue
+			}
+			for _, pt := range segmentIntersections(a.p, a.q, b.p, b.q) {
+				splits[ei] = append(splits[ei], pt)
+				splits[ej] = append(splits[ej], pt)
+			}
+		}
+	}
+
+	var result []booleanEdge
+	seen := make(map[[2]Point]bool)
+	for i, e := range edges {
+		d := Point{e.q.X - e.p.X, e.q.Y - e.p.Y}
+		along := func(pt Point) float64 { return (pt.X-e.p.X)*d.X + (pt.Y-e.p.Y)*d.Y }
+		points := append([]Point{e.p}, splits[i]...)
+		points = append(points, e.q)
+		sort.SliceStable(points, func(i, j int) bool { return along(points[i]) < along(points[j]) })
+		for j := 1; j < len(points); j++ {
+			p, q := points[j-1], points[j]
+			if p == q {
+				continue
+			}
+			key := [2]Point{p, q}
+			if q.X < p.X || q.X == p.X && q.Y < p.Y {
+				key = [2]Point{q, p}
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			result = append(result, booleanEdge{p: p, q: q, operand: e.operand})
+		}
+	}
+
+	return result
+}
+
+// segmentIntersections returns the snapped points where the segments p1-p2 and p3-p4 cross or touch,
+// including the end points of collinear overlapping segments.
+func segmentIntersections(p1, p2, p3, p4 Point) []Point {
+	r := Point{p2.X - p1.X, p2.Y - p1.Y}
+	s := Point{p4.X - p3.X, p4.Y - p3.Y}
+	w := Point{p3.X - p1.X, p3.Y - p1.Y}
+	d := cross(r, s)
+
+	if math.Abs(d) <= 1e-12*math.Sqrt(dot(r, r)*dot(s, s)) {
+		if math.Abs(cross(w, r)) > 1e-9*math.Sqrt(dot(r, r)) {
+			return nil
+		}
+		// collinear: every end point lying on the other segment is a split point
+		var result []Point
+		for _, c := range [][3]Point{{p3, p1, p2}, {p4, p1, p2}, {p1, p3, p4}, {p2, p3, p4}} {
+			u := Point{c[2].X - c[1].X, c[2].Y - c[1].Y}
+			t := dot(Point{c[0].X - c[1].X, c[0].Y - c[1].Y}, u) / dot(u, u)
+			if t > 0 && t < 1 {
+				result = append(result, c[0])
+			}
+		}
+		return result
+	}
+
+	t := cross(w, s) / d
+	u := cross(w, r) / d
+	if t < 0 || t > 1 || u < 0 || u > 1 {
+		return nil
+	}
+
+	return []Point{snapPoint(Point{p1.X + t*r.X, p1.Y + t*r.Y})}
+}
+
+// linkEdges links directed edges into closed subpaths. At vertices where more than one edge leaves, the edge
+// turning the most to the left is followed, which keeps subpaths that only touch at a vertex apart.
+func linkEdges(edges []booleanEdge) Path {
+	outgoing := make(map[Point][]int)
+	for i, e := range edges {
+		outgoing[e.p] = append(outgoing[e.p], i)
+	}
+
+	used := make([]bool, len(edges))
+	var result Path
+	for i := range edges {
+		if used[i] {
+			continue
+		}
+		used[i] = true
+		start := edges[i].p
+		result.MoveTo(start.X, start.Y)
+		e := edges[i]
+		for e.q != start {
+			result.LineTo(e.q.X, e.q.Y)
+			next := -1
+			best := math.Inf(-1)
+			din := Point{e.q.X - e.p.X, e.q.Y - e.p.Y}
+			for _, j := range outgoing[e.q] {
+				if used[j] {
+					continue
+				}
+				dout := Point{edges[j].q.X - edges[j].p.X, edges[j].q.Y - edges[j].p.Y}
+				if turn := math.Atan2(cross(din, dout), dot(din, dout)); turn > best {
+					best = turn
+					next = j
+				}
+			}
+			if next < 0 {
+				break
+			}
+			used[next] = true
+			e = edges[next]
+		}
+		result.Close()
+	}
+
+	return result
+}
+
+// windingContribution returns how much an edge adds to the winding number of a point, using a ray cast
+// towards positive x.
+func windingContribution(e booleanEdge, pt Point) int {
+	side := cross(Point{e.q.X - e.p.X, e.q.Y - e.p.Y}, Point{pt.X - e.p.X, pt.Y - e.p.Y})
+	if e.p.Y <= pt.Y && e.q.Y > pt.Y && side > 0 {
+		return 1
+	}
+	if e.q.Y <= pt.Y && e.p.Y > pt.Y && side < 0 {
+		return -1
+	}
+
+	return 0
+}
+
+// fillRuleInside reports whether a winding number is inside according to a fill rule.
+func fillRuleInside(winding int, fillRule FillRule) bool {
+	if fillRule == FillRuleEvenOdd {
+		return winding%2 != 0
+	}
+
+	return winding != 0
+}
+
+// snapPoint rounds a point to the boolean operation grid.
+func snapPoint(p Point) Point {
+	return Point{math.Round(p.X/booleanSnap) * booleanSnap, math.Round(p.Y/booleanSnap) * booleanSnap}
+}
+
+// cross returns the z component of the cross product of two vectors.
+func cross(a, b Point) float64 {
+	return a.X*b.Y - a.Y*b.X
+}
+
+// dot returns the dot product of two vectors.
+func dot(a, b Point) float64 {
+	return a.X*b.X + a.Y*b.Y
+}
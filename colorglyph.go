@@ -0,0 +1,376 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"sort"
+	"sync"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
+)
+
+// colorGlyphs holds the color glyph tables of a font: COLR layers painted with the colors of the first CPAL palette,
+// and PNG bitmaps embedded in CBDT or sbix strikes.
+type colorGlyphs struct {
+	colr      otData
+	palette   []color.NRGBA
+	cblc      otData
+	cbdt      otData
+	sbix      otData
+	numGlyphs int
+
+	mu      sync.Mutex
+	bitmaps map[colorBitmapKey]*colorBitmap
+}
+
+// colorLayer is a layer of a COLR glyph: the outline of a glyph filled with a color, or with the text color if the
+// color is nil.
+type colorLayer struct {
+	gid   uint16
+	color color.Color
+}
+
+// colorBitmap is a bitmap glyph of a strike, with the position of its top-left corner relative to the origin of the
+// glyph, in the pixels of the strike.
+type colorBitmap struct {
+	im   *image.RGBA
+	x, y float64
+	ppem float64
+}
+
+// colorBitmapKey identifies a bitmap glyph of a strike in the cache of the decoded bitmaps.
+type colorBitmapKey struct {
+	strike int
+	gid    uint16
+}
+
+// textColorIndex is the palette index of COLR layers that are painted with the text color.
+const textColorIndex = 0xffff
+
+// parseColorGlyphs reads the color glyph tables of a font, and returns nil if it has none.
+func parseColorGlyphs(tables map[string]otData, numGlyphs int) *colorGlyphs {
+	c := &colorGlyphs{numGlyphs: numGlyphs, bitmaps: make(map[colorBitmapKey]*colorBitmap)}
+	if colr, cpal := tables["COLR"], tables["CPAL"]; colr != nil && cpal != nil {
+		c.colr = colr
+		n, first := int(cpal.u16(2)), int(cpal.u16(12))
+		records := int(cpal.u32(8))
+		for i := 0; i < n; i++ {
+			at := records + 4*(first+i)
+			c.palette = append(c.palette, color.NRGBA{cpal.u8(at + 2), cpal.u8(at + 1), cpal.u8(at), cpal.u8(at + 3)})
+		}
+	}
+	if cblc, cbdt := tables["CBLC"], tables["CBDT"]; cblc != nil && cbdt != nil {
+		c.cblc, c.cbdt = cblc, cbdt
+	}
+	c.sbix = tables["sbix"]
+
+	if c.colr == nil && c.cblc == nil && c.sbix == nil {
+		return nil
+	}
+
+	return c
+}
+
+// layers returns the layers of a COLR glyph, from the bottom one, or nil if the glyph has no layers.
+func (c *colorGlyphs) layers(gid uint16) []colorLayer {
+	if c.colr == nil {
+		return nil
+	}
+
+	n := int(c.colr.u16(2))
+	bases, layers := int(c.colr.u32(4)), int(c.colr.u32(8))
+	i := sort.Search(n, func(i int) bool {
+		return c.colr.u16(bases+6*i) >= gid
+	})
+	if i == n || c.colr.u16(bases+6*i) != gid {
+		return nil
+	}
+
+	first, count := int(c.colr.u16(bases+6*i+2)), int(c.colr.u16(bases+6*i+4))
+	result := make([]colorLayer, 0, count)
+	for k := first; k < first+count; k++ {
+		at := layers + 4*k
+		l := colorLayer{gid: c.colr.u16(at)}
+		if index := int(c.colr.u16(at + 2)); index != textColorIndex && index < len(c.palette) {
+			l.color = c.palette[index]
+		}
+		result = append(result, l)
+	}
+
+	return result
+}
+
+// bitmap returns the bitmap of a glyph in the strike that best fits a size in pixels per em: the smallest one that
+// isn't smaller, or the largest one. It returns nil if the glyph has no bitmap.
+func (c *colorGlyphs) bitmap(gid uint16, ppem float64) *colorBitmap {
+	if c.cblc != nil {
+		if strike, ok := c.cblcStrike(gid, ppem); ok {
+			return c.cached(colorBitmapKey{strike, gid}, func() *colorBitmap {
+				return c.cbdtBitmap(strike, gid)
+			})
+		}
+	}
+	if c.sbix != nil {
+		if strike, ok := c.sbixStrike(gid, ppem); ok {
+			return c.cached(colorBitmapKey{-1 - strike, gid}, func() *colorBitmap {
+				return c.sbixBitmap(strike, gid, 0)
+			})
+		}
+	}
+
+	return nil
+}
+
+// cached returns a decoded bitmap, decoding it the first time it is asked for.
+func (c *colorGlyphs) cached(key colorBitmapKey, decode func() *colorBitmap) *colorBitmap {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, ok := c.bitmaps[key]
+	if !ok {
+		b = decode()
+		c.bitmaps[key] = b
+	}
+
+	return b
+}
+
+// bestStrike returns the index of the strike that best fits a size, given the sizes of the strikes, or -1 if there is
+// none. Strikes of size zero are ignored.
+func bestStrike(sizes []float64, ppem float64) int {
+	best := -1
+	for i, size := range sizes {
+		switch {
+		case size <= 0:
+		case best < 0:
+			best = i
+		case sizes[best] < ppem:
+			if size > sizes[best] {
+				best = i
+			}
+		case size >= ppem && size < sizes[best]:
+			best = i
+		}
+	}
+
+	return best
+}
+
+// cblcStrike returns the CBLC strike of a glyph that best fits a size.
+func (c *colorGlyphs) cblcStrike(gid uint16, ppem float64) (int, bool) {
+	sizes := make([]float64, c.cblc.u32(4))
+	for i := range sizes {
+		at := 8 + 48*i
+		if c.cblc.u16(at+40) <= gid && gid <= c.cblc.u16(at+42) {
+			sizes[i] = float64(c.cblc.u8(at + 45))
+		}
+	}
+	strike := bestStrike(sizes, ppem)
+
+	return strike, strike >= 0
+}
+
+// cbdtBitmap decodes the bitmap of a glyph in a CBLC strike, whose index subtables locate its data in CBDT.
+func (c *colorGlyphs) cbdtBitmap(strike int, gid uint16) *colorBitmap {
+	at := 8 + 48*strike
+	ppem := float64(c.cblc.u8(at + 45))
+	array := c.cblc[min(int(c.cblc.u32(at)), len(c.cblc)):]
+	for i := 0; i < int(c.cblc.u32(at+8)); i++ {
+		first, last := array.u16(8*i), array.u16(8*i+2)
+		if gid < first || gid > last {
+			continue
+		}
+		sub := array[min(int(array.u32(8*i+4)), len(array)):]
+		imageFormat, data := sub.u16(2), int(sub.u32(4))
+		k := int(gid - first)
+
+		// find the data of the glyph, and the metrics of the formats that keep them in the index
+		var start, end int
+		var metrics otData
+		switch sub.u16(0) {
+		case 1:
+			start, end = int(sub.u32(8+4*k)), int(sub.u32(12+4*k))
+		case 2:
+			size := int(sub.u32(8))
+			start, end = size*k, size*(k+1)
+			metrics = sub[min(12, len(sub)):]
+		case 3:
+			start, end = int(sub.u16(8+2*k)), int(sub.u16(10+2*k))
+		case 4:
+			n := int(sub.u32(8))
+			j := sort.Search(n, func(j int) bool {
+				return sub.u16(12+4*j) >= gid
+			})
+			if j == n || sub.u16(12+4*j) != gid {
+				return nil
+			}
+			start, end = int(sub.u16(14+4*j)), int(sub.u16(18+4*j))
+		case 5:
+			size, n := int(sub.u32(8)), int(sub.u32(20))
+			j := sort.Search(n, func(j int) bool {
+				return sub.u16(24+2*j) >= gid
+			})
+			if j == n || sub.u16(24+2*j) != gid {
+				return nil
+			}
+			start, end = size*j, size*(j+1)
+			metrics = sub[min(12, len(sub)):]
+		default:
+			return nil
+		}
+		if start < 0 || end <= start || data+end > len(c.cbdt) {
+			return nil
+		}
+		glyph := c.cbdt[data+start : data+end]
+
+		// the small and big metrics start with the height, width and the bearings of the bitmap
+		var pngData otData
+		switch imageFormat {
+		case 17:
+			metrics, pngData = glyph, glyph[min(9, len(glyph)):]
+		case 18:
+			metrics, pngData = glyph, glyph[min(12, len(glyph)):]
+		case 19:
+			pngData = glyph[min(4, len(glyph)):]
+		default:
+			return nil
+		}
+		if metrics == nil {
+			return nil
+		}
+		im := decodePNG(pngData)
+		if im == nil {
+			return nil
+		}
+
+		return &colorBitmap{im, float64(int8(metrics.u8(2))), -float64(int8(metrics.u8(3))), ppem}
+	}
+
+	return nil
+}
+
+// sbixStrike returns the sbix strike of a glyph that best fits a size.
+func (c *colorGlyphs) sbixStrike(gid uint16, ppem float64) (int, bool) {
+	sizes := make([]float64, c.sbix.u32(4))
+	for i := range sizes {
+		strike := c.sbix.offset32(8 + 4*i)
+		if int(gid) < c.numGlyphs && strike.u32(4+4*int(gid)) < strike.u32(8+4*int(gid)) {
+			sizes[i] = float64(strike.u16(0))
+		}
+	}
+	i := bestStrike(sizes, ppem)
+
+	return i, i >= 0
+}
+
+// sbixBitmap decodes the bitmap of a glyph in an sbix strike, following the glyphs it duplicates.
+func (c *colorGlyphs) sbixBitmap(i int, gid uint16, depth int) *colorBitmap {
+	strike := c.sbix.offset32(8 + 4*i)
+	if int(gid) >= c.numGlyphs || depth > 8 {
+		return nil
+	}
+	start, end := int(strike.u32(4+4*int(gid))), int(strike.u32(8+4*int(gid)))
+	if end <= start || end > len(strike) {
+		return nil
+	}
+	glyph := strike[start:end]
+
+	switch otTag(glyph.u32(4)) {
+	case makeTag("png "):
+		im := decodePNG(glyph[min(8, len(glyph)):])
+		if im == nil {
+			return nil
+		}
+		x, y := float64(glyph.i16(0)), float64(glyph.i16(2))
+
+		return &colorBitmap{im, x, -y - float64(im.Bounds().Dy()), float64(strike.u16(0))}
+	case makeTag("dupe"):
+		return c.sbixBitmap(i, glyph.u16(8), depth+1)
+	}
+
+	return nil
+}
+
+// decodePNG decodes a PNG image into an RGBA image, or returns nil if it can't be decoded.
+func decodePNG(data []byte) *image.RGBA {
+	im, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, im.Bounds().Dx(), im.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), im, im.Bounds().Min, draw.Src)
+
+	return rgba
+}
+
+// colorGlyphsOf returns the color glyph tables of the font of a face, or nil if it has none.
+func colorGlyphsOf(f *fontFace) *colorGlyphs {
+	return f.fontTables().colors
+}
+
+// drawColorGlyph draws a glyph of a shaped string with its colors, its COLR layers or its bitmap, and tells whether it
+// has any. Other glyphs are drawn by drawString with the color of the context.
+func (dc *Context) drawColorGlyph(im *image.RGBA, g shapedGlyph, dot fixed.Point26_6) bool {
+	f, ok := g.face.(*fontFace)
+	if !ok || g.gid == 0 {
+		return false
+	}
+	colors := colorGlyphsOf(f)
+	if colors == nil {
+		return false
+	}
+
+	gid := uint16(g.gid)
+	if layers := colors.layers(gid); layers != nil {
+		for _, l := range layers {
+			c := dc.color
+			if l.color != nil {
+				c = l.color
+			}
+			dr, mask, maskp, ok := f.glyph(dot, sfnt.GlyphIndex(l.gid))
+			if ok {
+				dc.drawGlyphMask(im, image.NewUniform(dc.withGlobalAlpha(c)), dr, mask, maskp)
+			}
+		}
+		return true
+	}
+
+	ppem := unfix(f.scale)
+	b := colors.bitmap(gid, ppem)
+	if b == nil {
+		return false
+	}
+
+	// the bitmap is scaled from the size of its strike to the size of the face, and resampled first when it shrinks
+	// much, which the interpolation of the transform alone doesn't smooth
+	k := ppem / b.ppem
+	src := b.im
+	if k < 0.5 {
+		w := max(1, int(math.Ceil(float64(src.Bounds().Dx())*k)))
+		h := max(1, int(math.Ceil(float64(src.Bounds().Dy())*k)))
+		src = image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.CatmullRom.Scale(src, src.Bounds(), b.im, b.im.Bounds(), draw.Src, nil)
+	}
+	kx := k * float64(b.im.Bounds().Dx()) / float64(src.Bounds().Dx())
+	ky := k * float64(b.im.Bounds().Dy()) / float64(src.Bounds().Dy())
+	m := dc.matrix.Translate(unfix(dot.X)+b.x*k, unfix(dot.Y)+b.y*k).Scale(kx, ky)
+	s2d := f64.Aff3{m.XX, m.XY, m.X0, m.YX, m.YY, m.Y0}
+	options := &draw.Options{}
+	if dc.globalAlpha < 1 {
+		options.SrcMask = image.NewUniform(color.Alpha16{uint16(dc.globalAlpha * 0xffff)})
+	}
+	dc.interp.Transform(im, s2d, src, src.Bounds(), draw.Over, options)
+
+	return true
+}
@@ -0,0 +1,239 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"image/color"
+	"math"
+)
+
+// ColorSpace specifies the color space in which gradients interpolate between their color stops.
+type ColorSpace int
+
+const (
+	ColorSpaceSRGB       ColorSpace = iota // Gamma-encoded sRGB components with 8-bit precision, the default.
+	ColorSpaceLinearSRGB                   // Linear-light sRGB components, which blend like light does.
+	ColorSpaceOKLab                        // The perceptual OKLab space, which avoids muddy and dark midpoints.
+	ColorSpaceOKLCH                        // The polar form of OKLab, which keeps colors saturated along the hue.
+	ColorSpaceHSL                          // Hue, saturation and lightness of sRGB.
+)
+
+// HueInterpolation specifies which way around the hue circle OKLCH and HSL interpolation goes, like in CSS.
+type HueInterpolation int
+
+const (
+	HueShorter    HueInterpolation = iota // The shorter arc between the hues, the default.
+	HueLonger                             // The longer arc between the hues.
+	HueIncreasing                         // The arc along which the hue increases.
+	HueDecreasing                         // The arc along which the hue decreases.
+)
+
+// colorInterpolation holds how a gradient interpolates between its color stops.
+type colorInterpolation struct {
+	space         ColorSpace
+	hue           HueInterpolation
+	premultiplied bool
+}
+
+// lerp interpolates between two colors at the position t, between 0 and 1.
+//
+// ColorSpaceSRGB uses colorLerp, unchanged, unless premultiplied is set. The other color spaces, and sRGB with
+// premultiplied set, interpolate with premultiplied alpha, like CSS, so that a transparent stop doesn't tint its
+// neighbors, and the hue of a color without chroma takes the hue of the other color.
+func (ci colorInterpolation) lerp(c0, c1 color.Color, t float64) color.Color {
+	if ci.straight() {
+		return colorLerp(c0, c1, t)
+	}
+
+	p0, a0 := ci.components(c0)
+	p1, a1 := ci.components(c1)
+
+	polar := ci.space == ColorSpaceOKLCH || ci.space == ColorSpaceHSL
+	if polar {
+		// a hue without chroma or saturation is missing, and takes the other hue
+		if p0[1] < 1e-6 {
+			p0[2] = p1[2]
+		} else if p1[1] < 1e-6 {
+			p1[2] = p0[2]
+		}
+		p0[2], p1[2] = fixupHues(p0[2], p1[2], ci.hue)
+	}
+
+	a := a0 + (a1-a0)*t
+	if a == 0 {
+		return color.Transparent
+	}
+
+	var p [3]float64
+	for k := range p {
+		if polar && k == 2 {
+			p[k] = p0[k] + (p1[k]-p0[k])*t
+			continue
+		}
+		p[k] = (p0[k]*a0 + (p1[k]*a1-p0[k]*a0)*t) / a
+	}
+
+	return ci.color(p, a)
+}
+
+// straight reports whether the interpolation is the default one of ColorSpaceSRGB, which uses colorLerp and is written
+// to vector output as it is.
+func (ci colorInterpolation) straight() bool {
+	return ci.space == ColorSpaceSRGB && !ci.premultiplied
+}
+
+// components returns the components of a color in the interpolation color space and its alpha. Hues are in degrees.
+func (ci colorInterpolation) components(c color.Color) ([3]float64, float64) {
+	n := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+	r, g, b := float64(n.R)/0xffff, float64(n.G)/0xffff, float64(n.B)/0xffff
+	a := float64(n.A) / 0xffff
+
+	switch ci.space {
+	case ColorSpaceLinearSRGB:
+		return [3]float64{srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)}, a
+	case ColorSpaceOKLab:
+		return linearToOKLab(srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)), a
+	case ColorSpaceOKLCH:
+		lab := linearToOKLab(srgbToLinear(r), srgbToLinear(g), srgbToLinear(b))
+		return [3]float64{lab[0], math.Hypot(lab[1], lab[2]), normalizeAngle(Degrees(math.Atan2(lab[2], lab[1])))}, a
+	case ColorSpaceHSL:
+		return rgbToHSL(r, g, b), a
+	}
+
+	return [3]float64{r, g, b}, a
+}
+
+// color converts components in the interpolation color space and an alpha back to a color.
+func (ci colorInterpolation) color(p [3]float64, a float64) color.Color {
+	var r, g, b float64
+	switch ci.space {
+	case ColorSpaceLinearSRGB:
+		r, g, b = linearToSRGB(p[0]), linearToSRGB(p[1]), linearToSRGB(p[2])
+	case ColorSpaceOKLab:
+		r, g, b = oklabToSRGB(p)
+	case ColorSpaceOKLCH:
+		h := Radians(p[2])
+		r, g, b = oklabToSRGB([3]float64{p[0], p[1] * math.Cos(h), p[1] * math.Sin(h)})
+	case ColorSpaceHSL:
+		r, g, b = hslToRGB(p[0], p[1], p[2])
+	default:
+		r, g, b = p[0], p[1], p[2]
+	}
+
+	unit := func(v float64) uint16 {
+		return uint16(math.Round(math.Max(0, math.Min(1, v)) * 0xffff))
+	}
+
+	return color.NRGBA64{R: unit(r), G: unit(g), B: unit(b), A: unit(a)}
+}
+
+// fixupHues adjusts two hues in degrees so that interpolating linearly between them follows the hue interpolation method.
+func fixupHues(h0, h1 float64, method HueInterpolation) (float64, float64) {
+	d := h1 - h0
+	switch method {
+	case HueShorter:
+		if d > 180 {
+			h0 += 360
+		} else if d < -180 {
+			h1 += 360
+		}
+	case HueLonger:
+		if d > 0 && d < 180 {
+			h0 += 360
+		} else if d > -180 && d <= 0 {
+			h1 += 360
+		}
+	case HueIncreasing:
+		if d < 0 {
+			h1 += 360
+		}
+	case HueDecreasing:
+		if d > 0 {
+			h0 += 360
+		}
+	}
+
+	return h0, h1
+}
+
+// srgbToLinear converts a gamma-encoded sRGB component to linear light.
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB converts a linear-light component to gamma-encoded sRGB.
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// linearToOKLab converts linear sRGB to OKLab, see https://bottosson.github.io/posts/oklab/.
+func linearToOKLab(r, g, b float64) [3]float64 {
+	l := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*b)
+	m := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*b)
+	s := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*b)
+
+	return [3]float64{
+		0.2104542553*l + 0.7936177850*m - 0.0040720468*s,
+		1.9779984951*l - 2.4285922050*m + 0.4505937099*s,
+		0.0259040371*l + 0.7827717662*m - 0.8086757660*s,
+	}
+}
+
+// oklabToSRGB converts OKLab to gamma-encoded sRGB. Colors outside of sRGB are clipped later.
+func oklabToSRGB(lab [3]float64) (r, g, b float64) {
+	l := lab[0] + 0.3963377774*lab[1] + 0.2158037573*lab[2]
+	m := lab[0] - 0.1055613458*lab[1] - 0.0638541728*lab[2]
+	s := lab[0] - 0.0894841775*lab[1] - 1.2914855480*lab[2]
+	l, m, s = l*l*l, m*m*m, s*s*s
+
+	r = 4.0767416621*l - 3.3077115913*m + 0.2309699292*s
+	g = -1.2684380046*l + 2.6097574011*m - 0.3413193965*s
+	b = -0.0041960863*l - 0.7034186147*m + 1.7076147010*s
+
+	return linearToSRGB(r), linearToSRGB(g), linearToSRGB(b)
+}
+
+// rgbToHSL converts sRGB to lightness, saturation and hue in degrees. The order matches the other polar space, OKLCH.
+func rgbToHSL(r, g, b float64) [3]float64 {
+	hi := math.Max(r, math.Max(g, b))
+	lo := math.Min(r, math.Min(g, b))
+	l := (hi + lo) / 2
+	d := hi - lo
+	if d == 0 {
+		return [3]float64{l, 0, 0}
+	}
+
+	s := d / (1 - math.Abs(2*l-1))
+	var h float64
+	switch hi {
+	case r:
+		h = math.Mod((g-b)/d, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+
+	return [3]float64{l, s, normalizeAngle(h * 60)}
+}
+
+// hslToRGB converts lightness, saturation and hue in degrees to sRGB.
+func hslToRGB(l, s, h float64) (r, g, b float64) {
+	f := func(n float64) float64 {
+		k := math.Mod(n+normalizeAngle(h)/30, 12)
+		a := s * math.Min(l, 1-l)
+		return l - a*math.Max(-1, math.Min(k-3, math.Min(9-k, 1)))
+	}
+
+	return f(0), f(8), f(4)
+}
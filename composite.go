@@ -0,0 +1,233 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// CompositeOperator specifies how drawing operations are combined with what is already on the context's image.
+//
+// The Porter-Duff operators follow the HTML canvas model: the shape is first drawn on its own onto a transparent layer,
+// which is then composited onto the whole image inside the clip. Operators that keep the destination only where the
+// source is painted, such as CompositeSourceIn or CompositeDestinationIn, therefore also clear the image outside the
+// shape. The blend modes combine the colors where both the source and the destination are painted and behave like
+// CompositeSourceOver elsewhere.
+type CompositeOperator int
+
+const (
+	CompositeSourceOver      CompositeOperator = iota // Source drawn over the destination, the default.
+	CompositeClear                                    // Destination cleared under the source.
+	CompositeSource                                   // Source only, the destination is ignored.
+	CompositeSourceIn                                 // Source where the destination is painted.
+	CompositeSourceOut                                // Source where the destination is not painted.
+	CompositeSourceAtop                               // Source over the destination, where the destination is painted.
+	CompositeDestination                              // Destination only, the source is ignored.
+	CompositeDestinationOver                          // Destination drawn over the source.
+	CompositeDestinationIn                            // Destination where the source is painted.
+	CompositeDestinationOut                           // Destination where the source is not painted.
+	CompositeDestinationAtop                          // Destination over the source, where the source is painted.
+	CompositeXor                                      // Source and destination where only one of them is painted.
+	CompositeLighter                                  // Source and destination added together.
+	CompositeMultiply                                 // Colors multiplied, which always darkens.
+	CompositeScreen                                   // Inverted colors multiplied, which always lightens.
+	CompositeOverlay                                  // Multiply or screen, depending on the destination.
+	CompositeDarken                                   // The darker of the colors.
+	CompositeLighten                                  // The lighter of the colors.
+	CompositeColorDodge                               // Destination brightened to reflect the source.
+	CompositeColorBurn                                // Destination darkened to reflect the source.
+	CompositeHardLight                                // Multiply or screen, depending on the source.
+	CompositeSoftLight                                // Softer version of hard light.
+	CompositeDifference                               // Absolute difference of the colors.
+	CompositeExclusion                                // Like difference, with lower contrast.
+)
+
+// blendModeNames are the CSS names of the blend modes, starting with CompositeMultiply.
+var blendModeNames = []string{
+	"multiply", "screen", "overlay", "darken", "lighten", "color-dodge", "color-burn",
+	"hard-light", "soft-light", "difference", "exclusion",
+}
+
+// SetCompositeOperator sets the operator used to combine Fill, Stroke, DrawString and DrawImage with the context's image.
+//
+// The default is CompositeSourceOver. The operator is saved and restored by Push and Pop. Vector output only supports the
+// blend modes, from CompositeMultiply on; the other Porter-Duff operators are recorded as CompositeSourceOver.
+func (dc *Context) SetCompositeOperator(op CompositeOperator) {
+	dc.compositeOp = op
+}
+
+// layered reports whether drawing operations have to be rendered through paintLayer, because of a composite operator
+// other than CompositeSourceOver or a shadow.
+func (dc *Context) layered() bool {
+	return dc.compositeOp != CompositeSourceOver || dc.hasShadow()
+}
+
+// paintLayer draws an operation that can't be painted directly onto the context's image. The paint function renders
+// the operation onto a transparent layer the size of the context, with source-over and without the clip. The layer's
+// shadow, if any, and then the layer itself are composited onto the context's image through the clip.
+func (dc *Context) paintLayer(paint func(layer *image.RGBA)) {
+	layer := image.NewRGBA(dc.im.Bounds())
+	paint(layer)
+	if dc.hasShadow() {
+		compositeImage(dc.im, dc.shadow(layer), dc.mask, dc.compositeOp)
+	}
+	compositeImage(dc.im, layer, dc.mask, dc.compositeOp)
+}
+
+// compositeImage composites src onto dst with an operator, weighted by mask if it is not nil. Both images must have
+// the same bounds.
+func compositeImage(dst, src *image.RGBA, mask *image.Alpha, op CompositeOperator) {
+	if op == CompositeSourceOver {
+		b := dst.Bounds()
+		if mask == nil {
+			draw.Draw(dst, b, src, b.Min, draw.Over)
+		} else {
+			draw.DrawMask(dst, b, src, b.Min, mask, b.Min, draw.Over)
+		}
+		return
+	}
+
+	// with these operators a transparent source leaves the destination unchanged
+	bounded := op != CompositeSource && op != CompositeSourceIn && op != CompositeSourceOut &&
+		op != CompositeDestinationIn && op != CompositeDestinationAtop
+
+	b := dst.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := dst.PixOffset(b.Min.X, y)
+		for x := b.Min.X; x < b.Max.X; x, i = x+1, i+4 {
+			if bounded && src.Pix[i+3] == 0 {
+				continue
+			}
+			m := 1.0
+			if mask != nil {
+				if m = float64(mask.AlphaAt(x, y).A) / 255; m == 0 {
+					continue
+				}
+			}
+
+			var s, d [4]float64
+			for k := range s {
+				s[k] = float64(src.Pix[i+k]) / 255
+				d[k] = float64(dst.Pix[i+k]) / 255
+			}
+			r := compositePixel(op, s, d)
+			for k := range r {
+				v := d[k] + (r[k]-d[k])*m
+				dst.Pix[i+k] = uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+			}
+		}
+	}
+}
+
+// compositePixel combines a premultiplied source color with a premultiplied destination color.
+func compositePixel(op CompositeOperator, s, d [4]float64) [4]float64 {
+	sa, da := s[3], d[3]
+
+	var fa, fb float64
+	switch op {
+	case CompositeClear:
+		fa, fb = 0, 1-sa
+	case CompositeSource:
+		fa, fb = 1, 0
+	case CompositeSourceIn:
+		fa, fb = da, 0
+	case CompositeSourceOut:
+		fa, fb = 1-da, 0
+	case CompositeSourceAtop:
+		fa, fb = da, 1-sa
+	case CompositeDestination:
+		fa, fb = 0, 1
+	case CompositeDestinationOver:
+		fa, fb = 1-da, 1
+	case CompositeDestinationIn:
+		fa, fb = 0, sa
+	case CompositeDestinationOut:
+		fa, fb = 0, 1-sa
+	case CompositeDestinationAtop:
+		fa, fb = 1-da, sa
+	case CompositeXor:
+		fa, fb = 1-da, 1-sa
+	case CompositeLighter:
+		fa, fb = 1, 1
+	case CompositeSourceOver:
+		fa, fb = 1, 1-sa
+	default:
+		// separable blend modes, see https://www.w3.org/TR/compositing-1/#blending
+		var r [4]float64
+		for k := 0; k < 3; k++ {
+			var cs, cb float64
+			if sa > 0 {
+				cs = s[k] / sa
+			}
+			if da > 0 {
+				cb = d[k] / da
+			}
+			r[k] = s[k]*(1-da) + d[k]*(1-sa) + sa*da*blend(op, cb, cs)
+		}
+		r[3] = sa + da - sa*da
+		return r
+	}
+
+	var r [4]float64
+	for k := range r {
+		r[k] = math.Min(1, s[k]*fa+d[k]*fb)
+	}
+
+	return r
+}
+
+// blend returns the result of a separable blend mode for a backdrop color component cb and a source color component cs.
+func blend(op CompositeOperator, cb, cs float64) float64 {
+	switch op {
+	case CompositeMultiply:
+		return cb * cs
+	case CompositeScreen:
+		return cb + cs - cb*cs
+	case CompositeOverlay:
+		return blend(CompositeHardLight, cs, cb)
+	case CompositeDarken:
+		return math.Min(cb, cs)
+	case CompositeLighten:
+		return math.Max(cb, cs)
+	case CompositeColorDodge:
+		if cb == 0 {
+			return 0
+		}
+		if cs >= 1 {
+			return 1
+		}
+		return math.Min(1, cb/(1-cs))
+	case CompositeColorBurn:
+		if cb >= 1 {
+			return 1
+		}
+		if cs <= 0 {
+			return 0
+		}
+		return 1 - math.Min(1, (1-cb)/cs)
+	case CompositeHardLight:
+		if cs <= 0.5 {
+			return cb * 2 * cs
+		}
+		return blend(CompositeScreen, cb, 2*cs-1)
+	case CompositeSoftLight:
+		if cs <= 0.5 {
+			return cb - (1-2*cs)*cb*(1-cb)
+		}
+		d := math.Sqrt(cb)
+		if cb <= 0.25 {
+			d = ((16*cb-12)*cb + 4) * cb
+		}
+		return cb + (2*cs-1)*(d-cb)
+	case CompositeDifference:
+		return math.Abs(cb - cs)
+	case CompositeExclusion:
+		return cb + cs - 2*cb*cs
+	}
+
+	return cs
+}
@@ -19,6 +19,7 @@ import (
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/basicfont"
 	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
 )
 
 // LineCap defines the possible line cap styles for drawing paths in a rendering context.
@@ -36,6 +37,7 @@ type LineJoin int
 const (
 	LineJoinRound LineJoin = iota // Line join style with round connections.
 	LineJoinBevel                 // Line join style with beveled connections.
+	LineJoinMiter                 // Line join style with sharp connections, beveled past the miter limit.
 )
 
 // FillRule specifies the fill rule for determining the interior of complex paths.
@@ -50,9 +52,10 @@ const (
 type Align int
 
 const (
-	AlignLeft   Align = iota // Left alignment for text rendering.
-	AlignCenter              // Center alignment for text rendering.
-	AlignRight               // Right alignment for text rendering.
+	AlignLeft    Align = iota // Left alignment for text rendering.
+	AlignCenter               // Center alignment for text rendering.
+	AlignRight                // Right alignment for text rendering.
+	AlignJustify              // Justified alignment for wrapped text, except for the last line of each paragraph.
 )
 
 // defaultFillStyle represents the default fill style used in the rendering context.
@@ -63,31 +66,43 @@ var defaultStrokeStyle = NewSolidPattern(color.Black)
 
 // Context represents a 2D rendering context used for drawing operations.
 type Context struct {
-	width         int
-	height        int
-	rasterizer    *raster.Rasterizer
-	im            *image.RGBA
-	mask          *image.Alpha
-	color         color.Color
-	fillPattern   Pattern
-	strokePattern Pattern
-	strokePath    raster.Path
-	fillPath      raster.Path
-	start         Point
-	current       Point
-	hasCurrent    bool
-	dashes        []float64
-	dashOffset    float64
-	lineWidth     float64
-	lineCap       LineCap
-	lineJoin      LineJoin
-	fillRule      FillRule
-	fontFace      font.Face
-	fontHeight    float64
-	matrix        Matrix
-	stack         []*Context
-	interp        draw.Interpolator
-	frames        []image.Image
+	width             int
+	height            int
+	rasterizer        *raster.Rasterizer
+	im                *image.RGBA
+	mask              *image.Alpha
+	color             color.Color
+	fillPattern       Pattern
+	strokePattern     Pattern
+	userSpacePatterns bool
+	strokePath        raster.Path
+	fillPath          raster.Path
+	path              Path
+	start             Point
+	current           Point
+	hasCurrent        bool
+	dashes            []float64
+	dashOffset        float64
+	lineWidth         float64
+	lineCap           LineCap
+	lineJoin          LineJoin
+	miterLimit        float64
+	fillRule          FillRule
+	compositeOp       CompositeOperator
+	globalAlpha       float64
+	shadowX           float64
+	shadowY           float64
+	shadowBlur        float64
+	shadowColor       color.Color
+	fontFace          font.Face
+	fontHeight        float64
+	writingMode       WritingMode
+	matrix            Matrix
+	stack             []*Context
+	interp            draw.Interpolator
+	frames            []image.Image
+	recorder          *recorder
+	clipChain         *recordClip
 }
 
 // NewContext creates a new rendering context with the specified width and height.
@@ -123,6 +138,8 @@ func NewContextForRGBA(im *image.RGBA) *Context {
 		fillPattern:   defaultFillStyle,
 		strokePattern: defaultStrokeStyle,
 		lineWidth:     1,
+		miterLimit:    10,
+		globalAlpha:   1,
 		fillRule:      FillRuleWinding,
 		fontFace:      basicfont.Face7x13,
 		fontHeight:    13,
@@ -228,8 +245,8 @@ func (dc *Context) SetLineCapSquare() {
 
 // SetLineJoin sets the line join style for the intersection of stroked lines.
 //
-// This method allows you to set the line join style for the intersections of stroked lines. You can choose from two predefined
-// styles: LineJoinRound and LineJoinBevel.
+// This method allows you to set the line join style for the intersections of stroked lines. You can choose from three predefined
+// styles: LineJoinRound, LineJoinBevel and LineJoinMiter.
 func (dc *Context) SetLineJoin(lineJoin LineJoin) {
 	dc.lineJoin = lineJoin
 }
@@ -248,6 +265,23 @@ func (dc *Context) SetLineJoinBevel() {
 	dc.lineJoin = LineJoinBevel
 }
 
+// SetLineJoinMiter sets the line join style to Miter for the intersection of stroked lines.
+//
+// This method sets the line join style to Miter for the intersections of stroked lines, which extends the outer edges of the
+// lines until they meet in a sharp corner. Corners sharper than the miter limit are beveled instead.
+func (dc *Context) SetLineJoinMiter() {
+	dc.lineJoin = LineJoinMiter
+}
+
+// SetMiterLimit sets the limit on the ratio of the miter length to the line width for miter joins.
+//
+// When two lines meet at a sharp angle, the miter can extend far beyond the line width. Joins whose miter would be longer
+// than limit times the line width are drawn as bevel joins instead, as in SVG and the HTML canvas. The default limit is 10,
+// which bevels corners sharper than about 11 degrees. Limits below 1 are treated as 1.
+func (dc *Context) SetMiterLimit(limit float64) {
+	dc.miterLimit = math.Max(limit, 1)
+}
+
 // SetFillRule sets the fill rule for determining the interior of filled shapes.
 //
 // This method allows you to set the fill rule for determining the interior of filled shapes. You can choose from two predefined
@@ -303,6 +337,24 @@ func (dc *Context) SetStrokeStyle(pattern Pattern) {
 	dc.strokePattern = pattern
 }
 
+// SetUserSpacePatterns sets whether fill and stroke patterns follow the current transformation matrix.
+//
+// By default, patterns are painted in device space and don't move with the shapes they fill when the context is
+// transformed. When enabled, like in cairo and the HTML canvas, each Fill and Stroke paints its pattern in the user space
+// in effect when it is called, after the pattern's own matrix. The setting is saved and restored by Push and Pop.
+func (dc *Context) SetUserSpacePatterns(enabled bool) {
+	dc.userSpacePatterns = enabled
+}
+
+// devicePattern returns the pattern p as it is painted in device space.
+func (dc *Context) devicePattern(p Pattern) Pattern {
+	if !dc.userSpacePatterns {
+		return p
+	}
+
+	return transformPattern(p, dc.matrix)
+}
+
 // SetColor sets the fill and stroke color of the rendering context to the specified color.
 //
 // This method sets both the fill and stroke colors of the rendering context to the specified color 'c'. The 'c' parameter
@@ -375,6 +427,7 @@ func (dc *Context) MoveTo(x, y float64) {
 	p := Point{x, y}
 	dc.strokePath.Start(p.Fixed())
 	dc.fillPath.Start(p.Fixed())
+	dc.path = append(dc.path, PathSegment{Op: PathMoveTo, Points: [3]Point{p}})
 	dc.start = p
 	dc.current = p
 	dc.hasCurrent = true
@@ -392,6 +445,7 @@ func (dc *Context) LineTo(x, y float64) {
 		p := Point{x, y}
 		dc.strokePath.Add1(p.Fixed())
 		dc.fillPath.Add1(p.Fixed())
+		dc.path = append(dc.path, PathSegment{Op: PathLineTo, Points: [3]Point{p}})
 		dc.current = p
 	}
 }
@@ -411,6 +465,7 @@ func (dc *Context) QuadraticTo(x1, y1, x2, y2 float64) {
 	p2 := Point{x2, y2}
 	dc.strokePath.Add2(p1.Fixed(), p2.Fixed())
 	dc.fillPath.Add2(p1.Fixed(), p2.Fixed())
+	dc.path = append(dc.path, PathSegment{Op: PathQuadTo, Points: [3]Point{p1, p2}})
 	dc.current = p2
 }
 
@@ -430,6 +485,7 @@ func (dc *Context) CubicTo(x1, y1, x2, y2, x3, y3 float64) {
 	x3, y3 = dc.TransformPoint(x3, y3)
 	points := CubicBezier(x0, y0, x1, y1, x2, y2, x3, y3)
 	previous := dc.current.Fixed()
+	dc.path = append(dc.path, PathSegment{Op: PathCubicTo, Points: [3]Point{{x1, y1}, {x2, y2}, {x3, y3}}})
 
 	for _, p := range points[1:] {
 		f := p.Fixed()
@@ -452,6 +508,7 @@ func (dc *Context) ClosePath() {
 	if dc.hasCurrent {
 		dc.strokePath.Add1(dc.start.Fixed())
 		dc.fillPath.Add1(dc.start.Fixed())
+		dc.path = append(dc.path, PathSegment{Op: PathClose})
 		dc.current = dc.start
 	}
 }
@@ -462,6 +519,7 @@ func (dc *Context) ClosePath() {
 func (dc *Context) ClearPath() {
 	dc.strokePath.Clear()
 	dc.fillPath.Clear()
+	dc.path = dc.path[:0]
 	dc.hasCurrent = false
 }
 
@@ -476,6 +534,66 @@ func (dc *Context) NewSubPath() {
 	dc.hasCurrent = false
 }
 
+// AppendPath appends the segments of a path to the current path.
+//
+// The path is interpreted in user space, so it is transformed by the current transformation matrix exactly as if its
+// segments had been issued with MoveTo, LineTo, QuadraticTo, CubicTo and ClosePath.
+func (dc *Context) AppendPath(p Path) {
+	for _, s := range p {
+		switch s.Op {
+		case PathMoveTo:
+			dc.MoveTo(s.Points[0].X, s.Points[0].Y)
+		case PathLineTo:
+			dc.LineTo(s.Points[0].X, s.Points[0].Y)
+		case PathQuadTo:
+			dc.QuadraticTo(s.Points[0].X, s.Points[0].Y, s.Points[1].X, s.Points[1].Y)
+		case PathCubicTo:
+			dc.CubicTo(s.Points[0].X, s.Points[0].Y, s.Points[1].X, s.Points[1].Y, s.Points[2].X, s.Points[2].Y)
+		case PathClose:
+			dc.ClosePath()
+		}
+	}
+}
+
+// CopyPath returns a copy of the current path in user space.
+//
+// The current path is kept in device space, so it is transformed back by the inverse of the current transformation
+// matrix. If that matrix is singular, the path is returned in device space.
+func (dc *Context) CopyPath() Path {
+	if m, ok := dc.matrix.Invert(); ok {
+		return dc.path.Transform(m)
+	}
+
+	return append(Path(nil), dc.path...)
+}
+
+// SetGlobalAlpha sets an opacity, between 0 and 1, that is multiplied into every fill, stroke, string and image drawn.
+//
+// The default is 1, which draws fully opaque. Like the rest of the drawing state, it is saved and restored by Push and Pop.
+func (dc *Context) SetGlobalAlpha(alpha float64) {
+	dc.globalAlpha = math.Max(0, math.Min(1, alpha))
+}
+
+// withGlobalAlpha returns the color c with the global alpha multiplied into it.
+func (dc *Context) withGlobalAlpha(c color.Color) color.Color {
+	if dc.globalAlpha >= 1 {
+		return c
+	}
+
+	r, g, b, a := c.RGBA()
+	k := dc.globalAlpha
+
+	return color.RGBA64{uint16(float64(r) * k), uint16(float64(g) * k), uint16(float64(b) * k), uint16(float64(a) * k)}
+}
+
+// newPatternPainter returns a patternPainter for the pattern p that respects the global alpha.
+func (dc *Context) newPatternPainter(im *image.RGBA, mask *image.Alpha, p Pattern) *patternPainter {
+	painter := newPatternPainter(im, mask, patternOver(p, im.Bounds()))
+	painter.alpha = uint32(dc.globalAlpha * 0xffff)
+
+	return painter
+}
+
 // capper returns the raster.Capper based on the current line cap style.
 //
 // This method returns a raster.Capper based on the current line cap style (LineCapButt, LineCapRound, or LineCapSquare).
@@ -495,7 +613,7 @@ func (dc *Context) capper() raster.Capper {
 
 // joiner returns the raster.Joiner based on the current line join style.
 //
-// This method returns a raster.Joiner based on the current line join style (LineJoinBevel or LineJoinRound).
+// This method returns a raster.Joiner based on the current line join style (LineJoinBevel, LineJoinRound or LineJoinMiter).
 // It is used in the stroke operation to determine how line segments are joined at corners.
 func (dc *Context) joiner() raster.Joiner {
 	switch dc.lineJoin {
@@ -503,32 +621,71 @@ func (dc *Context) joiner() raster.Joiner {
 		return raster.BevelJoiner
 	case LineJoinRound:
 		return raster.RoundJoiner
+	case LineJoinMiter:
+		return miterJoiner(dc.miterLimit)
 	}
 
 	return nil
 }
 
+// miterJoiner returns a raster.Joiner that adds miter joins, falling back to bevel joins when the ratio of the miter length
+// to the line width exceeds limit.
+func miterJoiner(limit float64) raster.Joiner {
+	return raster.JoinerFunc(func(lhs, rhs raster.Adder, halfWidth fixed.Int26_6, pivot, n0, n1 fixed.Point26_6) {
+		x0, y0 := unfix(n0.X), unfix(n0.Y)
+		x1, y1 := unfix(n1.X), unfix(n1.Y)
+		hw := unfix(halfWidth)
+		sx, sy := x0+x1, y0+y1
+		s2 := sx*sx + sy*sy
+
+		// The miter ratio is 1 / sin(phi / 2) for an angle phi between the lines, which is 2 * hw / |n0 + n1|.
+		if s2 > 0 && 4*hw*hw <= limit*limit*s2 {
+			k := 2 * hw * hw / s2
+			miter := fixp(sx*k, sy*k)
+			// The outer side of the corner is the side the path turns away from.
+			if x0*y1-y0*x1 >= 0 {
+				lhs.Add1(pivot.Add(miter))
+			} else {
+				rhs.Add1(pivot.Sub(miter))
+			}
+		}
+
+		lhs.Add1(pivot.Add(n1))
+		rhs.Add1(pivot.Sub(n1))
+	})
+}
+
 // stroke applies stroke painting to the current path.
 //
 // This method applies stroke painting to the current path using the specified painter. If a dash pattern is set, it applies
 // the dashed pattern to the path. It also uses the current line width, line cap, and line join styles for stroke rendering.
 // The resulting stroke is rendered by the painter.
 func (dc *Context) stroke(painter raster.Painter) {
-	path := dc.strokePath
-	if len(dc.dashes) > 0 {
-		path = dashed(path, dc.dashes, dc.dashOffset)
-	} else {
-		// TODO: this is a temporary workaround to remove tiny segments
-		// that result in rendering issues
-		path = rasterPath(flattenPath(path))
-	}
 	r := dc.rasterizer
 	r.UseNonZeroWinding = true
 	r.Clear()
-	r.AddStroke(path, fix(dc.lineWidth), dc.capper(), dc.joiner())
+	r.AddStroke(dc.strokeRasterPath(), fix(dc.lineWidth), dc.capper(), dc.joiner())
 	r.Rasterize(painter)
 }
 
+// strokeRasterPath returns the raster path that is stroked by stroke.
+//
+// The stroker caps both ends of every subpath, even closed ones. With miter joins, closed subpaths are restarted in the
+// middle of their first segment so that the corner at their starting point gets a miter too. Other strokes are
+// flattened as they always were, so that their output doesn't change.
+func (dc *Context) strokeRasterPath() raster.Path {
+	if len(dc.dashes) > 0 {
+		return dashed(dc.strokePath, dc.dashes, dc.dashOffset)
+	}
+	if dc.lineJoin == LineJoinMiter {
+		return rasterPath(dc.path.strokePolylines())
+	}
+
+	// TODO: this is a temporary workaround to remove tiny segments
+	// that result in rendering issues
+	return rasterPath(flattenPath(dc.strokePath))
+}
+
 // fill applies fill painting to the current path.
 //
 // This method applies fill painting to the current path using the specified painter. It takes into account the current fill
@@ -554,20 +711,30 @@ func (dc *Context) fill(painter raster.Painter) {
 // the appropriate painter based on the stroke pattern and the presence of a mask. It then calls the stroke method to
 // render the stroke. After the stroke is applied, the current path remains intact.
 func (dc *Context) StrokePreserve() {
+	pattern := dc.devicePattern(dc.strokePattern)
+	if dc.layered() {
+		dc.paintLayer(func(layer *image.RGBA) {
+			dc.stroke(dc.newPatternPainter(layer, nil, pattern))
+		})
+		dc.recordPath(recordStroke, pattern)
+		return
+	}
+
 	var painter raster.Painter
 	if dc.mask == nil {
-		if pattern, ok := dc.strokePattern.(*solidPattern); ok {
+		if solid, ok := pattern.(*solidPattern); ok {
 			// with a nil mask and a solid color pattern, we can be more efficient
 			// TODO: refactor so we don't have to do this type assertion stuff?
 			p := raster.NewRGBAPainter(dc.im)
-			p.SetColor(pattern.color)
+			p.SetColor(dc.withGlobalAlpha(solid.color))
 			painter = p
 		}
 	}
 	if painter == nil {
-		painter = newPatternPainter(dc.im, dc.mask, dc.strokePattern)
+		painter = dc.newPatternPainter(dc.im, dc.mask, pattern)
 	}
 	dc.stroke(painter)
+	dc.recordPath(recordStroke, pattern)
 }
 
 // Stroke applies the stroke operation to the current path and clears the path.
@@ -585,20 +752,30 @@ func (dc *Context) Stroke() {
 // the appropriate painter based on the fill pattern and the presence of a mask. It then calls the fill method to render
 // the fill. After the fill is applied, the current path remains intact.
 func (dc *Context) FillPreserve() {
+	pattern := dc.devicePattern(dc.fillPattern)
+	if dc.layered() {
+		dc.paintLayer(func(layer *image.RGBA) {
+			dc.fill(dc.newPatternPainter(layer, nil, pattern))
+		})
+		dc.recordPath(recordFill, pattern)
+		return
+	}
+
 	var painter raster.Painter
 	if dc.mask == nil {
-		if pattern, ok := dc.fillPattern.(*solidPattern); ok {
+		if solid, ok := pattern.(*solidPattern); ok {
 			// with a nil mask and a solid color pattern, we can be more efficient
 			// TODO: refactor so we don't have to do this type assertion stuff?
 			p := raster.NewRGBAPainter(dc.im)
-			p.SetColor(pattern.color)
+			p.SetColor(dc.withGlobalAlpha(solid.color))
 			painter = p
 		}
 	}
 	if painter == nil {
-		painter = newPatternPainter(dc.im, dc.mask, dc.fillPattern)
+		painter = dc.newPatternPainter(dc.im, dc.mask, pattern)
 	}
 	dc.fill(painter)
+	dc.recordPath(recordFill, pattern)
 }
 
 // Fill applies the fill operation to the current path and clears the path.
@@ -610,6 +787,81 @@ func (dc *Context) Fill() {
 	dc.ClearPath()
 }
 
+// InFill reports whether the point (x, y) lies inside the area that Fill would paint with the current path.
+//
+// The point is given in user space and the current fill rule is respected. The clip is not taken into account.
+func (dc *Context) InFill(x, y float64) bool {
+	x, y = dc.TransformPoint(x, y)
+
+	return dc.path.contains(Point{x, y}, dc.fillRule)
+}
+
+// InStroke reports whether the point (x, y) lies inside the area that Stroke would paint with the current path.
+//
+// The point is given in user space, and the current line width, line cap, line join and dashes are respected.
+// The clip is not taken into account.
+func (dc *Context) InStroke(x, y float64) bool {
+	x, y = dc.TransformPoint(x, y)
+
+	return dc.strokeOutline().contains(Point{x, y}, FillRuleWinding)
+}
+
+// FillExtents returns the bounding box of the area that Fill would paint with the current path.
+//
+// The box is given in user space, as its top-left (x1, y1) and bottom-right (x2, y2) corners. If the current
+// transformation is rotated or skewed, it is the user space box that encloses the device space extents.
+func (dc *Context) FillExtents() (x1, y1, x2, y2 float64) {
+	return dc.userExtents(dc.path)
+}
+
+// StrokeExtents returns the bounding box of the area that Stroke would paint with the current path.
+//
+// The box is given in user space like FillExtents, and takes the current line width, line cap, line join
+// and dashes into account.
+func (dc *Context) StrokeExtents() (x1, y1, x2, y2 float64) {
+	return dc.userExtents(dc.strokeOutline())
+}
+
+// strokeOutline returns the outline of the current stroke in device space, as a path to be filled with the
+// nonzero winding rule. It is computed exactly like the stroke that gets rasterized.
+func (dc *Context) strokeOutline() Path {
+	var outline raster.Path
+	raster.Stroke(&outline, dc.strokeRasterPath(), fix(dc.lineWidth), dc.capper(), dc.joiner())
+
+	var result Path
+	for _, points := range flattenPath(outline) {
+		for i, p := range points {
+			if i == 0 {
+				result.MoveTo(p.X, p.Y)
+			} else {
+				result.LineTo(p.X, p.Y)
+			}
+		}
+	}
+
+	return result
+}
+
+// userExtents returns the user space bounding box of a device space path.
+func (dc *Context) userExtents(path Path) (x1, y1, x2, y2 float64) {
+	if len(path) == 0 {
+		return 0, 0, 0, 0
+	}
+
+	x0, y0, x1, y1 := path.Bounds()
+	m, ok := dc.matrix.Invert()
+	if !ok {
+		return x0, y0, x1, y1
+	}
+
+	return Path{
+		{Op: PathMoveTo, Points: [3]Point{{x0, y0}}},
+		{Op: PathLineTo, Points: [3]Point{{x1, y0}}},
+		{Op: PathLineTo, Points: [3]Point{{x1, y1}}},
+		{Op: PathLineTo, Points: [3]Point{{x0, y1}}},
+	}.Transform(m).Bounds()
+}
+
 // ClipPreserve applies the clip operation to the current path, preserving the path for future rendering.
 //
 // This method applies the clip operation to the current path and preserves the path for future rendering. It creates a
@@ -626,6 +878,7 @@ func (dc *Context) ClipPreserve() {
 		draw.DrawMask(mask, mask.Bounds(), clip, image.Point{}, dc.mask, image.Point{}, draw.Over)
 		dc.mask = mask
 	}
+	dc.recordClipPath()
 }
 
 // SetMask sets the mask of the rendering context.
@@ -638,6 +891,7 @@ func (dc *Context) SetMask(mask *image.Alpha) error {
 	}
 
 	dc.mask = mask
+	dc.recordMask()
 
 	return nil
 }
@@ -666,6 +920,7 @@ func (dc *Context) InvertMask() {
 			dc.mask.Pix[i] = 255 - a
 		}
 	}
+	dc.recordMask()
 }
 
 // Clip applies the clip operation to the current path and clears the path.
@@ -683,6 +938,7 @@ func (dc *Context) Clip() {
 // clipping effects applied by previous Clip or ClipPreserve operations.
 func (dc *Context) ResetClip() {
 	dc.mask = nil
+	dc.clipChain = nil
 }
 
 // Clear sets the entire context's image to a uniform color, effectively clearing the rendering area.
@@ -693,6 +949,7 @@ func (dc *Context) ResetClip() {
 func (dc *Context) Clear() {
 	src := image.NewUniform(dc.color)
 	draw.Draw(dc.im, dc.im.Bounds(), src, image.Point{}, draw.Src)
+	dc.recordClear(dc.color)
 }
 
 // SetPixel sets the color of a single pixel at the specified coordinates.
@@ -701,6 +958,7 @@ func (dc *Context) Clear() {
 // It effectively paints a single pixel with the specified color.
 func (dc *Context) SetPixel(x, y int) {
 	dc.im.Set(x, y, dc.color)
+	dc.recordPixel(x, y, dc.color)
 }
 
 // DrawPoint draws a filled circle at the specified point.
@@ -857,13 +1115,27 @@ func (dc *Context) DrawImageAnchored(im image.Image, x, y int, ax, ay float64) {
 		s2d = f64.Aff3{m.XX, m.XY, m.X0, m.YX, m.YY, m.Y0}
 		opt *draw.Options
 	)
-	if dc.mask != nil {
+	if dc.globalAlpha < 1 {
 		opt = &draw.Options{
-			DstMask:  dc.mask,
-			DstMaskP: image.Point{},
+			SrcMask: image.NewUniform(color.Alpha16{A: uint16(dc.globalAlpha * 0xffff)}),
 		}
 	}
+	if dc.layered() {
+		dc.paintLayer(func(layer *image.RGBA) {
+			dc.interp.Transform(layer, s2d, im, im.Bounds(), draw.Over, opt)
+		})
+		dc.recordImage(im, m)
+		return
+	}
+	if dc.mask != nil {
+		if opt == nil {
+			opt = &draw.Options{}
+		}
+		opt.DstMask = dc.mask
+		opt.DstMaskP = image.Point{}
+	}
 	dc.interp.Transform(dc.im, s2d, im, im.Bounds(), draw.Over, opt)
+	dc.recordImage(im, m)
 }
 
 // SetFontFace sets the font face for text rendering.
@@ -871,7 +1143,7 @@ func (dc *Context) DrawImageAnchored(im image.Image, x, y int, ax, ay float64) {
 // This method sets the font face for rendering text. The provided `fontFace` is used for subsequent text operations. You can obtain a font face using the `LoadFontFace` or related methods.
 func (dc *Context) SetFontFace(fontFace font.Face) {
 	dc.fontFace = fontFace
-	dc.fontHeight = (float64(fontFace.Metrics().Height) / 64) * 72 / 96
+	dc.fontHeight = faceHeight(fontFace)
 }
 
 // LoadFontFace loads a font face from a file and sets it for text rendering.
@@ -883,8 +1155,7 @@ func (dc *Context) LoadFontFace(path string, points float64) error {
 		return err
 	}
 
-	dc.fontFace = face
-	dc.fontHeight = points * 72 / 96
+	dc.SetFontFace(face)
 
 	return nil
 }
@@ -898,8 +1169,7 @@ func (dc *Context) LoadFontFaceFromReader(r io.Reader, points float64) error {
 		return err
 	}
 
-	dc.fontFace = face
-	dc.fontHeight = points * 72 / 96
+	dc.SetFontFace(face)
 
 	return nil
 }
@@ -913,8 +1183,7 @@ func (dc *Context) LoadFontFaceFromFS(fsys fs.FS, path string, points float64) e
 		return err
 	}
 
-	dc.fontFace = face
-	dc.fontHeight = points * 72 / 96
+	dc.SetFontFace(face)
 
 	return nil
 }
@@ -928,15 +1197,14 @@ func (dc *Context) LoadFontFaceFromBytes(raw []byte, points float64) error {
 		return err
 	}
 
-	dc.fontFace = face
-	dc.fontHeight = points * 72 / 96
+	dc.SetFontFace(face)
 
 	return nil
 }
 
 // FontHeight returns the height of the currently set font face.
 //
-// This method returns the height of the font face currently set for text rendering. The height is measured in points.
+// This method returns the height of the font face currently set for text rendering, the same whether the face was loaded with LoadFontFace or set with SetFontFace: the size of the face in points times 72/96, or the height of the lines of faces that don't come from a font file, like bitmap faces. It is the line height of MeasureString and of wrapped text; use MeasureText for the ascent, descent and line gap of the face.
 func (dc *Context) FontHeight() float64 {
 	return dc.fontHeight
 }
@@ -945,38 +1213,38 @@ func (dc *Context) FontHeight() float64 {
 //
 // This method renders the given text string `s` onto the provided RGBA image `im` at the specified (x, y) coordinates. The text is rendered using the current font face, color, and other text rendering settings of the context.
 func (dc *Context) drawString(im *image.RGBA, s string, x, y float64) {
-	d := &font.Drawer{
-		Dst:  im,
-		Src:  image.NewUniform(dc.color),
-		Face: dc.fontFace,
-		Dot:  fixp(x, y),
-	}
-	// based on Drawer.DrawString() in golang.org/x/image/font/font.go
-	prevC := rune(-1)
-	for _, c := range s {
-		if prevC >= 0 {
-			d.Dot.X += d.Face.Kern(prevC, c)
+	dc.drawGlyphs(im, shapeString(dc.fontFace, s).glyphs, x, y)
+}
+
+// drawGlyphs draws shaped glyphs whose dots are relative to (x, y).
+func (dc *Context) drawGlyphs(im *image.RGBA, glyphs []shapedGlyph, x, y float64) {
+	src := image.NewUniform(dc.withGlobalAlpha(dc.color))
+	origin := fixp(x, y)
+	for _, g := range glyphs {
+		dot := origin.Add(g.dot)
+		if dc.drawColorGlyph(im, g, dot) {
+			continue
 		}
-		dr, mask, maskp, advance, ok := d.Face.Glyph(d.Dot, c)
+		dr, mask, maskp, ok := g.image(dot)
 		if !ok {
-			// TODO: is falling back on the U+FFFD glyph the responsibility of
-			// the Drawer or the Face?
-			// TODO: set prevC = '\ufffd'?
 			continue
 		}
-		sr := dr.Sub(dr.Min)
-		fx, fy := float64(dr.Min.X), float64(dr.Min.Y)
-		m := dc.matrix.Translate(fx, fy)
-		s2d := f64.Aff3{m.XX, m.XY, m.X0, m.YX, m.YY, m.Y0}
-		dc.interp.Transform(d.Dst, s2d, d.Src, sr, draw.Over, &draw.Options{
-			SrcMask:  mask,
-			SrcMaskP: maskp,
-		})
-		d.Dot.X += advance
-		prevC = c
+		dc.drawGlyphMask(im, src, dr, mask, maskp)
 	}
 }
 
+// drawGlyphMask draws the source through the mask of a glyph, which covers dr in user space.
+func (dc *Context) drawGlyphMask(im *image.RGBA, src image.Image, dr image.Rectangle, mask image.Image, maskp image.Point) {
+	sr := dr.Sub(dr.Min)
+	fx, fy := float64(dr.Min.X), float64(dr.Min.Y)
+	m := dc.matrix.Translate(fx, fy)
+	s2d := f64.Aff3{m.XX, m.XY, m.X0, m.YX, m.YY, m.Y0}
+	dc.interp.Transform(im, s2d, src, sr, draw.Over, &draw.Options{
+		SrcMask:  mask,
+		SrcMaskP: maskp,
+	})
+}
+
 // DrawString renders a text string at the specified coordinates.
 //
 // This method renders the given text string `s` at the specified (x, y) coordinates on the context's image. The text is rendered using the current font face, color, and other text rendering settings of the context. The (x, y) coordinates represent the baseline position for rendering the text.
@@ -986,66 +1254,210 @@ func (dc *Context) DrawString(s string, x, y float64) {
 
 // DrawStringAnchored renders a text string anchored at the specified coordinates.
 //
-// This method renders the given text string `s` anchored at the specified (x, y) coordinates on the context's image. The anchor point is determined by the `ax` (X-axis) and `ay` (Y-axis) values, which represent the relative position within the text bounding box. The text is rendered using the current font face, color, and other text rendering settings of the context.
-func (dc *Context) DrawStringAnchored(s string, x, y, ax, ay float64) {
-	w, h := dc.MeasureString(s)
-	x -= ax * w
-	y += ay * h
-	if dc.mask == nil {
-		dc.drawString(dc.im, s, x, y)
+// This method renders the given text string `s` anchored at the specified (x, y) coordinates on the context's image. The anchor point is determined by the `ax` (X-axis) and `ay` (Y-axis) values, which represent the relative position within the text bounding box. The optional `box` selects that box: by default the box of MeasureString, or the baseline, the tight box of the ink, or the em box, see TextBox. In vertical writing mode, the anchors are relative to the column and the boxes are turned with it: the box of MeasureString is the column, the baseline is the center line of the column, and the em box is as wide as the ascent and descent of the face. The text is rendered using the current font face, color, and other text rendering settings of the context.
+func (dc *Context) DrawStringAnchored(s string, x, y, ax, ay float64, box ...TextBox) {
+	b := TextBoxLine
+	if len(box) > 0 {
+		b = box[0]
+	}
+
+	if dc.vertical() {
+		col := shapeColumn(dc.fontFace, s)
+		x1, y1, x2, y2 := dc.columnBox(s, col, b)
+		x -= x1 + ax*(x2-x1)
+		y -= y1 + ay*(y2-y1)
+		dc.paintText(func(im *image.RGBA) {
+			dc.drawColumn(im, col, x, y)
+		})
+		dc.recordColumn(col, x, y)
+		return
+	}
+
+	x1, y1, x2, y2 := dc.textBox(s, b)
+	x -= x1 + ax*(x2-x1)
+	y += ay*(y2-y1) - y2
+	dc.paintText(func(im *image.RGBA) {
+		dc.drawString(im, s, x, y)
+	})
+	dc.recordText(s, x, y)
+}
+
+// paintText paints text drawn onto an image by the paint function onto the image of the context, through its layer
+// or its mask.
+func (dc *Context) paintText(paint func(im *image.RGBA)) {
+	if dc.layered() {
+		dc.paintLayer(paint)
+	} else if dc.mask == nil {
+		paint(dc.im)
 	} else {
 		im := image.NewRGBA(image.Rect(0, 0, dc.width, dc.height))
-		dc.drawString(im, s, x, y)
+		paint(im)
 		draw.DrawMask(dc.im, dc.im.Bounds(), im, image.Point{}, dc.mask, image.Point{}, draw.Over)
 	}
 }
 
 // DrawStringWrapped renders a text string wrapped within a specified width.
 //
-// This method renders the given text string `s` wrapped within the specified `width` while anchored at the (x, y) coordinates. The text is wrapped into multiple lines to fit the given width. The `ax` (X-axis) and `ay` (Y-axis) values determine the anchor point's relative position within the text bounding box, and the `lineSpacing` controls the vertical spacing between lines. The `align` parameter specifies the horizontal alignment of the text.
-func (dc *Context) DrawStringWrapped(s string, x, y, ax, ay, width, lineSpacing float64, align Align) {
-	lines := dc.WordWrap(s, width)
+// This method renders the given text string `s` wrapped within the specified `width` while anchored at the (x, y) coordinates. The text is wrapped into multiple lines to fit the given width. The `ax` (X-axis) and `ay` (Y-axis) values determine the anchor point's relative position within the text bounding box, and the `lineSpacing` controls the vertical spacing between lines. The `align` parameter specifies the horizontal alignment of the text, and the optional `options` set the paragraph spacing, first-line indent and maximum number of lines. It returns the layout of the text, see LayoutStringWrapped. In vertical writing mode, the text is broken into columns no taller than `width`, which stack from right to left, and the alignment places them from top to bottom.
+func (dc *Context) DrawStringWrapped(s string, x, y, ax, ay, width, lineSpacing float64, align Align, options ...WrapOptions) *TextLayout {
+	layout := dc.LayoutStringWrapped(s, x, y, ax, ay, width, lineSpacing, align, options...)
+
+	// lines are drawn from the top-left corner of their box, which is above the baseline of horizontal text
+	top := 1.0
+	if dc.vertical() {
+		top = 0
+	}
+	for _, line := range layout.Lines {
+		if line.WordSpacing == 0 {
+			dc.DrawStringAnchored(line.Text, line.X, line.Y, 0, top)
+			continue
+		}
+
+		// justified lines are shaped whole, and their gaps widened in display order
+		if dc.vertical() {
+			col := shapeColumn(dc.fontFace, line.Text)
+			col.spread(fix(line.WordSpacing))
+			x := line.X + dc.fontHeight/2
+			dc.paintText(func(im *image.RGBA) {
+				dc.drawColumn(im, col, x, line.Y)
+			})
+			dc.recordColumn(col, x, line.Y)
+			continue
+		}
+		shaped := shapeString(dc.fontFace, line.Text)
+		spreadWords(line.Text, shaped.glyphs, fix(line.WordSpacing))
+		y := line.Y + dc.fontHeight
+		dc.paintText(func(im *image.RGBA) {
+			dc.drawGlyphs(im, shaped.glyphs, line.X, y)
+		})
+		dc.recordWords(line.Text, shaped.glyphs, line.X, y)
+	}
+
+	return layout
+}
+
+// LayoutStringWrapped lays out a text string like DrawStringWrapped, without drawing it.
+//
+// This method returns the line boxes of the wrapped text, in user space, and its total height, which includes the paragraph spacing. When the text has more lines than `MaxLines`, the last line that is kept ends with the ellipsis, shortened to fit. Lines of a justified paragraph, except for its last line, are stretched to the available width by widening the gaps between their words. In vertical writing mode, the lines are the columns of the text, and the layout is `width` tall.
+func (dc *Context) LayoutStringWrapped(s string, x, y, ax, ay, width, lineSpacing float64, align Align, options ...WrapOptions) *TextLayout {
+	var opts WrapOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	if opts.Ellipsis == "" {
+		opts.Ellipsis = "\u2026"
+	}
+
+	type wrappedLine struct {
+		text      string
+		indent    float64
+		paragraph int
+		last      bool
+	}
+	m := dc.lineMeasurer()
+	var lines []wrappedLine
+	for i, paragraph := range strings.Split(s, "\n") {
+		if strings.TrimSpace(paragraph) == "" {
+			lines = append(lines, wrappedLine{indent: opts.Indent, paragraph: i, last: true})
+			continue
+		}
+		for j, line := range wrapParagraph(m, paragraph, width, opts) {
+			indent := 0.0
+			if j == 0 {
+				indent = opts.Indent
+			}
+			lines = append(lines, wrappedLine{text: lineText(line), indent: indent, paragraph: i})
+		}
+		lines[len(lines)-1].last = true
+	}
+
+	truncated := opts.MaxLines > 0 && len(lines) > opts.MaxLines
+	if truncated {
+		lines = lines[:opts.MaxLines]
+		last := &lines[len(lines)-1]
+		last.text = ellipsize(m, last.text, width-last.indent, opts.Ellipsis)
+		last.last = true
+	}
 
 	// sync h formula with MeasureMultilineString
 	h := float64(len(lines)) * dc.fontHeight * lineSpacing
 	h -= (lineSpacing - 1) * dc.fontHeight
+	h += float64(lines[len(lines)-1].paragraph) * opts.ParagraphSpacing
 
-	x -= ax * width
-	y -= ay * h
-	switch align {
-	case AlignLeft:
-		ax = 0
-	case AlignCenter:
-		ax = 0.5
-		x += width / 2
-	case AlignRight:
-		ax = 1
-		x += width
+	// vertical lines are laid out across first, from the origin, and turned into columns at the end
+	left, top := x-ax*h, y-ay*width
+	if dc.vertical() {
+		x, y = 0, 0
+	} else {
+		x -= ax * width
+		y -= ay * h
 	}
-	ay = 1
-	for _, line := range lines {
-		dc.DrawStringAnchored(line, x, y, ax, ay)
+	layout := &TextLayout{X: x, Y: y, Width: width, Height: h, Truncated: truncated}
+	for i, l := range lines {
+		if i > 0 && l.paragraph != lines[i-1].paragraph {
+			y += opts.ParagraphSpacing
+		}
+
+		w, _ := m.MeasureString(l.text)
+		line := TextLine{Text: l.text, Y: y, Width: w, Height: dc.fontHeight}
+		switch align {
+		case AlignLeft:
+			line.X = x + l.indent
+		case AlignCenter:
+			line.X = x + l.indent + (width-l.indent)/2 - 0.5*w
+		case AlignRight:
+			line.X = x + width - w
+		case AlignJustify:
+			line.X = x + l.indent
+			// the trimmed text alternates words and spaces
+			gaps := len(splitOnSpace(l.text)) / 2
+			if !l.last && gaps > 0 {
+				precise := dc.lineAdvance(l.text)
+				if spacing := (width - l.indent - precise) / float64(gaps); spacing > 0 {
+					line.Width = width - l.indent
+					line.WordSpacing = spacing
+				}
+			}
+		}
+		layout.Lines = append(layout.Lines, line)
 		y += dc.fontHeight * lineSpacing
 	}
+
+	if dc.vertical() {
+		layout.X, layout.Y, layout.Width, layout.Height = left, top, h, width
+		for i := range layout.Lines {
+			line := &layout.Lines[i]
+			line.X, line.Y, line.Width, line.Height = left+h-line.Y-line.Height, top+line.X, line.Height, line.Width
+		}
+	}
+
+	return layout
 }
 
 // MeasureMultilineString measures the width and height of a multiline text string.
 //
-// This method calculates the dimensions of a multiline text string `s`, taking into account the specified `lineSpacing` factor for vertical line spacing. It returns the width and height of the multiline text in pixels.
+// This method calculates the dimensions of a multiline text string `s`, taking into account the specified `lineSpacing` factor for vertical line spacing. It returns the width and height of the multiline text in pixels. In vertical writing mode, the lines are columns that stack from right to left.
 func (dc *Context) MeasureMultilineString(s string, lineSpacing float64) (width, height float64) {
 	lines := strings.Split(s, "\n")
 
+	if dc.vertical() {
+		// the columns stack like the lines of horizontal text
+		width = float64(len(lines)) * dc.fontHeight * lineSpacing
+		width -= (lineSpacing - 1) * dc.fontHeight
+		for _, line := range lines {
+			h, _ := columnMeasurer{dc}.MeasureString(line)
+			height = math.Max(height, h)
+		}
+		return width, height
+	}
+
 	// sync h formula with DrawStringWrapped
 	height = float64(len(lines)) * dc.fontHeight * lineSpacing
 	height -= (lineSpacing - 1) * dc.fontHeight
 
-	d := &font.Drawer{
-		Face: dc.fontFace,
-	}
-
 	// max width from lines
 	for _, line := range lines {
-		adv := d.MeasureString(line)
+		adv := shapeString(dc.fontFace, line).advance
 		currentWidth := float64(adv >> 6) // from gg.Context.MeasureString
 		if currentWidth > width {
 			width = currentWidth
@@ -1057,21 +1469,28 @@ func (dc *Context) MeasureMultilineString(s string, lineSpacing float64) (width,
 
 // MeasureString measures the width and height of a single-line text string.
 //
-// This method calculates the dimensions of a single-line text string `s` and returns its width and the standard line height (font height).
+// This method calculates the dimensions of a single-line text string `s` and returns its width and the standard line height (font height). In vertical writing mode, it returns the font height as the width of the column, and the length of the column.
 func (dc *Context) MeasureString(s string) (w, h float64) {
-	d := &font.Drawer{
-		Face: dc.fontFace,
+	if dc.vertical() {
+		h, _ := columnMeasurer{dc}.MeasureString(s)
+		return dc.fontHeight, h
 	}
-	a := d.MeasureString(s)
+
+	a := shapeString(dc.fontFace, s).advance
 
 	return float64(a >> 6), dc.fontHeight
 }
 
 // WordWrap wraps a text string to fit within a specified width.
 //
-// This method takes a text string `s` and wraps it to fit within a given width `w`, breaking it into multiple lines as necessary to prevent text from exceeding the specified width. The result is returned as a slice of strings, each representing a wrapped line of text.
-func (dc *Context) WordWrap(s string, w float64) []string {
-	return wordWrap(dc, s, w)
+// This method takes a text string `s` and wraps it to fit within a given width `w`, breaking it into multiple lines as necessary to prevent text from exceeding the specified width. Lines break at the line break opportunities of the Unicode line breaking algorithm, which include spaces, hyphens, soft hyphens, zero width spaces and the gaps between ideographs. The optional `options` indent the first line of each paragraph, break words that are too wide and hyphenate words; the other options are ignored. The result is returned as a slice of strings, each representing a wrapped line of text.
+func (dc *Context) WordWrap(s string, w float64, options ...WrapOptions) []string {
+	var opts WrapOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	return wordWrap(dc.lineMeasurer(), s, w, opts)
 }
 
 // Identity resets the current transformation matrix to the identity matrix.
@@ -1175,9 +1594,12 @@ func (dc *Context) Pop() {
 	dc.mask = before.mask
 	dc.strokePath = before.strokePath
 	dc.fillPath = before.fillPath
+	dc.path = before.path
 	dc.start = before.start
 	dc.current = before.current
 	dc.hasCurrent = before.hasCurrent
+	dc.recorder = before.recorder
+	dc.clipChain = before.clipChain
 }
 
 // AppendFrame appends the current context image to the frames slice.
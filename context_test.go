@@ -5,19 +5,37 @@
 package gg
 
 import (
+	"bytes"
+	"compress/zlib"
 	"crypto/md5"
+	"encoding/xml"
 	"flag"
 	"fmt"
+	"image"
 	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
 	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
 )
 
 var save bool
 
 func init() {
 	flag.BoolVar(&save, "save", false, "save PNG output for each test case")
-	flag.Parse()
 }
 
 func hash(dc *Context) string {
@@ -31,6 +49,22 @@ func checkHash(t *testing.T, dc *Context, expected string) {
 	}
 }
 
+// opaqueBounds returns the smallest rectangle containing all the non-transparent pixels of an image.
+func opaqueBounds(im *image.RGBA) image.Rectangle {
+	var r image.Rectangle
+	b := im.Bounds()
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if im.Pix[im.PixOffset(x, y)+3] != 0 {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+
+	return r
+}
+
 func saveImage(dc *Context, name string) error {
 	if save {
 		return SavePNG(name+".png", dc.Image())
@@ -208,6 +242,739 @@ func TestDrawStringWrapped(t *testing.T) {
 	checkHash(t, dc, "8d92f6aae9e8b38563f171abd00893f8")
 }
 
+func TestWrapOptions(t *testing.T) {
+	f, err := FontParse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err := FontNewFace(f, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := NewContext(300, 300)
+	dc.SetFontFace(face)
+	dc.SetRGB(0, 0, 0)
+
+	const text = "The quick brown fox jumps over the lazy dog.\nA second paragraph."
+	plain := dc.LayoutStringWrapped(text, 10, 10, 0, 0, 200, 1.5, AlignLeft, WrapOptions{Indent: 15})
+
+	// justified lines fill the width, except for the last line of each paragraph
+	layout := dc.DrawStringWrapped(text, 10, 10, 0, 0, 200, 1.5, AlignJustify, WrapOptions{ParagraphSpacing: 7, Indent: 15})
+	if layout.Height != plain.Height+7 {
+		t.Fatalf("expected a height of %v, got %v", plain.Height+7, layout.Height)
+	}
+	first := layout.Lines[0]
+	if first.X != 25 || first.Width != 185 || first.WordSpacing <= 0 {
+		t.Fatalf("expected an indented, justified first line, got %+v", first)
+	}
+	for i, line := range layout.Lines {
+		last := i == len(layout.Lines)-1 || layout.Lines[i+1].X == 25
+		if last != (line.WordSpacing == 0) {
+			t.Fatalf("expected only the last lines of paragraphs to be unjustified, got %+v", line)
+		}
+	}
+	below := layout.Lines[len(layout.Lines)-1]
+	if math.Abs(below.Y+below.Height-(layout.Y+layout.Height)) > 1e-9 {
+		t.Fatalf("expected the last baseline at the bottom of the layout, got %v and %v", below.Y+below.Height, layout.Y+layout.Height)
+	}
+
+	// lines beyond the maximum are cut and the last line ends with an ellipsis
+	layout = dc.LayoutStringWrapped(text, 10, 10, 0, 0, 200, 1.5, AlignLeft, WrapOptions{MaxLines: 2})
+	if !layout.Truncated || len(layout.Lines) != 2 {
+		t.Fatalf("expected 2 truncated lines, got %d", len(layout.Lines))
+	}
+	last := layout.Lines[1]
+	if !strings.HasSuffix(last.Text, "…") || last.Width > 200 {
+		t.Fatalf("expected an ellipsis that fits, got %q with a width of %v", last.Text, last.Width)
+	}
+	if layout = dc.LayoutStringWrapped(text, 10, 10, 0, 0, 200, 1.5, AlignLeft, WrapOptions{MaxLines: 10}); layout.Truncated {
+		t.Fatal("expected no truncation")
+	}
+}
+
+// monospace measures every character of a string as 10 pixels wide.
+type monospace struct{}
+
+func (monospace) MeasureString(s string) (w, h float64) {
+	return float64(utf8.RuneCountInString(s) * 10), 10
+}
+
+func TestLineBreaking(t *testing.T) {
+	tests := []struct {
+		s     string
+		width float64
+		opts  WrapOptions
+		want  []string
+	}{
+		{"Hello, world! How are you?", 130, WrapOptions{}, []string{"Hello, world!", "How are you?"}},
+		{"日本語のテキストです。", 50, WrapOptions{}, []string{"日本語のテ", "キストで", "す。"}},
+		{"我们服务中文用户，谢谢！", 40, WrapOptions{}, []string{"我们服务", "中文用", "户，谢", "谢！"}},
+		{"co\u00adop\u00aderation and", 60, WrapOptions{}, []string{"coop-", "eration", "and"}},
+		{"zero\u200bwidth", 60, WrapOptions{}, []string{"zero", "width"}},
+		{"no break here", 60, WrapOptions{}, []string{"no break", "here"}},
+		{"see https://example.com/path", 150, WrapOptions{}, []string{"see https://", "example.com/", "path"}},
+		{"abcdefghij", 40, WrapOptions{}, []string{"abcdefghij"}},
+		{"abcdefghij", 40, WrapOptions{BreakWords: true}, []string{"abcd", "efgh", "ij"}},
+		{"a b", 100, WrapOptions{}, []string{"a", "b"}},
+		{"the hyphenation", 110, WrapOptions{Hyphenate: func(word string) []int {
+			if word == "hyphenation" {
+				return []int{2, 6, 7}
+			}
+			return nil
+		}}, []string{"the hyphen-", "ation"}},
+	}
+	for _, test := range tests {
+		got := wordWrap(monospace{}, test.s, test.width, test.opts)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("wrapping %q in %v: expected %q, got %q", test.s, test.width, test.want, got)
+		}
+	}
+
+	// Thai breaks before leading vowels
+	segments := lineSegments("ภาษาไทยเป็น")
+	if len(segments) != 3 || segments[1].text != "ไทย" {
+		t.Fatalf("unexpected Thai segments: %+v", segments)
+	}
+
+	// rich text breaks between ideographs of different spans
+	dc := NewContext(100, 100)
+	layout := dc.LayoutRichText([]TextSpan{{Text: "日本語"}, {Text: "のテキスト"}}, 20, 1, AlignLeft)
+	if len(layout.Lines) < 2 {
+		t.Fatalf("expected CJK rich text to wrap, got %d lines", len(layout.Lines))
+	}
+}
+
+// kernedFace kerns every pair of runes by one pixel.
+type kernedFace struct {
+	font.Face
+}
+
+func (kernedFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	return fixed.I(1)
+}
+
+func TestFontFamily(t *testing.T) {
+	f, err := FontParse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err := FontNewFace(f, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	family := NewFontFamily(basicfont.Face7x13, face)
+
+	// runes missing from the first face are drawn with the next one
+	ink := func(face font.Face) int {
+		dc := NewContext(60, 30)
+		dc.SetFontFace(face)
+		dc.SetRGB(0, 0, 0)
+		dc.DrawString("AΩ", 5, 20)
+		n := 0
+		for y := 0; y < 30; y++ {
+			for x := 12; x < 60; x++ {
+				if dc.im.RGBAAt(x, y).A > 0 {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	if ink(basicfont.Face7x13) != 0 || ink(family) == 0 {
+		t.Fatal("expected the missing rune to be drawn with the fallback face")
+	}
+
+	dc := NewContext(100, 100)
+	dc.SetFontFace(family)
+	omega, _ := face.GlyphAdvance('Ω')
+	if w, _ := dc.MeasureString("AΩ"); w != float64((fixed.I(7)+omega)>>6) {
+		t.Fatalf("expected the fallback advance to be measured, got %v", w)
+	}
+	if lines := dc.WordWrap("ΩΩΩ ΩΩΩ", 30); len(lines) != 2 {
+		t.Fatalf("expected fallback text to wrap, got %q", lines)
+	}
+
+	// kerning only applies between runes of the same face
+	kerned := NewFontFamily(kernedFace{basicfont.Face7x13}, kernedFace{face})
+	if k := kerned.Kern('A', 'V'); k != fixed.I(1) {
+		t.Fatalf("expected the kerning of the face, got %v", k)
+	}
+	if k := kerned.Kern('A', 'Ω'); k != 0 {
+		t.Fatalf("expected no kerning between faces, got %v", k)
+	}
+
+	runs := faceRuns(family, "AΩA")
+	if len(runs) != 3 || runs[1].face != face || runs[1].offset != 7 || runs[2].offset != 7+unfix(omega) {
+		t.Fatalf("unexpected face runs: %+v", runs)
+	}
+
+	// runs are placed where their glyphs are shaped, here in right-to-left order
+	runs = faceRuns(family, "\u202eAΩ")
+	if len(runs) != 2 || runs[0].offset != unfix(omega) || runs[1].face != face || runs[1].offset != 0 {
+		t.Fatalf("unexpected right-to-left face runs: %+v", runs)
+	}
+}
+
+// visualString returns the runes of a line in display order, as the Unicode Bidirectional Algorithm orders them,
+// without the formatting characters.
+func visualString(s string) string {
+	runes := []rune(s)
+	levels, _ := bidiLevels(runes)
+	var b strings.Builder
+	for _, i := range visualOrder(levels) {
+		r := runes[i]
+		if isDefaultIgnorable(r) {
+			continue
+		}
+		if levels[i]%2 == 1 {
+			r = bidiMirror(r)
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func TestBidi(t *testing.T) {
+	tests := []struct {
+		logical, visual string
+	}{
+		{"plain text", "plain text"},
+		{"car means אבג.", "car means גבא."},
+		{"אבג (abc) 12", "12 (abc) גבא"},
+		{"abc سلام 123", "abc 123 مالس"},
+		{"א < ב", "ב > א"},
+		{"\u2067abc\u2069 def", "abc def"},
+		{"\u202eabc\u202c def", "cba def"},
+		{"\u2067אבג 12\u2069 def", "12 גבא def"},
+	}
+	for _, test := range tests {
+		if got := visualString(test.logical); got != test.visual {
+			t.Errorf("reordering %q: expected %q, got %q", test.logical, test.visual, got)
+		}
+	}
+
+	// right to left text is measured like its runes, and drawn from its last rune
+	f, err := FontParse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err := FontNewFace(f, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shaped := shapeString(face, "ab \u202edef")
+	if shaped.advance != font.MeasureString(face, "ab fed") {
+		t.Errorf("expected the advance of the runes, got %v", shaped.advance)
+	}
+	if g := shaped.glyphs[len(shaped.glyphs)-1]; g.r != 'd' {
+		t.Errorf("expected the override to end with d, got %q", g.r)
+	}
+}
+
+func TestShaping(t *testing.T) {
+	raw, err := os.ReadFile("/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf")
+	if err != nil {
+		t.Skip("DejaVu Sans is not installed")
+	}
+	f, err := FontParse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err := FontNewFace(f, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lam and alef form a ligature
+	if glyphs := shapeString(face, "لا").glyphs; len(glyphs) != 1 || glyphs[0].r != -1 {
+		t.Errorf("expected a lam-alef ligature, got %+v", glyphs)
+	}
+
+	// a dual joining letter takes its initial, medial and final forms, from right to left
+	isolated := shapeString(face, "ب").glyphs[0].gid
+	glyphs := shapeString(face, "ببب").glyphs
+	if len(glyphs) != 3 {
+		t.Fatalf("expected 3 glyphs, got %d", len(glyphs))
+	}
+	seen := map[sfnt.GlyphIndex]bool{isolated: true}
+	for _, g := range glyphs {
+		if seen[g.gid] {
+			t.Errorf("expected 4 forms of beh, got %+v", glyphs)
+		}
+		seen[g.gid] = true
+	}
+	if glyphs[0].start != 4 || glyphs[2].start != 0 {
+		t.Errorf("expected the glyphs from right to left, got %+v", glyphs)
+	}
+
+	// a combining mark is placed over its base and takes no space
+	base := shapeString(face, "e")
+	shaped := shapeString(face, "e\u0301")
+	if shaped.advance != base.advance || len(shaped.glyphs) != 2 {
+		t.Fatalf("expected the mark to take no space, got %v and %v", shaped.advance, base.advance)
+	}
+	if mark := shaped.glyphs[1].dot; mark.X <= 0 || mark.X >= base.advance {
+		t.Errorf("expected the mark over the base, got %v", mark)
+	}
+
+	// measuring and drawing use the shaped glyphs
+	dc := NewContext(200, 60)
+	dc.SetRGB(0, 0, 0)
+	dc.SetFontFace(face)
+	w, _ := dc.MeasureString("لا ببب")
+	if want := float64(shapeString(face, "لا ببب").advance >> 6); w != want {
+		t.Errorf("expected a width of %v, got %v", want, w)
+	}
+	dc.DrawString("لا ببب", 10, 40)
+	if bounds := opaqueBounds(dc.im); bounds.Empty() || bounds.Max.X > 10+int(w)+2 {
+		t.Errorf("unexpected bounds of the text: %v", bounds)
+	}
+}
+
+func TestJustifyRightToLeft(t *testing.T) {
+	raw, err := os.ReadFile("/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf")
+	if err != nil {
+		t.Skip("DejaVu Sans is not installed")
+	}
+	f, err := FontParse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err := FontNewFace(f, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := NewContext(300, 300)
+	dc.SetFontFace(face)
+	dc.StartRecording()
+
+	// the words of a justified Hebrew line are spread from right to left, the first one ending at the right edge
+	const text = "שלום עולם זה טקסט ארוך בעברית שנשבר לכמה שורות"
+	layout := dc.DrawStringWrapped(text, 10, 10, 0, 0, 200, 1.5, AlignJustify)
+	first := layout.Lines[0]
+	if first.WordSpacing <= 0 {
+		t.Fatalf("expected a justified first line, got %+v", first)
+	}
+	words := strings.Fields(first.Text)
+	xs := make(map[string]float64)
+	for _, op := range dc.recorder.page.ops {
+		if op.kind == recordText && op.y == first.Y+first.Height {
+			xs[op.text] = op.x
+		}
+	}
+	if len(xs) != len(words) {
+		t.Fatalf("expected %d words recorded, got %v", len(words), xs)
+	}
+	for i := 1; i < len(words); i++ {
+		if xs[words[i]] >= xs[words[i-1]] {
+			t.Fatalf("expected the words from right to left, got %v", xs)
+		}
+	}
+	if right := xs[words[0]] + dc.lineAdvance(words[0]); math.Abs(right-(first.X+first.Width)) > 0.5 {
+		t.Fatalf("expected the first word to end at %v, got %v", first.X+first.Width, right)
+	}
+}
+
+func TestLayoutTables(t *testing.T) {
+	f, err := FontParse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gid := func(r rune) int {
+		i, _ := f.GlyphIndex(nil, r)
+		return int(i)
+	}
+	u16 := func(b []byte, v int) []byte { return append(b, byte(v>>8), byte(v)) }
+	u32 := func(b []byte, v int) []byte { return u16(u16(b, v>>16), v) }
+	coverage := func(g int) []byte { return u16(u16(u16(nil, 1), 1), g) }
+
+	// a GSUB or GPOS table with the DFLT script, two features with a lookup each, and their lookups
+	layoutTable := func(feature0, feature1 string, kind0 int, sub0 []byte, kind1 int, sub1 []byte) []byte {
+		scripts := append(u16(nil, 1), "DFLT"...)
+		scripts = u16(u16(u16(scripts, 8), 4), 0)
+		scripts = u16(u16(u16(u16(u16(scripts, 0), 0xffff), 2), 0), 1)
+		features := u16(append(u16(append(u16(nil, 2), feature0...), 14), feature1...), 20)
+		features = u16(u16(u16(u16(u16(u16(features, 0), 1), 0), 0), 1), 1)
+		lookup0 := append(u16(u16(u16(u16(nil, kind0), 0), 1), 8), sub0...)
+		lookup1 := append(u16(u16(u16(u16(nil, kind1), 0), 1), 8), sub1...)
+		lookups := append(append(u16(u16(u16(nil, 2), 6), 6+len(lookup0)), lookup0...), lookup1...)
+		b := u16(u16(u16(u32(nil, 0x00010000), 10), 10+len(scripts)), 10+len(scripts)+len(features))
+		return append(append(append(b, scripts...), features...), lookups...)
+	}
+
+	// ccmp substitutes b for a, and liga forms a ligature of f and i, drawn with the glyph of #
+	single := append(u16(u16(u16(u16(nil, 2), 8), 1), gid('b')), coverage(gid('a'))...)
+	liga := append(u16(u16(u16(u16(nil, 1), 8), 1), 14), coverage(gid('f'))...)
+	liga = u16(u16(u16(u16(u16(liga, 1), 4), gid('#')), 2), gid('i'))
+	gsub := layoutTable("ccmp", "liga", 1, single, 4, liga)
+
+	// kern moves V 200 units closer to A, and mark attaches the grave accent to o
+	pair := append(u16(u16(u16(u16(u16(u16(nil, 1), 12), 4), 0), 1), 18), coverage(gid('A'))...)
+	pair = u16(u16(u16(pair, 1), gid('V')), -200)
+	mark := u16(u16(u16(u16(u16(u16(nil, 1), 12), 18), 1), 24), 36)
+	mark = append(append(mark, coverage(gid('`'))...), coverage(gid('o'))...)
+	mark = u16(u16(u16(u16(u16(u16(mark, 1), 0), 6), 1), 100), 0)
+	mark = u16(u16(u16(u16(u16(mark, 1), 4), 1), 500), 1400)
+	gpos := layoutTable("kern", "mark", 2, pair, 4, mark)
+
+	// GDEF makes o a base glyph and the grave accent a mark
+	ranges := [][2]int{{gid('o'), 1}, {gid('`'), 3}}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	gdef := u16(u16(u16(u16(u16(u32(nil, 0x00010000), 12), 0), 0), 0), 2)
+	gdef = u16(gdef, len(ranges))
+	for _, r := range ranges {
+		gdef = u16(u16(u16(gdef, r[0]), r[0]), r[1])
+	}
+
+	f, err = FontParse(withTables(goregular.TTF, map[string][]byte{"GSUB": gsub, "GPOS": gpos, "GDEF": gdef}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err := FontNewFace(f, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 32 pixels per em of 2048 units make one unit 1/64 of a pixel, one unit of fixed.Int26_6
+	if f.UnitsPerEm() != 2048 {
+		t.Fatalf("expected 2048 units per em, got %d", f.UnitsPerEm())
+	}
+
+	if g := shapeString(face, "ab").glyphs; len(g) != 2 || g[0].gid != sfnt.GlyphIndex(gid('b')) || g[0].r != -1 {
+		t.Errorf("expected a to be substituted with b, got %+v", g)
+	}
+
+	if g := shapeString(face, "fit").glyphs; len(g) != 2 || g[0].gid != sfnt.GlyphIndex(gid('#')) || g[0].start != 0 || g[0].end != 2 {
+		t.Errorf("expected a ligature of f and i, got %+v", g)
+	}
+
+	advA, _ := face.GlyphAdvance('A')
+	advV, _ := face.GlyphAdvance('V')
+	if a := shapeString(face, "AV").advance; a != advA+advV-200 {
+		t.Errorf("expected AV to be kerned to %v, got %v", advA+advV-200, a)
+	}
+
+	advO, _ := face.GlyphAdvance('o')
+	shaped := shapeString(face, "o`")
+	if shaped.advance != advO || len(shaped.glyphs) != 2 {
+		t.Fatalf("expected the mark to take no space, got %v for an o of %v", shaped.advance, advO)
+	}
+	if dot := shaped.glyphs[1].dot; dot.X != 400 || dot.Y != -1400 {
+		t.Errorf("expected the mark anchored at (400, -1400), got %v", dot)
+	}
+}
+
+// withTables returns the source of a font with tables added to it.
+func withTables(src []byte, tables map[string][]byte) []byte {
+	all := make(map[string][]byte)
+	for i := 0; i < int(src[4])<<8|int(src[5]); i++ {
+		rec := src[12+16*i:]
+		offset := int(rec[8])<<24 | int(rec[9])<<16 | int(rec[10])<<8 | int(rec[11])
+		length := int(rec[12])<<24 | int(rec[13])<<16 | int(rec[14])<<8 | int(rec[15])
+		all[string(rec[:4])] = src[offset : offset+length]
+	}
+	for tag, data := range tables {
+		all[tag] = data
+	}
+	tags := make([]string, 0, len(all))
+	for tag := range all {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	u16 := func(b []byte, v int) []byte { return append(b, byte(v>>8), byte(v)) }
+	u32 := func(b []byte, v int) []byte { return u16(u16(b, v>>16), v) }
+	out := u16(u16(u32(nil, 0x00010000), len(tags)), 0)
+	out = u16(u16(out, 0), 0)
+	offset := 12 + 16*len(tags)
+	var data []byte
+	for _, tag := range tags {
+		out = append(out, tag...)
+		out = u32(u32(u32(out, 0), offset+len(data)), len(all[tag]))
+		data = append(data, all[tag]...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+
+	return append(out, data...)
+}
+
+func TestColorGlyphs(t *testing.T) {
+	f, err := FontParse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gid := func(r rune) int {
+		i, _ := f.GlyphIndex(nil, r)
+		return int(i)
+	}
+	u16 := func(b []byte, v int) []byte { return append(b, byte(v>>8), byte(v)) }
+	u32 := func(b []byte, v int) []byte { return u16(u16(b, v>>16), v) }
+	square := func(c color.Color) []byte {
+		im := image.NewRGBA(image.Rect(0, 0, 8, 8))
+		draw.Draw(im, im.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		var b bytes.Buffer
+		png.Encode(&b, im)
+		return b.Bytes()
+	}
+
+	// A is painted red, B with the text color
+	colr := u16(u16(nil, 0), 2)
+	colr = u16(u32(u32(colr, 14), 26), 2)
+	colr = u16(u16(u16(colr, gid('A')), 0), 1)
+	colr = u16(u16(u16(colr, gid('B')), 1), 1)
+	colr = u16(u16(colr, gid('A')), 0)
+	colr = u16(u16(colr, gid('B')), 0xffff)
+	cpal := u16(u16(u16(u16(nil, 0), 1), 1), 1)
+	cpal = u16(u32(cpal, 14), 0)
+	cpal = append(cpal, 0, 0, 255, 255)
+
+	// C is an 8x8 green bitmap in a CBDT strike of 16 pixels per em
+	png := square(color.RGBA{0, 255, 0, 255})
+	cbdt := u32(nil, 0x00030000)
+	cbdt = append(cbdt, 8, 8, 0, 8, 8)
+	cbdt = append(u32(cbdt, len(png)), png...)
+	cblc := u32(u32(nil, 0x00030000), 1)
+	cblc = u32(u32(u32(u32(cblc, 56), 20), 1), 0)
+	cblc = append(cblc, make([]byte, 24)...)
+	cblc = u16(u16(cblc, gid('C')), gid('C'))
+	cblc = append(cblc, 16, 16, 32, 1)
+	cblc = u32(u16(u16(cblc, gid('C')), gid('C')), 8)
+	cblc = u32(u16(u16(cblc, 1), 17), 4)
+	cblc = u32(u32(cblc, 0), len(cbdt)-4)
+
+	// D is an 8x8 blue bitmap in an sbix strike of 16 pixels per em
+	n := f.NumGlyphs()
+	sbix := u32(u16(u16(nil, 1), 1), 1)
+	sbix = u32(sbix, 12)
+	strike := u16(u16(nil, 16), 72)
+	glyph := u16(u16(nil, 0), 0)
+	glyph = append(append(glyph, "png "...), square(color.RGBA{0, 0, 255, 255})...)
+	for i := 0; i <= n; i++ {
+		offset := 4 + 4*(n+1)
+		if i > gid('D') {
+			offset += len(glyph)
+		}
+		strike = u32(strike, offset)
+	}
+	sbix = append(append(sbix, strike...), glyph...)
+
+	raw := withTables(goregular.TTF, map[string][]byte{"COLR": colr, "CPAL": cpal, "CBDT": cbdt, "CBLC": cblc, "sbix": sbix})
+	f, err = FontParse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err := FontNewFace(f, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dc := NewContext(200, 60)
+	dc.SetRGB(0, 0, 0)
+	dc.SetFontFace(face)
+	dc.DrawString("ABCDx", 10, 40)
+	count := func(x0, x1 int, want color.RGBA) int {
+		n := 0
+		for y := 0; y < 60; y++ {
+			for x := x0; x < x1; x++ {
+				if dc.im.RGBAAt(x, y) == want {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	advance := func(s string) int {
+		w, _ := dc.MeasureString(s)
+		return 10 + int(w)
+	}
+	red, green, blue, black := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}, color.RGBA{0, 0, 255, 255}, color.RGBA{0, 0, 0, 255}
+	if count(10, advance("A"), red) == 0 {
+		t.Error("expected a red A")
+	}
+	if count(advance("A"), advance("AB"), black) == 0 || count(advance("A"), advance("AB"), red) != 0 {
+		t.Error("expected a B in the text color")
+	}
+	// the bitmaps are scaled from 16 to 32 pixels per em, with their bottom on the baseline
+	if n := count(advance("AB"), advance("ABC"), green); n < 12*12 || n > 16*16 {
+		t.Errorf("expected a 16x16 green bitmap, got %d pixels", n)
+	}
+	if dc.im.RGBAAt(advance("AB")+8, 38) != green || dc.im.RGBAAt(advance("AB")+8, 42) == green {
+		t.Error("expected the green bitmap above the baseline")
+	}
+	if n := count(advance("ABC"), advance("ABCD"), blue); n < 12*12 || n > 16*16 {
+		t.Errorf("expected a 16x16 blue bitmap, got %d pixels", n)
+	}
+	if count(advance("ABCD"), 200, black) == 0 {
+		t.Error("expected a black x after the color glyphs")
+	}
+}
+
+func TestVerticalText(t *testing.T) {
+	f, err := FontParse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err := FontNewFace(f, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dc := NewContext(200, 200)
+	dc.SetRGB(0, 0, 0)
+	dc.SetFontFace(face)
+	dc.SetWritingMode(WritingModeVertical)
+
+	// without vertical metrics, upright glyphs take the height of the face, and sideways runs their advance
+	m := face.Metrics()
+	if _, h := dc.MeasureString("■■"); h != float64((2*(m.Ascent+m.Descent))>>6) {
+		t.Errorf("expected upright glyphs as tall as the face, got %v", h)
+	}
+	horizontal := unfix(shapeString(face, "Hello").advance)
+	if w, h := dc.MeasureString("Hello"); w != dc.FontHeight() || math.Abs(h-horizontal) > 1 {
+		t.Errorf("expected a sideways run as long as its advance, got %v by %v", w, h)
+	}
+
+	// sideways runs are turned a quarter turn, so a dash is drawn down the column
+	dc.DrawString("-----", 100, 20)
+	if bounds := opaqueBounds(dc.im); bounds.Dy() <= bounds.Dx() || bounds.Min.Y < 20 {
+		t.Errorf("expected a sideways run down from the top of the column, got %v", bounds)
+	}
+
+	// the columns of wrapped text stack from right to left
+	layout := dc.LayoutStringWrapped("Hello world again", 150, 10, 1, 0, 150, 1.5, AlignLeft)
+	if len(layout.Lines) < 2 || layout.Height != 150 || layout.X+layout.Width != 150 {
+		t.Fatalf("unexpected layout %+v", layout)
+	}
+	for i, line := range layout.Lines {
+		if line.Height > 150 || line.Y != 10 || i > 0 && line.X >= layout.Lines[i-1].X {
+			t.Errorf("unexpected column %+v", line)
+		}
+	}
+	if lines := dc.WordWrap("Hello world again", 150); len(lines) != len(layout.Lines) {
+		t.Errorf("expected %d columns, got %q", len(layout.Lines), lines)
+	}
+
+	// vertical metrics set the advance and the top of upright glyphs
+	u16 := func(b []byte, v int) []byte { return append(b, byte(v>>8), byte(v)) }
+	vhea := u16(make([]byte, 34), 1)
+	vmtx := u16(u16(nil, 2*int(f.UnitsPerEm())), 0)
+	f, err = FontParse(withTables(goregular.TTF, map[string][]byte{"vhea": vhea, "vmtx": vmtx}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err = FontNewFace(f, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc = NewContext(200, 200)
+	dc.SetRGB(0, 0, 0)
+	dc.SetFontFace(face)
+	dc.SetWritingMode(WritingModeVertical)
+	if _, h := dc.MeasureString("■■"); h != 128 {
+		t.Errorf("expected upright glyphs of 64 pixels, got %v", h)
+	}
+	dc.DrawStringAnchored("■", 100, 20, 0.5, 0)
+	if bounds := opaqueBounds(dc.im); bounds.Min.Y < 19 || bounds.Min.Y > 21 || math.Abs(float64(bounds.Min.X+bounds.Max.X)/2-100) > 1 {
+		t.Errorf("expected the glyph centered on the column from its top, got %v", bounds)
+	}
+
+	// anchors are relative to the selected box, turned with the column
+	anchored := func(s string, ax, ay float64, box TextBox) image.Rectangle {
+		dc := NewContext(200, 200)
+		dc.SetRGB(0, 0, 0)
+		dc.SetFontFace(face)
+		dc.SetWritingMode(WritingModeVertical)
+		dc.DrawStringAnchored(s, 100, 100, ax, ay, box)
+		return opaqueBounds(dc.im)
+	}
+	for _, s := range []string{"■", "Hey"} {
+		if b := anchored(s, 0, 0, TextBoxInk); math.Abs(float64(b.Min.X)-100) > 1 || math.Abs(float64(b.Min.Y)-100) > 1 {
+			t.Errorf("expected the ink of %q from the anchor, got %v", s, b)
+		}
+		if b := anchored(s, 1, 1, TextBoxInk); math.Abs(float64(b.Max.X)-100) > 1 || math.Abs(float64(b.Max.Y)-100) > 1 {
+			t.Errorf("expected the ink of %q up to the anchor, got %v", s, b)
+		}
+	}
+	if line, em := anchored("■", 0, 0, TextBoxLine), anchored("■", 0, 0, TextBoxEm); line.Min.Y != em.Min.Y || line.Min.X == em.Min.X {
+		t.Errorf("expected the em box across the ascent and descent of the face, got %v and %v", line, em)
+	}
+	if b := anchored("■", 0.5, 0, TextBoxBaseline); math.Abs(float64(b.Min.X+b.Max.X)/2-100) > 1 {
+		t.Errorf("expected the center line on the anchor, got %v", b)
+	}
+}
+
+func TestMeasureText(t *testing.T) {
+	f, err := FontParse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err := FontNewFace(f, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the font height doesn't depend on the way the face was set
+	loaded := NewContext(10, 10)
+	if err := loaded.LoadFontFaceFromBytes(goregular.TTF, 40); err != nil {
+		t.Fatal(err)
+	}
+	dc := NewContext(200, 100)
+	dc.SetFontFace(face)
+	if loaded.FontHeight() != dc.FontHeight() {
+		t.Errorf("expected the same font height, got %v and %v", loaded.FontHeight(), dc.FontHeight())
+	}
+	bitmap := NewContext(10, 10)
+	height := bitmap.FontHeight()
+	bitmap.SetFontFace(basicfont.Face7x13)
+	if bitmap.FontHeight() != height {
+		t.Errorf("expected a font height of %v, got %v", height, bitmap.FontHeight())
+	}
+
+	m := face.Metrics()
+	tm := dc.MeasureText("Hello")
+	if w, _ := dc.MeasureString("Hello"); math.Floor(tm.Width) != w {
+		t.Errorf("expected a width of %v, got %v", w, tm.Width)
+	}
+	if tm.Ascent != unfix(m.Ascent) || tm.Descent != unfix(m.Descent) || tm.LineGap < 0 {
+		t.Errorf("unexpected vertical metrics %+v", tm)
+	}
+	if tm.XHeight <= 0 || tm.CapHeight <= tm.XHeight {
+		t.Errorf("unexpected x-height and cap height %+v", tm)
+	}
+	if tm := dc.MeasureText("HEX"); math.Abs(tm.InkY1+tm.CapHeight) > 1 || math.Abs(tm.InkY2) > 1 || tm.InkX1 < 0 || tm.InkX2 > tm.Width {
+		t.Errorf("expected the ink from the baseline to the cap height, got %+v", tm)
+	}
+	if tm := dc.MeasureText("g"); tm.InkY2 <= 1 {
+		t.Errorf("expected the ink of a descender below the baseline, got %+v", tm)
+	}
+	if tm := dc.MeasureText(" "); tm.InkX1 != 0 || tm.InkX2 != 0 || tm.Width <= 0 {
+		t.Errorf("expected a space without ink, got %+v", tm)
+	}
+	if tm := NewContext(10, 10).MeasureText("x"); tm.InkY1 <= -tm.Ascent || tm.InkY2 > 0 {
+		t.Errorf("expected the ink of a bitmap glyph, got %+v", tm)
+	}
+
+	// anchors are relative to the selected box
+	anchored := func(y, ax, ay float64, box TextBox) image.Rectangle {
+		dc := NewContext(200, 100)
+		dc.SetRGB(0, 0, 0)
+		dc.SetFontFace(face)
+		dc.DrawStringAnchored("Hello", 100, y, ax, ay, box)
+		return opaqueBounds(dc.im)
+	}
+	if b := anchored(50, 0.5, 0.5, TextBoxInk); math.Abs(float64(b.Min.X+b.Max.X)/2-100) > 1 || math.Abs(float64(b.Min.Y+b.Max.Y)/2-50) > 1 {
+		t.Errorf("expected the ink centered on the anchor, got %v", b)
+	}
+	if b := anchored(50, 0, 1, TextBoxBaseline); b.Max.Y < 49 || b.Max.Y > 51 {
+		t.Errorf("expected the baseline on the anchor, got %v", b)
+	}
+	if b := anchored(20, 0, 1, TextBoxEm); b.Min.Y < 20 || float64(b.Max.Y) > 20+tm.Ascent+tm.Descent {
+		t.Errorf("expected the em box below the anchor, got %v", b)
+	}
+}
+
 func TestDrawImage(t *testing.T) {
 	src := NewContext(100, 100)
 	src.SetRGB(1, 1, 1)
@@ -308,20 +1075,1264 @@ func TestDashes(t *testing.T) {
 	checkHash(t, dc, "94ee9b2ce00b8896815aecf8045a785d")
 }
 
-func BenchmarkCircles(b *testing.B) {
-	dc := NewContext(1000, 1000)
+func TestEncodeSVG(t *testing.T) {
+	dc := NewContext(100, 100)
+	if err := EncodeSVG(io.Discard, dc); err == nil {
+		t.Fatal("expected an error for a context that is not recording")
+	}
+	dc.StartRecording()
 	dc.SetRGB(1, 1, 1)
 	dc.Clear()
-	rnd := rand.New(rand.NewSource(99))
-	for i := 0; i < b.N; i++ {
-		x := rnd.Float64() * 1000
-		y := rnd.Float64() * 1000
-		dc.DrawCircle(x, y, 10)
-		if i%2 == 0 {
-			dc.SetRGB(0, 0, 0)
-		} else {
-			dc.SetRGB(1, 1, 1)
+	dc.DrawCircle(50, 50, 40)
+	dc.Clip()
+	g := NewLinearGradient(0, 0, 100, 100)
+	g.AddColorStop(0, color.RGBA{0, 255, 0, 255})
+	g.AddColorStop(1, color.RGBA{0, 0, 255, 255})
+	dc.SetFillStyle(g)
+	dc.DrawRectangle(10, 10, 80, 80)
+	dc.Fill()
+	dc.SetFillStyle(NewConicGradient(50, 50, 90))
+	dc.MoveTo(10, 10)
+	dc.CubicTo(20, 90, 80, 10, 90, 90)
+	dc.Fill()
+	dc.SetRGBA(1, 0, 0, 0.5)
+	dc.SetDash(4, 2)
+	dc.DrawLine(0, 0, 100, 100)
+	dc.Stroke()
+	dc.ResetClip()
+	dc.DrawString("a < b & c", 10, 90)
+	dc.DrawImage(dc.Image(), 0, 0)
+	var buf bytes.Buffer
+	if err := EncodeSVG(&buf, dc); err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	decoder := xml.NewDecoder(&buf)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
 		}
-		dc.Fill()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e, ok := token.(xml.StartElement); ok {
+			counts[e.Name.Local]++
+		}
+	}
+	expected := map[string]int{"clipPath": 1, "linearGradient": 1, "pattern": 1, "text": 1, "image": 1}
+	for name, n := range expected {
+		if counts[name] != n {
+			t.Fatalf("expected %d <%s> elements, got %d", n, name, counts[name])
+		}
+	}
+}
+
+// stripes is a pattern of vertical stripes whose type isn't comparable.
+type stripes struct {
+	colors []color.Color
+}
+
+func (s stripes) ColorAt(x, y int) color.Color {
+	return s.colors[x%len(s.colors)]
+}
+
+func TestEncodeSVGPattern(t *testing.T) {
+	dc := NewContext(100, 100)
+	dc.StartRecording()
+	p := stripes{colors: []color.Color{color.Black, color.White}}
+	dc.SetFillStyle(p)
+	dc.DrawRectangle(10, 10, 80, 80)
+	dc.Fill()
+	dc.SetStrokeStyle(p)
+	dc.DrawCircle(50, 50, 30)
+	dc.Stroke()
+	var buf bytes.Buffer
+	if err := EncodeSVG(&buf, dc); err != nil {
+		t.Fatal(err)
 	}
+	if n := strings.Count(buf.String(), "<pattern "); n != 2 {
+		t.Fatalf("expected a pattern for each use, got %d", n)
+	}
+}
+
+func TestRecordImageSnapshot(t *testing.T) {
+	dc := NewContext(100, 100)
+	dc.StartRecording()
+	im := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(im, im.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	dc.DrawImage(im, 0, 0)
+	dc.DrawImage(im, 20, 0)
+	draw.Draw(im, im.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	dc.DrawImage(im, 40, 0)
+	ops := dc.recorder.page.ops
+	if len(ops) != 3 {
+		t.Fatalf("expected 3 recorded images, got %d", len(ops))
+	}
+	if ops[0].image.At(0, 0) != (color.RGBA{0, 0, 0, 255}) {
+		t.Error("first recorded image changed with the drawn image")
+	}
+	if ops[0].image != ops[1].image {
+		t.Error("unchanged image not shared")
+	}
+	if ops[2].image == ops[1].image || ops[2].image.At(0, 0) != (color.RGBA{255, 255, 255, 255}) {
+		t.Error("changed image not recorded")
+	}
+}
+
+func TestEncodePDF(t *testing.T) {
+	font, err := FontParse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err := FontNewFace(font, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := NewContext(200, 100)
+	dc.StartRecording()
+	dc.SetPageSize(300, 150)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0, 0, 0)
+	dc.SetFontFace(face)
+	dc.DrawString("Invoice", 10, 40)
+	dc.SetFontFace(basicfont.Face7x13)
+	dc.DrawString("No. 42", 10, 60)
+	dc.ShowPage()
+	dc.DrawRectangle(10, 10, 50, 50)
+	g := NewRadialGradient(35, 35, 0, 35, 35, 25)
+	g.AddColorStop(0, color.White)
+	g.AddColorStop(1, color.Black)
+	dc.SetFillStyle(g)
+	dc.Fill()
+	dc.DrawImage(image.NewRGBA(image.Rect(0, 0, 4, 4)), 100, 10)
+	if n := dc.PageCount(); n != 2 {
+		t.Fatalf("expected 2 pages, got %d", n)
+	}
+	var buf bytes.Buffer
+	if err := EncodePDF(&buf, dc); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte("%PDF-")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatal("missing PDF header or trailer")
+	}
+	// follow startxref to the cross-reference table and check that every entry points at its object
+	i := bytes.LastIndex(data, []byte("startxref\n"))
+	var xref int
+	fmt.Sscanf(string(data[i+len("startxref\n"):]), "%d", &xref)
+	var first, count int
+	table := string(data[xref:])
+	if _, err := fmt.Sscanf(table, "xref\n%d %d\n", &first, &count); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(table, "\n")[2:]
+	objects := make(map[string]string)
+	for n := 1; n < count; n++ {
+		var offset int
+		fmt.Sscanf(lines[n], "%d", &offset)
+		prefix := fmt.Sprintf("%d 0 obj\n", n)
+		if !strings.HasPrefix(string(data[offset:]), prefix) {
+			t.Fatalf("xref entry %d does not point at its object", n)
+		}
+		body := string(data[offset+len(prefix):])
+		objects[fmt.Sprint(n)] = body[:strings.Index(body, "endobj")]
+	}
+	types := make(map[string]int)
+	text := false
+	var programs []*sfnt.Font
+	for _, body := range objects {
+		for _, name := range []string{"/Type /Page ", "/Subtype /Type0", "/Subtype /CIDFontType2", "/Subtype /Image", "/ShadingType 3"} {
+			if strings.Contains(body, name) {
+				types[name]++
+			}
+		}
+		if j := strings.Index(body, "stream\n"); j >= 0 {
+			r, err := zlib.NewReader(strings.NewReader(body[j+len("stream\n"):]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ := io.ReadAll(r)
+			text = text || bytes.Contains(content, []byte(") TJ")) || bytes.Contains(content, []byte("] TJ"))
+			if strings.Contains(body, "/Length1") {
+				program, err := sfnt.Parse(content)
+				if err != nil {
+					t.Fatalf("expected an embedded TrueType font, got %v", err)
+				}
+				programs = append(programs, program)
+			}
+		}
+	}
+	// the transparent image is written together with its soft mask, and the text of the bitmap face as a font
+	for name, n := range map[string]int{"/Type /Page ": 2, "/Subtype /Type0": 2, "/Subtype /CIDFontType2": 2, "/Subtype /Image": 2, "/ShadingType 3": 1} {
+		if types[name] != n {
+			t.Fatalf("expected %d objects with %s, got %d", n, name, types[name])
+		}
+	}
+	if !text {
+		t.Fatal("expected a text showing operator")
+	}
+
+	// the subsets hold the glyphs of the text, after the missing glyph, mapped from their runes
+	if len(programs) != 2 {
+		t.Fatalf("expected 2 embedded fonts, got %d", len(programs))
+	}
+	var buf2 sfnt.Buffer
+	for _, program := range programs {
+		gid, err := program.GlyphIndex(&buf2, 'o')
+		if err != nil || gid == 0 {
+			t.Fatalf("expected a glyph for o, got %v, %v", gid, err)
+		}
+		segments, err := program.LoadGlyph(&buf2, gid, fixed.I(int(program.UnitsPerEm())), nil)
+		if err != nil || len(segments) == 0 {
+			t.Fatalf("expected the outline of o, got %v", err)
+		}
+		switch n := program.NumGlyphs(); n {
+		case len("Invoice") + 1, len("No. 42") + 1:
+		default:
+			t.Fatalf("expected a subset of the glyphs of the text, got %d glyphs", n)
+		}
+	}
+	for _, program := range programs {
+		if program.UnitsPerEm() != font.UnitsPerEm() {
+			continue
+		}
+		ppem := fixed.I(int(font.UnitsPerEm()))
+		gid, _ := program.GlyphIndex(&buf2, 'o')
+		subset, _ := program.LoadGlyph(&buf2, gid, ppem, nil)
+		gid, _ = font.GlyphIndex(&buf2, 'o')
+		original, _ := font.LoadGlyph(&buf2, gid, ppem, nil)
+		if fmt.Sprint(subset) != fmt.Sprint(original) {
+			t.Fatalf("expected the outline of o to be kept, got %v and %v", subset, original)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	var p Path
+	p.MoveTo(10, 10)
+	p.LineTo(50, 10)
+	p.CubicTo(70, 10, 70, 50, 50, 50)
+	p.QuadraticTo(30, 70, 10, 50)
+	p.Close()
+	x0, y0, x1, y1 := p.Bounds()
+	if x0 != 10 || y0 != 10 || x1 != 65 || y1 != 60 {
+		t.Fatalf("unexpected bounds %v %v %v %v", x0, y0, x1, y1)
+	}
+	x0, y0, x1, y1 = p.Transform(Translate(5, -10).Scale(2, 2)).Bounds()
+	if x0 != 25 || y0 != 10 || x1 != 135 || y1 != 110 {
+		t.Fatalf("unexpected transformed bounds %v %v %v %v", x0, y0, x1, y1)
+	}
+
+	draw := func(f func(dc *Context)) *Context {
+		dc := NewContext(100, 100)
+		dc.SetRGB(1, 1, 1)
+		dc.Clear()
+		dc.Rotate(0.2)
+		f(dc)
+		dc.SetRGB(0, 0, 0)
+		dc.FillPreserve()
+		dc.SetRGB(1, 0, 0)
+		dc.Stroke()
+		return dc
+	}
+	direct := draw(func(dc *Context) {
+		dc.MoveTo(10, 10)
+		dc.LineTo(50, 10)
+		dc.CubicTo(70, 10, 70, 50, 50, 50)
+		dc.QuadraticTo(30, 70, 10, 50)
+		dc.ClosePath()
+	})
+	appended := draw(func(dc *Context) {
+		dc.AppendPath(p)
+		copied := dc.CopyPath()
+		for i := range p {
+			for j := 0; j < p[i].pointCount(); j++ {
+				if copied[i].Points[j].Distance(p[i].Points[j]) > 1e-9 {
+					t.Fatalf("copied path differs at segment %d: %v != %v", i, copied[i], p[i])
+				}
+			}
+		}
+	})
+	if hash(direct) != hash(appended) {
+		t.Fatal("appended path renders differently from the same path drawn directly")
+	}
+}
+
+func pathArea(p Path) float64 {
+	var area float64
+	for _, points := range p.flatten() {
+		for i, a := range points {
+			b := points[(i+1)%len(points)]
+			area += a.X*b.Y - b.X*a.Y
+		}
+	}
+	return math.Abs(area / 2)
+}
+
+func TestBooleanOperations(t *testing.T) {
+	square := func(x, y, s float64) Path {
+		var p Path
+		p.MoveTo(x, y)
+		p.LineTo(x+s, y)
+		p.LineTo(x+s, y+s)
+		p.LineTo(x, y+s)
+		p.Close()
+		return p
+	}
+	a, b := square(0, 0, 10), square(5, 5, 10)
+	for name, c := range map[string]struct {
+		result Path
+		area   float64
+	}{
+		"union":        {a.Union(b, FillRuleWinding), 175},
+		"intersection": {a.Intersect(b, FillRuleWinding), 25},
+		"difference":   {a.Difference(b, FillRuleWinding), 75},
+		"xor":          {a.Xor(b, FillRuleWinding), 150},
+		"touching":     {a.Union(square(10, 0, 10), FillRuleWinding), 200},
+		"disjoint":     {a.Intersect(square(20, 20, 10), FillRuleWinding), 0},
+	} {
+		if area := pathArea(c.result); math.Abs(area-c.area) > 1e-6 {
+			t.Fatalf("%s: expected area %v, got %v", name, c.area, area)
+		}
+	}
+
+	ring := append(square(0, 0, 20), square(5, 5, 10)...)
+	if area := pathArea(ring.Union(nil, FillRuleWinding)); math.Abs(area-400) > 1e-6 {
+		t.Fatalf("expected the winding ring to cover 400, got %v", area)
+	}
+	if area := pathArea(ring.Union(nil, FillRuleEvenOdd)); math.Abs(area-300) > 1e-6 {
+		t.Fatalf("expected the even-odd ring to cover 300, got %v", area)
+	}
+
+	dc := NewContext(100, 100)
+	dc.DrawCircle(50, 50, 30)
+	circle := dc.CopyPath()
+	lens := circle.Intersect(circle.Transform(Translate(20, 0)), FillRuleWinding)
+	if x0, _, x1, _ := lens.Bounds(); math.Abs(x0-40) > 0.5 || math.Abs(x1-80) > 0.5 {
+		t.Fatalf("unexpected lens bounds %v %v", x0, x1)
+	}
+}
+
+func TestHitTesting(t *testing.T) {
+	dc := NewContext(100, 100)
+	dc.Translate(50, 50)
+	dc.Scale(2, 2)
+	dc.DrawRectangle(-10, -10, 20, 20)
+	dc.DrawRectangle(-5, -5, 10, 10)
+	dc.SetLineWidth(4)
+	dc.SetLineCap(LineCapButt)
+
+	if !dc.InFill(0, 0) || !dc.InFill(8, 8) || dc.InFill(11, 0) {
+		t.Fatal("unexpected winding fill hit test")
+	}
+	dc.SetFillRule(FillRuleEvenOdd)
+	if dc.InFill(0, 0) || !dc.InFill(8, 8) {
+		t.Fatal("unexpected even-odd fill hit test")
+	}
+	// the line width is in device space, so the stroke is 1 unit wide on each side in user space
+	if !dc.InStroke(10.5, 0) || dc.InStroke(11.5, 0) || dc.InStroke(0, 0) {
+		t.Fatal("unexpected stroke hit test")
+	}
+
+	x1, y1, x2, y2 := dc.FillExtents()
+	if x1 != -10 || y1 != -10 || x2 != 10 || y2 != 10 {
+		t.Fatalf("unexpected fill extents %v %v %v %v", x1, y1, x2, y2)
+	}
+	x1, y1, x2, y2 = dc.StrokeExtents()
+	if math.Abs(x1+11) > 0.05 || math.Abs(y1+11) > 0.05 || math.Abs(x2-11) > 0.05 || math.Abs(y2-11) > 0.05 {
+		t.Fatalf("unexpected stroke extents %v %v %v %v", x1, y1, x2, y2)
+	}
+}
+
+func TestMiterJoin(t *testing.T) {
+	dc := NewContext(100, 100)
+	dc.SetLineWidth(10)
+	dc.SetLineCap(LineCapButt)
+	dc.DrawRectangle(20, 20, 60, 60)
+
+	// corners, including the one where the closed subpath starts
+	dc.SetLineJoinMiter()
+	if !dc.InStroke(16, 16) || !dc.InStroke(84, 16) || !dc.InStroke(84, 84) || !dc.InStroke(16, 84) {
+		t.Fatal("expected mitered corners")
+	}
+	dc.SetLineJoinBevel()
+	if dc.InStroke(16, 16) || dc.InStroke(84, 84) {
+		t.Fatal("expected beveled corners")
+	}
+	// a right angle has a miter ratio of sqrt(2)
+	dc.SetLineJoinMiter()
+	dc.SetMiterLimit(1.4)
+	if dc.InStroke(16, 16) || dc.InStroke(84, 84) {
+		t.Fatal("expected corners past the miter limit to be beveled")
+	}
+
+	dc.ClearPath()
+	dc.SetMiterLimit(10)
+	dc.MoveTo(10, 10)
+	dc.LineTo(90, 10)
+	dc.LineTo(90, 90)
+	if !dc.InStroke(94, 6) || dc.InStroke(8, 10) {
+		t.Fatal("unexpected open path stroke")
+	}
+	dc.Stroke()
+	if _, _, _, a := dc.Image().At(94, 6).RGBA(); a == 0 {
+		t.Fatal("expected the miter to be painted")
+	}
+}
+
+func TestLineJoinBevel(t *testing.T) {
+	dc := NewContext(100, 100)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0, 0, 0)
+	dc.SetLineWidth(8)
+	dc.SetLineJoinBevel()
+	dc.SetLineCapButt()
+	dc.DrawRegularPolygon(5, 50, 40, 30, 0)
+	dc.Stroke()
+	dc.SetLineCapSquare()
+	dc.MoveTo(15, 90)
+	dc.LineTo(50, 75)
+	dc.LineTo(85, 90)
+	dc.Stroke()
+	saveImage(dc, "TestLineJoinBevel")
+	checkHash(t, dc, "d9d31c568f3fdc88230d0d589d50e615")
+}
+
+func TestCompositeOperator(t *testing.T) {
+	at := func(dc *Context, x, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(dc.Image().At(x, y)).(color.NRGBA)
+	}
+
+	dc := NewContext(100, 100)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(1, 0, 0)
+	dc.DrawRectangle(0, 0, 60, 100)
+	dc.Fill()
+	dc.Push()
+	dc.SetCompositeOperator(CompositeMultiply)
+	dc.SetRGB(1, 1, 0)
+	dc.DrawRectangle(40, 0, 60, 100)
+	dc.Fill()
+	dc.Pop()
+	if c := at(dc, 20, 50); c != (color.NRGBA{255, 0, 0, 255}) {
+		t.Fatalf("unexpected color outside the source %v", c)
+	}
+	if c := at(dc, 50, 50); c != (color.NRGBA{255, 0, 0, 255}) {
+		t.Fatalf("unexpected multiplied color %v", c)
+	}
+	if c := at(dc, 80, 50); c != (color.NRGBA{255, 255, 0, 255}) {
+		t.Fatalf("unexpected color over white %v", c)
+	}
+	if dc.compositeOp != CompositeSourceOver {
+		t.Fatal("expected Pop to restore the composite operator")
+	}
+
+	// destination-in keeps the destination only under the source, even outside the shape
+	dc.SetCompositeOperator(CompositeDestinationIn)
+	dc.DrawCircle(50, 50, 20)
+	dc.Fill()
+	if c := at(dc, 50, 50); c != (color.NRGBA{255, 0, 0, 255}) {
+		t.Fatalf("unexpected color inside the source %v", c)
+	}
+	if c := at(dc, 5, 5); c.A != 0 {
+		t.Fatalf("expected the destination to be cleared outside the source, got %v", c)
+	}
+
+	// images and text go through the same compositing
+	im := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(im, im.Bounds(), image.NewUniform(color.NRGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+	dc.SetCompositeOperator(CompositeDestinationOver)
+	dc.DrawImage(im, 45, 45)
+	dc.DrawImage(im, 0, 0)
+	if c := at(dc, 50, 50); c != (color.NRGBA{255, 0, 0, 255}) {
+		t.Fatalf("expected destination-over to keep the destination on top, got %v", c)
+	}
+	if c := at(dc, 5, 5); c != (color.NRGBA{0, 0, 255, 255}) {
+		t.Fatalf("expected destination-over to paint under transparent areas, got %v", c)
+	}
+	dc.SetCompositeOperator(CompositeClear)
+	dc.DrawString("gg", 40, 55)
+	cleared := 0
+	for y := 40; y < 60; y++ {
+		for x := 40; x < 60; x++ {
+			if at(dc, x, y).A == 0 {
+				cleared++
+			}
+		}
+	}
+	if cleared == 0 {
+		t.Fatal("expected the text to clear the destination")
+	}
+}
+
+func TestGlobalAlpha(t *testing.T) {
+	dc := NewContext(100, 100)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.Push()
+	dc.SetGlobalAlpha(0.5)
+	dc.SetRGB(0, 0, 0)
+	dc.DrawRectangle(0, 0, 20, 20)
+	dc.Fill()
+	g := NewLinearGradient(20, 0, 40, 0)
+	g.AddColorStop(0, color.Black)
+	g.AddColorStop(1, color.Black)
+	dc.SetFillStyle(g)
+	dc.DrawRectangle(20, 0, 20, 20)
+	dc.Fill()
+	im := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	draw.Draw(im, im.Bounds(), image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	dc.DrawImage(im, 40, 0)
+	dc.DrawString("gg", 10, 60)
+	dc.Pop()
+	dc.SetRGB(0, 0, 0)
+	dc.DrawRectangle(60, 0, 20, 20)
+	dc.Fill()
+
+	for _, c := range []struct {
+		x, y     int
+		expected color.RGBA
+	}{
+		{10, 10, color.RGBA{127, 127, 127, 255}},
+		{30, 10, color.RGBA{127, 127, 127, 255}},
+		{50, 10, color.RGBA{255, 127, 127, 255}},
+		{70, 10, color.RGBA{0, 0, 0, 255}},
+	} {
+		actual := dc.Image().At(c.x, c.y).(color.RGBA)
+		for i, d := range []int{int(actual.R) - int(c.expected.R), int(actual.G) - int(c.expected.G), int(actual.B) - int(c.expected.B)} {
+			if d < -1 || d > 1 {
+				t.Fatalf("unexpected color at %d, %d: %v != %v (channel %d)", c.x, c.y, actual, c.expected, i)
+			}
+		}
+	}
+	for y := 40; y < 70; y++ {
+		for x := 0; x < 40; x++ {
+			if r, _, _, _ := dc.Image().At(x, y).RGBA(); r < 0x7f00 {
+				t.Fatalf("expected faded text, got %v at %d, %d", dc.Image().At(x, y), x, y)
+			}
+		}
+	}
+}
+
+func TestGroups(t *testing.T) {
+	gray := func(dc *Context, x, y int) uint8 {
+		return dc.Image().At(x, y).(color.RGBA).R
+	}
+
+	dc := NewContext(100, 100)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.DrawRectangle(0, 0, 80, 100)
+	dc.Clip()
+	dc.PushGroup()
+	dc.SetRGB(0, 0, 0)
+	dc.DrawRectangle(10, 10, 50, 50)
+	dc.DrawRectangle(40, 40, 50, 50)
+	dc.Fill()
+	dc.PopGroupToSource(0.5, CompositeSourceOver)
+	if a, b := gray(dc, 20, 20), gray(dc, 50, 50); a != b || a < 126 || a > 128 {
+		t.Fatalf("expected overlapping shapes to be faded as one unit, got %d and %d", a, b)
+	}
+	if v := gray(dc, 85, 85); v != 255 {
+		t.Fatalf("expected the group to be clipped, got %d", v)
+	}
+	if len(dc.stack) != 0 || dc.mask == nil {
+		t.Fatal("expected PopGroupToSource to restore the state")
+	}
+
+	dc.ResetClip()
+	dc.PushGroup()
+	dc.SetRGB(1, 0, 0)
+	dc.DrawRectangle(0, 90, 10, 10)
+	dc.Fill()
+	pattern := dc.PopGroup()
+	if v := gray(dc, 5, 95); v != 255 {
+		t.Fatalf("expected PopGroup not to paint, got %d", v)
+	}
+	dc.SetFillStyle(pattern)
+	dc.DrawRectangle(0, 80, 100, 20)
+	dc.Fill()
+	if c := dc.Image().At(5, 95).(color.RGBA); c != (color.RGBA{255, 0, 0, 255}) {
+		t.Fatalf("expected the group pattern to be painted, got %v", c)
+	}
+}
+
+func TestBlur(t *testing.T) {
+	mask := image.NewAlpha(image.Rect(0, 0, 41, 41))
+	mask.SetAlpha(20, 20, color.Alpha{255})
+	blurred := BlurAlpha(mask, 8)
+	if a := blurred.AlphaAt(20, 20).A; a == 0 || a == 255 {
+		t.Fatalf("expected the center to be spread out, got %d", a)
+	}
+	if blurred.AlphaAt(16, 20) != blurred.AlphaAt(24, 20) || blurred.AlphaAt(20, 16) != blurred.AlphaAt(20, 24) {
+		t.Fatal("expected a symmetric blur")
+	}
+	if mask.AlphaAt(20, 20).A != 255 {
+		t.Fatal("expected the source mask to be left unchanged")
+	}
+
+	im := image.NewRGBA(image.Rect(10, 10, 50, 50))
+	draw.Draw(im, image.Rect(20, 20, 40, 40), image.NewUniform(color.NRGBA{255, 0, 0, 128}), image.Point{}, draw.Src)
+	for i, c := 0, BlurRGBA(im, 6); i < len(c.Pix); i += 4 {
+		if c.Pix[i] > c.Pix[i+3] || c.Pix[i+1] != 0 {
+			t.Fatalf("expected a premultiplied blur, got %v", c.Pix[i:i+4])
+		}
+	}
+}
+
+func TestShadow(t *testing.T) {
+	dc := NewContext(100, 100)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetShadow(10, 10, 0, color.Black)
+	dc.SetRGB(1, 0, 0)
+	dc.DrawRectangle(10, 10, 30, 30)
+	dc.Fill()
+	if c := dc.Image().At(20, 20).(color.RGBA); c != (color.RGBA{255, 0, 0, 255}) {
+		t.Fatalf("expected the shape over its shadow, got %v", c)
+	}
+	if c := dc.Image().At(45, 45).(color.RGBA); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Fatalf("expected the shadow, got %v", c)
+	}
+	if c := dc.Image().At(5, 45).(color.RGBA); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Fatalf("expected no shadow, got %v", c)
+	}
+
+	dc.SetShadow(0, 0, 10, color.Black)
+	dc.DrawRectangle(60, 60, 20, 20)
+	dc.Fill()
+	if c := dc.Image().At(82, 70).(color.RGBA); c.R == 255 || c.R == 0 {
+		t.Fatalf("expected a soft shadow, got %v", c)
+	}
+
+	dc.SetShadow(0, 0, 0, color.Transparent)
+	if dc.hasShadow() {
+		t.Fatal("expected a transparent shadow to disable shadows")
+	}
+}
+
+func TestGradientSpread(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	gray := func(c color.Color) uint8 {
+		r, _, _, _ := c.RGBA()
+		return uint8(r >> 8)
+	}
+
+	g := NewLinearGradient(0, 0, 10, 0)
+	g.AddColorStop(0, red)
+	g.AddColorStop(1, blue)
+	if r := gray(g.ColorAt(15, 0)); r != 0 {
+		t.Fatalf("expected a padded gradient, got red %d", r)
+	}
+
+	g.SetSpread(SpreadRepeat)
+	if r0, r1 := gray(g.ColorAt(2, 0)), gray(g.ColorAt(12, 0)); r0 != r1 {
+		t.Fatalf("expected a repeated gradient, got red %d and %d", r0, r1)
+	}
+	if r0, r1 := gray(g.ColorAt(2, 0)), gray(g.ColorAt(-8, 0)); r0 != r1 {
+		t.Fatalf("expected a repeated gradient before the start, got red %d and %d", r0, r1)
+	}
+
+	g.SetSpread(SpreadReflect)
+	if r0, r1 := gray(g.ColorAt(2, 0)), gray(g.ColorAt(18, 0)); r0 != r1 {
+		t.Fatalf("expected a reflected gradient, got red %d and %d", r0, r1)
+	}
+
+	// the period is the distance between the first and last stops
+	g = NewLinearGradient(0, 0, 100, 0)
+	g.AddColorStop(0.2, red)
+	g.AddColorStop(0.4, blue)
+	g.SetSpread(SpreadRepeat)
+	if r0, r1 := gray(g.ColorAt(25, 0)), gray(g.ColorAt(65, 0)); r0 != r1 {
+		t.Fatalf("expected a period of 20 pixels, got red %d and %d", r0, r1)
+	}
+
+	r := NewRadialGradient(50, 50, 0, 50, 50, 10)
+	r.AddColorStop(0, red)
+	r.AddColorStop(1, blue)
+	r.SetSpread(SpreadRepeat)
+	if r0, r1 := gray(r.ColorAt(54, 49)), gray(r.ColorAt(64, 49)); r0 != r1 {
+		t.Fatalf("expected a repeated radial gradient, got red %d and %d", r0, r1)
+	}
+}
+
+func TestPatternMatrix(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+
+	g := NewLinearGradient(0, 0, 10, 0)
+	g.AddColorStop(0, red)
+	g.AddColorStop(1, blue)
+	g.SetMatrix(Translate(50, 0))
+	if c := color.RGBAModel.Convert(g.ColorAt(45, 0)).(color.RGBA); c != red {
+		t.Fatalf("expected the gradient to start at x = 50, got %v", c)
+	}
+	if c := color.RGBAModel.Convert(g.ColorAt(65, 0)).(color.RGBA); c != blue {
+		t.Fatalf("expected the gradient to end at x = 60, got %v", c)
+	}
+
+	im := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	im.Set(0, 0, red)
+	im.Set(1, 0, blue)
+	p := NewSurfacePattern(im, RepeatNone)
+	p.SetMatrix(Scale(10, 10))
+	if c := p.ColorAt(9, 9); c != red {
+		t.Fatalf("expected a scaled image, got %v", c)
+	}
+	if c := p.ColorAt(15, 5); c != blue {
+		t.Fatalf("expected a scaled image, got %v", c)
+	}
+	if _, _, _, a := p.ColorAt(25, 5).RGBA(); a != 0 {
+		t.Fatal("expected nothing outside the scaled image")
+	}
+
+	// patterns follow the transformation only with user space patterns
+	dc := NewContext(100, 100)
+	dc.StartRecording()
+	dc.Translate(50, 0)
+	g = NewLinearGradient(0, 0, 10, 0)
+	g.AddColorStop(0, red)
+	g.AddColorStop(1, blue)
+	dc.SetFillStyle(g)
+	dc.DrawRectangle(-50, 0, 100, 10)
+	dc.Fill()
+	if c := dc.Image().At(45, 5).(color.RGBA); c != blue {
+		t.Fatalf("expected a gradient in device space, got %v", c)
+	}
+	dc.SetUserSpacePatterns(true)
+	dc.DrawRectangle(-50, 0, 100, 10)
+	dc.Fill()
+	if c := dc.Image().At(45, 5).(color.RGBA); c != red {
+		t.Fatalf("expected a gradient in user space, got %v", c)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeSVG(&buf, dc); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `gradientTransform="matrix(1 0 0 1 50 0)"`) {
+		t.Fatal("expected a gradient transform in SVG output")
+	}
+}
+
+func TestGradientInterpolation(t *testing.T) {
+	mid := func(space ColorSpace, hue HueInterpolation, c0, c1 color.Color) color.NRGBA {
+		g := NewLinearGradient(0, 0, 100, 0)
+		g.AddColorStop(0, c0)
+		g.AddColorStop(1, c1)
+		g.SetInterpolation(space, hue)
+		return color.NRGBAModel.Convert(g.ColorAt(50, 0)).(color.NRGBA)
+	}
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+
+	tests := []struct {
+		space    ColorSpace
+		hue      HueInterpolation
+		expected color.NRGBA
+	}{
+		{ColorSpaceSRGB, HueShorter, color.NRGBA{127, 0, 127, 255}},
+		{ColorSpaceLinearSRGB, HueShorter, color.NRGBA{188, 0, 188, 255}},
+		{ColorSpaceOKLab, HueShorter, color.NRGBA{140, 83, 162, 255}},
+		{ColorSpaceHSL, HueShorter, color.NRGBA{255, 0, 255, 255}},
+		{ColorSpaceHSL, HueIncreasing, color.NRGBA{0, 255, 0, 255}},
+	}
+	for _, test := range tests {
+		c := mid(test.space, test.hue, red, blue)
+		for k, v := range []uint8{c.R - test.expected.R, c.G - test.expected.G, c.B - test.expected.B} {
+			if v > 1 && v < 255 {
+				t.Fatalf("color space %d, hue %d: expected %v, got %v (component %d)", test.space, test.hue, test.expected, c, k)
+			}
+		}
+	}
+
+	// OKLCH keeps the chroma between saturated colors
+	if c := mid(ColorSpaceOKLCH, HueShorter, red, blue); c.R < 180 || c.B < 180 {
+		t.Fatalf("expected a saturated midpoint, got %v", c)
+	}
+
+	// premultiplied interpolation doesn't darken towards a transparent stop
+	if c := mid(ColorSpaceLinearSRGB, HueShorter, red, color.Transparent); c.R != 255 || c.G != 0 || c.A < 127 || c.A > 128 {
+		t.Fatalf("expected half transparent red, got %v", c)
+	}
+
+	// premultiplied sRGB too, and vector output approximates it with more stops
+	g := NewLinearGradient(0, 0, 100, 0)
+	g.AddColorStop(0, red)
+	g.AddColorStop(1, color.Transparent)
+	g.(PremultipliedGradient).SetPremultiplied(true)
+	if c := color.NRGBAModel.Convert(g.ColorAt(50, 0)).(color.NRGBA); c.R != 255 || c.G != 0 || c.A < 127 || c.A > 128 {
+		t.Fatalf("expected half transparent red with premultiplied sRGB, got %v", c)
+	}
+	dc := NewContext(100, 10)
+	dc.StartRecording()
+	dc.SetFillStyle(g)
+	dc.DrawRectangle(0, 0, 100, 10)
+	dc.Fill()
+	var b bytes.Buffer
+	if err := EncodeSVG(&b, dc); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(b.String(), "<stop "); n <= 2 {
+		t.Fatalf("expected premultiplied sRGB to be approximated with more than 2 stops, got %d", n)
+	}
+}
+
+func TestGradientColorSpan(t *testing.T) {
+	gradients := []Gradient{
+		NewLinearGradient(0, 0, 100, 100),
+		NewRadialGradient(30, 50, 0, 70, 50, 50),
+		NewConicGradient(50, 50, 90),
+	}
+	spread := NewLinearGradient(20, 0, 40, 0)
+	spread.SetSpread(SpreadReflect)
+	spread.SetMatrix(Rotate(0.5))
+	gradients = append(gradients, spread)
+
+	span := make([]color.RGBA64, 100)
+	for i, g := range gradients {
+		g.AddColorStop(0, color.RGBA{0, 255, 0, 255})
+		g.AddColorStop(1, color.RGBA{0, 0, 255, 128})
+		g.AddColorStop(0.5, color.RGBA{255, 0, 0, 255})
+		for _, size := range []int{0, 1024} {
+			g.(ColorTableGradient).SetColorTable(size)
+			tolerance := 0
+			if size > 0 {
+				tolerance = 1
+			}
+			for y := 0; y < 100; y++ {
+				g.(SpanPattern).ColorSpan(span, 0, y)
+				for x, c := range span {
+					r, g, b, a := g.ColorAt(x, y).RGBA()
+					for k, v := range [][2]uint32{{r, uint32(c.R)}, {g, uint32(c.G)}, {b, uint32(c.B)}, {a, uint32(c.A)}} {
+						if d := int(v[0]>>8) - int(v[1]>>8); d < -tolerance || d > tolerance {
+							t.Fatalf("gradient %d, table of %d colors, at (%d, %d), component %d: ColorAt %d, ColorSpan %d", i, size, x, y, k, v[0]>>8, v[1]>>8)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestGradientConcurrent(t *testing.T) {
+	g := NewLinearGradient(0, 0, 100, 100)
+	g.(ColorTableGradient).SetColorTable(256)
+	g.AddColorStop(0, color.RGBA{0, 255, 0, 255})
+	g.AddColorStop(1, color.RGBA{0, 0, 255, 255})
+
+	// one gradient is painted by contexts on different goroutines; go test -race reports any write while painting
+	var wg sync.WaitGroup
+	images := make([]*image.RGBA, 4)
+	for i := range images {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			dc := NewContext(100, 100)
+			dc.SetFillStyle(g)
+			dc.DrawRectangle(0, 0, 100, 100)
+			dc.Fill()
+			images[i] = dc.Image().(*image.RGBA)
+		}(i)
+	}
+	wg.Wait()
+	for i := range images[1:] {
+		if !bytes.Equal(images[0].Pix, images[i+1].Pix) {
+			t.Fatalf("image %d differs from image 0", i+1)
+		}
+	}
+}
+
+func TestMeshGradients(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	green := color.RGBA{0, 255, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	white := color.RGBA{255, 255, 255, 255}
+	near := func(c color.Color, expected color.RGBA) bool {
+		n := color.RGBAModel.Convert(c).(color.RGBA)
+		for _, d := range []int{int(n.R) - int(expected.R), int(n.G) - int(expected.G), int(n.B) - int(expected.B), int(n.A) - int(expected.A)} {
+			if d < -4 || d > 4 {
+				return false
+			}
+		}
+		return true
+	}
+
+	b := NewBilinearGradient(0, 0, 100, 100, red, green, blue, white)
+	if c := b.ColorAt(0, 0); !near(c, red) {
+		t.Fatalf("expected red in the top left corner, got %v", c)
+	}
+	if c := b.ColorAt(99, 99); !near(c, blue) {
+		t.Fatalf("expected blue in the bottom right corner, got %v", c)
+	}
+	if c := b.ColorAt(49, 49); !near(c, color.RGBA{127, 127, 127, 255}) {
+		t.Fatalf("expected the average of the corners in the center, got %v", c)
+	}
+	if _, _, _, a := b.ColorAt(150, 50).RGBA(); a != 0 {
+		t.Fatal("expected nothing outside the rectangle")
+	}
+
+	// a patch with straight edges over a rectangle is a bilinear gradient
+	m := NewMeshGradient()
+	m.AddPatch([12]Point{
+		{0, 0}, {33, 0}, {67, 0}, {100, 0}, {100, 33}, {100, 67},
+		{100, 100}, {67, 100}, {33, 100}, {0, 100}, {0, 67}, {0, 33},
+	}, [4]color.Color{red, green, blue, white})
+	for _, p := range []image.Point{{5, 5}, {49, 49}, {80, 20}, {30, 90}} {
+		if c0, c1 := m.ColorAt(p.X, p.Y), b.ColorAt(p.X, p.Y); !near(c0, color.RGBAModel.Convert(c1).(color.RGBA)) {
+			t.Fatalf("expected %v at %v, got %v", c1, p, c0)
+		}
+	}
+	if _, _, _, a := m.ColorAt(150, 50).RGBA(); a != 0 {
+		t.Fatal("expected nothing outside the patches")
+	}
+
+	// curved edges bend the patch
+	m = NewMeshGradient()
+	m.AddPatch([12]Point{
+		{0, 0}, {33, 40}, {67, 40}, {100, 0}, {100, 33}, {100, 67},
+		{100, 100}, {67, 100}, {33, 100}, {0, 100}, {0, 67}, {0, 33},
+	}, [4]color.Color{red, red, red, red})
+	if _, _, _, a := m.ColorAt(50, 10).RGBA(); a != 0 {
+		t.Fatal("expected the top edge to curve down")
+	}
+	if c := m.ColorAt(50, 50); !near(c, red) {
+		t.Fatalf("expected red inside the patch, got %v", c)
+	}
+
+	dc := NewContext(100, 100)
+	dc.StartRecording()
+	dc.SetFillStyle(m)
+	dc.DrawRectangle(0, 0, 100, 100)
+	dc.Fill()
+	if c := dc.Image().At(50, 50); !near(c, red) {
+		t.Fatalf("expected the mesh gradient to be painted, got %v", c)
+	}
+	var buf bytes.Buffer
+	if err := EncodePDF(&buf, dc); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("/ShadingType 6")) {
+		t.Fatal("expected a mesh shading in PDF output")
+	}
+
+	// a mesh scaled far beyond the canvas is only rendered over the canvas, from any number of goroutines
+	m.SetMatrix(Translate(50, 50).Scale(1e5, 1e5).Translate(-50, -50))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dc := NewContext(100, 100)
+			dc.SetFillStyle(m)
+			dc.DrawRectangle(0, 0, 100, 100)
+			dc.Fill()
+			if c := dc.Image().At(50, 50); !near(c, red) {
+				t.Errorf("expected the scaled mesh gradient to be painted, got %v", c)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTextPath(t *testing.T) {
+	f, err := FontParse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err := FontNewFace(f, 48)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := StringPath(f, 48, "Hello", 10, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := NewContext(200, 100)
+	dc.SetFontFace(face)
+	w, _ := dc.MeasureString("Hello")
+	x0, y0, x1, y1 := path.Bounds()
+	if math.Abs(x0-10) > 5 || math.Abs(x1-10-w) > 5 || y1 > 61 || y0 > 30 {
+		t.Fatalf("unexpected bounds %v, %v, %v, %v for a string %v wide", x0, y0, x1, y1, w)
+	}
+
+	// the filled outlines cover the same pixels as the drawn string
+	dc.SetRGB(0, 0, 0)
+	dc.DrawString("Hello", 10, 60)
+	outlines := NewContext(200, 100)
+	outlines.SetFontFace(face)
+	outlines.TextPath("Hello", 10, 60)
+	outlines.Fill()
+	same, total := 0, 0
+	for i := 3; i < len(dc.im.Pix); i += 4 {
+		a, b := dc.im.Pix[i] > 127, outlines.im.Pix[i] > 127
+		if a || b {
+			total++
+			if a == b {
+				same++
+			}
+		}
+	}
+	if total == 0 || float64(same)/float64(total) < 0.9 {
+		t.Fatalf("expected the outlines to match the drawn string, %d of %d pixels match", same, total)
+	}
+
+	// text paths follow the transformation and can be used as a clip
+	dc = NewContext(200, 100)
+	dc.SetFontFace(face)
+	dc.Translate(0, -30)
+	dc.TextPath("Hello", 10, 60)
+	dc.Identity()
+	dc.Clip()
+	dc.DrawRectangle(0, 0, 200, 100)
+	dc.Fill()
+	painted := func(y0, y1 int) bool {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < 200; x++ {
+				if dc.im.RGBAAt(x, y).A != 0 {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if !painted(0, 32) || painted(35, 100) {
+		t.Fatal("expected the text clip to move with the transformation")
+	}
+}
+
+func TestDrawStringOnPath(t *testing.T) {
+	f, err := FontParse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err := FontNewFace(f, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ink := func(dc *Context, r image.Rectangle) int {
+		n := 0
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if dc.im.RGBAAt(x, y).A > 127 {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	var line Path
+	line.MoveTo(180, 50)
+	line.LineTo(20, 50)
+	if l := line.Length(); l != 160 {
+		t.Fatalf("expected a length of 160, got %v", l)
+	}
+
+	// following a line from right to left turns the text upside down, below the line
+	dc := NewContext(200, 100)
+	dc.SetFontFace(face)
+	dc.SetRGB(0, 0, 0)
+	dc.DrawStringOnPath("Hello", line, line.Length()/2, AlignCenter)
+	if above, below := ink(dc, image.Rect(0, 0, 200, 50)), ink(dc, image.Rect(0, 50, 200, 100)); above > below {
+		t.Fatalf("expected upside down text below the line, got %d pixels above and %d below", above, below)
+	}
+
+	dc = NewContext(200, 100)
+	dc.SetFontFace(face)
+	dc.SetRGB(0, 0, 0)
+	dc.DrawStringOnPath("Hello", line, line.Length()/2, AlignCenter, true)
+	if above, below := ink(dc, image.Rect(0, 0, 200, 50)), ink(dc, image.Rect(0, 50, 200, 100)); above < below {
+		t.Fatalf("expected upright text above the line, got %d pixels above and %d below", above, below)
+	}
+	if left, right := ink(dc, image.Rect(0, 0, 100, 100)), ink(dc, image.Rect(100, 0, 200, 100)); left == 0 || right == 0 {
+		t.Fatal("expected centered text")
+	}
+
+	// text along a circle stays on the circle
+	var circle Path
+	circle.MoveTo(50, 100)
+	for a := 1; a <= 360; a++ {
+		circle.LineTo(100-50*math.Cos(Radians(float64(a))), 100-50*math.Sin(Radians(float64(a))))
+	}
+	dc = NewContext(200, 200)
+	dc.SetFontFace(face)
+	dc.SetRGB(0, 0, 0)
+	dc.DrawStringOnPath("Around the circle", circle, 0, AlignLeft)
+	if n := ink(dc, image.Rect(70, 70, 130, 130)); n != 0 {
+		t.Fatalf("expected nothing in the middle of the circle, got %d pixels", n)
+	}
+	if n := ink(dc, image.Rect(0, 0, 200, 200)); n == 0 {
+		t.Fatal("expected text along the circle")
+	}
+
+	// a glyph and its combining mark form one cluster, and the clusters take the shaped advances, kerning included
+	shaped := shapeString(face, "Ve\u0301")
+	clusters := glyphClusters("Ve\u0301", shaped)
+	if len(clusters) != 2 || len(clusters[1].glyphs) != 2 || clusters[1].start != 1 || clusters[1].end != 4 {
+		t.Fatalf("unexpected clusters %+v", clusters)
+	}
+	if x := unfix(shaped.glyphs[1].dot.X); clusters[0].width != x || clusters[1].x != x {
+		t.Fatalf("expected the second cluster at %v, got %v after a cluster %v wide", x, clusters[1].x, clusters[0].width)
+	}
+	if w := clusters[1].x + clusters[1].width; w != unfix(shaped.advance) {
+		t.Fatalf("expected the clusters to end at %v, got %v", unfix(shaped.advance), w)
+	}
+}
+
+func TestRichText(t *testing.T) {
+	f, err := FontParse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err := FontNewFace(f, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dc := NewContext(200, 200)
+	dc.SetFontFace(face)
+	dc.SetRGB(0, 0, 0)
+	spans := []TextSpan{
+		{Text: "Hello "},
+		{Text: "wor", Font: f, Size: 40, Color: color.RGBA{255, 0, 0, 255}},
+		{Text: "ld and more words", Underline: true},
+	}
+
+	// a word split across spans stays on one line, and the larger span makes its line taller
+	layout := dc.LayoutRichText(spans, 150, 1, AlignRight)
+	if len(layout.Lines) < 2 {
+		t.Fatalf("expected wrapped lines, got %d", len(layout.Lines))
+	}
+	first := layout.Lines[0]
+	if len(first.Runs) != 3 || first.Runs[1].Text != "wor" || first.Runs[2].Text != "ld" {
+		t.Fatalf("unexpected runs on the first line: %+v", first.Runs)
+	}
+	if first.Height <= layout.Lines[1].Height {
+		t.Fatalf("expected a taller first line, got %v and %v", first.Height, layout.Lines[1].Height)
+	}
+	for _, line := range layout.Lines {
+		if line.Width > 150 || math.Abs(line.X+line.Width-150) > 1e-9 {
+			t.Fatalf("expected right-aligned lines no wider than 150, got %+v", line)
+		}
+	}
+	if w, h := dc.MeasureRichText(spans, 150, 1); w != 150 || h != layout.Height {
+		t.Fatalf("expected a size of 150x%v, got %vx%v", layout.Height, w, h)
+	}
+	if n := len(dc.LayoutRichText([]TextSpan{{Text: "a\n\nb"}}, 0, 1, AlignLeft).Lines); n != 3 {
+		t.Fatalf("expected 3 lines, got %d", n)
+	}
+
+	// the current path survives drawing, the span colors are used and the underline is drawn
+	dc.MoveTo(0, 0)
+	dc.LineTo(10, 0)
+	dc.DrawRichTextLayout(layout, 0, 0)
+	if len(dc.CopyPath()) == 0 {
+		t.Fatal("expected the current path to be kept")
+	}
+	red := 0
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			if c := dc.im.RGBAAt(x, y); c.R > 200 && c.A > 200 {
+				red++
+			}
+		}
+	}
+	if red == 0 {
+		t.Fatal("expected red text")
+	}
+	last := layout.Lines[len(layout.Lines)-1]
+	run := last.Runs[len(last.Runs)-1]
+	uy := int(run.Y + unfix(face.Metrics().Descent)/4)
+	for x := int(run.X) + 1; x < int(run.X+run.Width)-1; x++ {
+		if dc.im.RGBAAt(x, uy).A == 0 {
+			t.Fatalf("expected an underline at (%d, %d)", x, uy)
+		}
+	}
+}
+
+func BenchmarkCircles(b *testing.B) {
+	dc := NewContext(1000, 1000)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	rnd := rand.New(rand.NewSource(99))
+	for i := 0; i < b.N; i++ {
+		x := rnd.Float64() * 1000
+		y := rnd.Float64() * 1000
+		dc.DrawCircle(x, y, 10)
+		if i%2 == 0 {
+			dc.SetRGB(0, 0, 0)
+		} else {
+			dc.SetRGB(1, 1, 1)
+		}
+		dc.Fill()
+	}
+}
+
+// colorAtPattern hides the ColorSpan method of a pattern, to benchmark painting it pixel by pixel.
+type colorAtPattern struct {
+	Pattern
+}
+
+// benchmarkGradient paints a gradient from a color table of the given size, or from ColorSpan without a table, the
+// default, if the size is zero, or pixel by pixel from ColorAt if the size is negative.
+func benchmarkGradient(b *testing.B, g Gradient, table int) {
+	g.AddColorStop(0, color.RGBA{0, 255, 0, 255})
+	g.AddColorStop(0.5, color.RGBA{255, 0, 0, 255})
+	g.AddColorStop(1, color.RGBA{0, 0, 255, 255})
+	var p Pattern = g
+	if table < 0 {
+		p = colorAtPattern{g}
+	} else {
+		g.(ColorTableGradient).SetColorTable(table)
+	}
+	dc := NewContext(1920, 1080)
+	dc.SetFillStyle(p)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dc.DrawRectangle(0, 0, 1920, 1080)
+		dc.Fill()
+	}
+}
+
+func BenchmarkLinearGradient(b *testing.B) {
+	benchmarkGradient(b, NewLinearGradient(0, 0, 1920, 1080), 0)
+}
+
+func BenchmarkLinearGradientTable(b *testing.B) {
+	benchmarkGradient(b, NewLinearGradient(0, 0, 1920, 1080), 1024)
+}
+
+func BenchmarkLinearGradientColorAt(b *testing.B) {
+	benchmarkGradient(b, NewLinearGradient(0, 0, 1920, 1080), -1)
+}
+
+func BenchmarkRadialGradient(b *testing.B) {
+	benchmarkGradient(b, NewRadialGradient(960, 540, 0, 960, 540, 800), 0)
+}
+
+func BenchmarkRadialGradientTable(b *testing.B) {
+	benchmarkGradient(b, NewRadialGradient(960, 540, 0, 960, 540, 800), 1024)
+}
+
+func BenchmarkRadialGradientColorAt(b *testing.B) {
+	benchmarkGradient(b, NewRadialGradient(960, 540, 0, 960, 540, 800), -1)
+}
+
+func BenchmarkConicGradient(b *testing.B) {
+	benchmarkGradient(b, NewConicGradient(960, 540, 90), 0)
+}
+
+func BenchmarkConicGradientTable(b *testing.B) {
+	benchmarkGradient(b, NewConicGradient(960, 540, 90), 1024)
+}
+
+func BenchmarkConicGradientColorAt(b *testing.B) {
+	benchmarkGradient(b, NewConicGradient(960, 540, 90), -1)
 }
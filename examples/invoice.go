@@ -30,6 +30,7 @@ func main() {
 	)
 
 	dc := gg.NewContext(W, H)
+	dc.StartRecording()
 
 	dc.SetRGB(1, 1, 1)
 	dc.Clear()
@@ -125,4 +126,8 @@ func main() {
 	if err := gg.SavePNG("./testdata/_invoice.png", dc.Image()); err != nil {
 		log.Fatalf("could not save to file: %+v", err)
 	}
+
+	if err := gg.SavePDF("./testdata/_invoice.pdf", dc); err != nil {
+		log.Fatalf("could not save to file: %+v", err)
+	}
 }
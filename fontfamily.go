@@ -0,0 +1,160 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// fontFamily is the font.Face returned by NewFontFamily.
+type fontFamily struct {
+	faces []font.Face
+	index map[rune]int
+}
+
+// NewFontFamily returns a font face that draws every rune with the first of the given faces that has a glyph for it,
+// falling back on the first face for runes that none of them has. Set it with SetFontFace to use it for drawing,
+// measuring and wrapping text, for example with a Latin face first, then a CJK face and an emoji face.
+//
+// The family has the metrics of its first face, and only kerns pairs of runes drawn with the same face. Closing the
+// family doesn't close its faces. Without faces, the family falls back on the default face of a context.
+func NewFontFamily(faces ...font.Face) font.Face {
+	if len(faces) == 0 {
+		faces = []font.Face{basicfont.Face7x13}
+	}
+
+	return &fontFamily{faces: faces, index: make(map[rune]int)}
+}
+
+// faceIndex returns the index of the face that draws a rune.
+func (f *fontFamily) faceIndex(r rune) int {
+	if i, ok := f.index[r]; ok {
+		return i
+	}
+
+	i := 0
+	for j, face := range f.faces {
+		if _, ok := face.GlyphAdvance(r); ok {
+			i = j
+			break
+		}
+	}
+	f.index[r] = i
+
+	return i
+}
+
+// face returns the face that draws a rune.
+func (f *fontFamily) face(r rune) font.Face {
+	return f.faces[f.faceIndex(r)]
+}
+
+// Close does nothing, as the family doesn't own its faces.
+func (f *fontFamily) Close() error {
+	return nil
+}
+
+// Glyph returns the glyph of a rune drawn with the face that has it.
+func (f *fontFamily) Glyph(dot fixed.Point26_6, r rune) (
+	dr image.Rectangle, mask image.Image, maskp image.Point, advance fixed.Int26_6, ok bool) {
+	return f.face(r).Glyph(dot, r)
+}
+
+// GlyphBounds returns the bounds and advance of a rune drawn with the face that has it.
+func (f *fontFamily) GlyphBounds(r rune) (bounds fixed.Rectangle26_6, advance fixed.Int26_6, ok bool) {
+	return f.face(r).GlyphBounds(r)
+}
+
+// GlyphAdvance returns the advance of a rune drawn with the face that has it.
+func (f *fontFamily) GlyphAdvance(r rune) (advance fixed.Int26_6, ok bool) {
+	return f.face(r).GlyphAdvance(r)
+}
+
+// Kern returns the kerning between two runes drawn with the same face, or zero between runes of different faces.
+func (f *fontFamily) Kern(r0, r1 rune) fixed.Int26_6 {
+	i := f.faceIndex(r0)
+	if i != f.faceIndex(r1) {
+		return 0
+	}
+
+	return f.faces[i].Kern(r0, r1)
+}
+
+// Metrics returns the metrics of the first face of the family.
+func (f *fontFamily) Metrics() font.Metrics {
+	return f.faces[0].Metrics()
+}
+
+// faceRun is a part of a string drawn with a single face, starting at an offset along the baseline.
+type faceRun struct {
+	face   font.Face
+	text   string
+	offset float64
+}
+
+// faceRuns splits a string into the runs of the faces of a font family that draw it, with their offsets in pixels.
+// Faces of nested families are expanded too, and other faces draw the whole string. The offset of a run is the
+// leftmost pen position of its glyphs once the string is shaped, so that it follows kerning and bidirectional order.
+func faceRuns(face font.Face, s string) []faceRun {
+	f, ok := face.(*fontFamily)
+	if !ok {
+		return []faceRun{{face, s, 0}}
+	}
+
+	clusters := glyphClusters(s, shapeString(f, s))
+	var runs []faceRun
+	offset := 0.0
+	add := func(face int, start, end int) {
+		left := math.Inf(1)
+		for _, c := range clusters {
+			if c.start >= start && c.start < end {
+				left = min(left, c.x)
+			}
+		}
+		if !math.IsInf(left, 1) {
+			offset = left
+		}
+		for _, run := range faceRuns(f.faces[face], s[start:end]) {
+			run.offset += offset
+			runs = append(runs, run)
+		}
+	}
+
+	start, last := 0, -1
+	for i, r := range s {
+		if j := f.faceIndex(r); j != last {
+			if last >= 0 {
+				add(last, start, i)
+			}
+			start, last = i, j
+		}
+	}
+	if last >= 0 {
+		add(last, start, len(s))
+	}
+
+	return runs
+}
+
+// hasOutlines tells whether the glyphs of a face, or of all the faces of a font family, have outlines.
+func hasOutlines(face font.Face) bool {
+	if f, ok := face.(*fontFamily); ok {
+		for _, face := range f.faces {
+			if !hasOutlines(face) {
+				return false
+			}
+		}
+		return true
+	}
+
+	_, _, ok := faceFont(face)
+
+	return ok
+}
@@ -5,6 +5,5 @@ go 1.21
 require (
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0
 	golang.org/x/image v0.13.0
+	golang.org/x/text v0.13.0
 )
-
-require golang.org/x/text v0.13.0 // indirect
@@ -5,6 +5,7 @@
 package gg
 
 import (
+	"image"
 	"image/color"
 	"math"
 	"sort"
@@ -38,6 +39,15 @@ func (s stops) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 
+// SpreadMode specifies how a gradient is painted beyond its first and last color stops.
+type SpreadMode int
+
+const (
+	SpreadPad     SpreadMode = iota // The colors of the first and last stops are extended, the default.
+	SpreadRepeat                    // The stops are repeated.
+	SpreadReflect                   // The stops are repeated, every other time in reverse order.
+)
+
 // Gradient is an interface representing a gradient pattern that can be used to
 // fill shapes with smooth color transitions.
 type Gradient interface {
@@ -49,13 +59,67 @@ type Gradient interface {
 	// define where colors change within the gradient. The stops are sorted in ascending order based
 	// on their offset values.
 	AddColorStop(offset float64, color color.Color)
+
+	// SetSpread sets how the gradient is painted beyond its first and last color stops.
+	//
+	// With SpreadRepeat and SpreadReflect, the gradient repeats with a period equal to the distance
+	// between the first and the last color stop, like the repeating gradients of CSS. This applies to
+	// linear gradients on both sides of the gradient vector, to radial gradients inside the start
+	// circle and outside the end circle, and to conic gradients around their center.
+	SetSpread(mode SpreadMode)
+
+	// SetMatrix sets the transformation from the gradient's space to the space it is painted in.
+	//
+	// Gradients are painted in device space, so their points and radii are normally in pixels of the image. The matrix
+	// moves, scales, rotates or shears the gradient instead; see Context.SetUserSpacePatterns to also make it follow the
+	// context's transformation. A matrix that can't be inverted is ignored.
+	SetMatrix(m Matrix)
+
+	// SetInterpolation sets the color space in which the gradient interpolates between its color stops.
+	//
+	// The default, ColorSpaceSRGB, interpolates gamma-encoded sRGB. The other color spaces interpolate with premultiplied
+	// alpha, and hue is the direction around the hue circle for ColorSpaceOKLCH and ColorSpaceHSL. Vector output
+	// approximates them with additional sRGB color stops.
+	SetInterpolation(space ColorSpace, hue HueInterpolation)
+}
+
+// PremultipliedGradient is an optional interface for gradients that can interpolate gamma-encoded sRGB with
+// premultiplied alpha. The linear, radial and conic gradients implement it.
+type PremultipliedGradient interface {
+	Gradient
+
+	// SetPremultiplied sets whether the gradient interpolates with premultiplied alpha in ColorSpaceSRGB too.
+	//
+	// The other color spaces always interpolate with premultiplied alpha. By default, ColorSpaceSRGB interpolates 8-bit
+	// components, and vector output interpolates its stops with straight alpha, which darkens towards transparent stops.
+	// With premultiplied alpha, sRGB is interpolated like the other color spaces, with 16-bit components, and vector
+	// output approximates it with additional color stops.
+	SetPremultiplied(enabled bool)
+}
+
+// ColorTableGradient is an optional interface for gradients that can be painted from a precomputed color table. The
+// linear, radial and conic gradients implement it.
+type ColorTableGradient interface {
+	Gradient
+
+	// SetColorTable sets the number of colors of a lookup table that the gradient is painted from.
+	//
+	// By default every pixel is painted with the color returned by ColorAt. With a table, for instance of 256 or 1024
+	// colors, the colors are computed once and whole spans of pixels are painted with incremental math, which is much
+	// faster, but the colors can differ slightly from those of ColorAt. A size of zero turns the table off again.
+	SetColorTable(size int)
 }
 
 // linearGradient represents a linear gradient that can be used as a pattern
 // to fill shapes with colors transitioning between specified points.
 type linearGradient struct {
+	patternMatrix
 	x0, y0, x1, y1 float64
 	stops          stops
+	spread         SpreadMode
+	interpolation  colorInterpolation
+	tableSize      int
+	table          *colorTable
 }
 
 // ColorAt returns the color at the specified (x, y) coordinate within the linear gradient.
@@ -69,17 +133,29 @@ func (g *linearGradient) ColorAt(x, y int) color.Color {
 	}
 
 	fx, fy := float64(x), float64(y)
+	if g.transformed {
+		fx, fy = g.point(x, y)
+		fx, fy = fx-0.5, fy-0.5
+	}
 	x0, y0, x1, y1 := g.x0, g.y0, g.x1, g.y1
 	dx, dy := x1-x0, y1-y0
 
+	if g.spread != SpreadPad {
+		mag2 := dx*dx + dy*dy
+		if mag2 == 0 {
+			return g.stops[0].color
+		}
+		return getColor(spreadPos((dx*(fx-x0)+dy*(fy-y0))/mag2, g.stops, g.spread), g.stops, g.interpolation)
+	}
+
 	// Horizontal
 	if dy == 0 && dx != 0 {
-		return getColor((fx-x0)/dx, g.stops)
+		return getColor((fx-x0)/dx, g.stops, g.interpolation)
 	}
 
 	// Vertical
 	if dx == 0 && dy != 0 {
-		return getColor((fy-y0)/dy, g.stops)
+		return getColor((fy-y0)/dy, g.stops, g.interpolation)
 	}
 
 	// Dot product
@@ -94,7 +170,46 @@ func (g *linearGradient) ColorAt(x, y int) color.Color {
 	x2, y2 := x0+u*-dy, y0+u*dx
 	d := math.Hypot(fx-x2, fy-y2) / mag
 
-	return getColor(d, g.stops)
+	return getColor(d, g.stops, g.interpolation)
+}
+
+// ColorSpan sets dst to the colors of a span of pixels, starting at (x, y).
+//
+// The colors are those returned by ColorAt, unless the gradient has a color table. Then the positions along the
+// gradient are computed incrementally and the colors are looked up in the table, so they can differ slightly.
+func (g *linearGradient) ColorSpan(dst []color.RGBA64, x, y int) {
+	table := g.table
+	if table == nil {
+		colorAtSpan(g, dst, x, y)
+		return
+	}
+
+	dx, dy := g.x1-g.x0, g.y1-g.y0
+	mag2 := dx*dx + dy*dy
+	if mag2 == 0 {
+		c := table.at(math.Inf(1))
+		if g.spread != SpreadPad {
+			c = table.at(math.Inf(-1))
+		}
+		for i := range dst {
+			dst[i] = c
+		}
+		return
+	}
+
+	fx, fy := float64(x), float64(y)
+	stepX, stepY := 1.0, 0.0
+	if g.transformed {
+		fx, fy = g.point(x, y)
+		fx, fy = fx-0.5, fy-0.5
+		stepX, stepY = g.inverse.XX, g.inverse.YX
+	}
+
+	t0 := (dx*(fx-g.x0) + dy*(fy-g.y0)) / mag2
+	dt := (dx*stepX + dy*stepY) / mag2
+	for i := range dst {
+		dst[i] = table.at(spreadPos(t0+float64(i)*dt, g.stops, g.spread))
+	}
 }
 
 // AddColorStop appends a color stop to the linear gradient at the specified offset.
@@ -105,6 +220,39 @@ func (g *linearGradient) ColorAt(x, y int) color.Color {
 func (g *linearGradient) AddColorStop(offset float64, color color.Color) {
 	g.stops = append(g.stops, stop{pos: offset, color: color})
 	sort.Sort(g.stops)
+	g.table = newColorTable(g.stops, g.interpolation, g.tableSize)
+}
+
+// SetSpread sets how the linear gradient is painted before its first and after its last color stop.
+func (g *linearGradient) SetSpread(mode SpreadMode) {
+	g.spread = mode
+}
+
+// SetInterpolation sets the color space in which the linear gradient interpolates between its color stops.
+func (g *linearGradient) SetInterpolation(space ColorSpace, hue HueInterpolation) {
+	g.interpolation.space, g.interpolation.hue = space, hue
+	g.table = newColorTable(g.stops, g.interpolation, g.tableSize)
+}
+
+// SetPremultiplied sets whether the linear gradient interpolates with premultiplied alpha in ColorSpaceSRGB too.
+func (g *linearGradient) SetPremultiplied(enabled bool) {
+	g.interpolation.premultiplied = enabled
+	g.table = newColorTable(g.stops, g.interpolation, g.tableSize)
+}
+
+// SetColorTable sets the number of colors of the lookup table that the linear gradient is painted from, or turns it
+// off with a size of zero.
+func (g *linearGradient) SetColorTable(size int) {
+	g.tableSize = max(size, 0)
+	g.table = newColorTable(g.stops, g.interpolation, g.tableSize)
+}
+
+// withMatrix returns a copy of the linear gradient whose transformation is followed by m.
+func (g *linearGradient) withMatrix(m Matrix) Pattern {
+	c := *g
+	c.patternMatrix = g.patternMatrix.then(m)
+
+	return &c
 }
 
 // NewLinearGradient creates a new linear gradient pattern that spans from point (x0, y0) to point (x1, y1).
@@ -125,10 +273,15 @@ type circle struct {
 
 // radialGradient represents a radial gradient pattern defined by three circles, colors, and stops.
 type radialGradient struct {
-	c0, c1, cd circle
-	a, inva    float64
-	mindr      float64
-	stops      stops
+	patternMatrix
+	c0, c1, cd    circle
+	a, inva       float64
+	mindr         float64
+	stops         stops
+	spread        SpreadMode
+	interpolation colorInterpolation
+	tableSize     int
+	table         *colorTable
 }
 
 // dot3 calculates the dot product of two 3D vectors (x0, y0, z0) and (x1, y1, z1).
@@ -150,7 +303,8 @@ func (g *radialGradient) ColorAt(x, y int) color.Color {
 	}
 
 	// copy from pixman's pixman-radial-gradient.c
-	dx, dy := float64(x)+0.5-g.c0.x, float64(y)+0.5-g.c0.y
+	px, py := g.point(x, y)
+	dx, dy := px-g.c0.x, py-g.c0.y
 	b := dot3(dx, dy, g.c0.r, g.cd.x, g.cd.y, g.cd.r)
 	c := dot3(dx, dy, -g.c0.r, dx, dy, g.c0.r)
 
@@ -160,7 +314,7 @@ func (g *radialGradient) ColorAt(x, y int) color.Color {
 		}
 		t := 0.5 * c / b
 		if t*g.cd.r >= g.mindr {
-			return getColor(t, g.stops)
+			return getColor(spreadPos(t, g.stops, g.spread), g.stops, g.interpolation)
 		}
 		return color.Transparent
 	}
@@ -172,19 +326,97 @@ func (g *radialGradient) ColorAt(x, y int) color.Color {
 		t1 := (b - sqrtdiscr) * g.inva
 
 		if t0*g.cd.r >= g.mindr {
-			return getColor(t0, g.stops)
+			return getColor(spreadPos(t0, g.stops, g.spread), g.stops, g.interpolation)
 		} else if t1*g.cd.r >= g.mindr {
-			return getColor(t1, g.stops)
+			return getColor(spreadPos(t1, g.stops, g.spread), g.stops, g.interpolation)
 		}
 	}
 
 	return color.Transparent
 }
 
+// ColorSpan sets dst to the colors of a span of pixels, starting at (x, y). The colors are those returned by ColorAt,
+// unless the gradient has a color table. Then they are looked up in the table, so they can differ slightly.
+func (g *radialGradient) ColorSpan(dst []color.RGBA64, x, y int) {
+	table := g.table
+	if table == nil {
+		colorAtSpan(g, dst, x, y)
+		return
+	}
+
+	px, py := g.point(x, y)
+	stepX, stepY := 1.0, 0.0
+	if g.transformed {
+		stepX, stepY = g.inverse.XX, g.inverse.YX
+	}
+
+	for i := range dst {
+		dx := px + float64(i)*stepX - g.c0.x
+		dy := py + float64(i)*stepY - g.c0.y
+		b := dot3(dx, dy, g.c0.r, g.cd.x, g.cd.y, g.cd.r)
+		c := dot3(dx, dy, -g.c0.r, dx, dy, g.c0.r)
+
+		dst[i] = color.RGBA64{}
+		if g.a == 0 {
+			if b == 0 {
+				continue
+			}
+			if t := 0.5 * c / b; t*g.cd.r >= g.mindr {
+				dst[i] = table.at(spreadPos(t, g.stops, g.spread))
+			}
+			continue
+		}
+
+		discr := dot3(b, g.a, 0, b, -c, 0)
+		if discr < 0 {
+			continue
+		}
+		sqrtdiscr := math.Sqrt(discr)
+		if t0 := (b + sqrtdiscr) * g.inva; t0*g.cd.r >= g.mindr {
+			dst[i] = table.at(spreadPos(t0, g.stops, g.spread))
+		} else if t1 := (b - sqrtdiscr) * g.inva; t1*g.cd.r >= g.mindr {
+			dst[i] = table.at(spreadPos(t1, g.stops, g.spread))
+		}
+	}
+}
+
 // AddColorStop adds a color stop to the gradient at the specified offset.
 func (g *radialGradient) AddColorStop(offset float64, color color.Color) {
 	g.stops = append(g.stops, stop{pos: offset, color: color})
 	sort.Sort(g.stops)
+	g.table = newColorTable(g.stops, g.interpolation, g.tableSize)
+}
+
+// SetSpread sets how the radial gradient is painted inside its first and outside its last color stop.
+func (g *radialGradient) SetSpread(mode SpreadMode) {
+	g.spread = mode
+}
+
+// SetInterpolation sets the color space in which the radial gradient interpolates between its color stops.
+func (g *radialGradient) SetInterpolation(space ColorSpace, hue HueInterpolation) {
+	g.interpolation.space, g.interpolation.hue = space, hue
+	g.table = newColorTable(g.stops, g.interpolation, g.tableSize)
+}
+
+// SetPremultiplied sets whether the radial gradient interpolates with premultiplied alpha in ColorSpaceSRGB too.
+func (g *radialGradient) SetPremultiplied(enabled bool) {
+	g.interpolation.premultiplied = enabled
+	g.table = newColorTable(g.stops, g.interpolation, g.tableSize)
+}
+
+// SetColorTable sets the number of colors of the lookup table that the radial gradient is painted from, or turns it
+// off with a size of zero.
+func (g *radialGradient) SetColorTable(size int) {
+	g.tableSize = max(size, 0)
+	g.table = newColorTable(g.stops, g.interpolation, g.tableSize)
+}
+
+// withMatrix returns a copy of the radial gradient whose transformation is followed by m.
+func (g *radialGradient) withMatrix(m Matrix) Pattern {
+	c := *g
+	c.patternMatrix = g.patternMatrix.then(m)
+
+	return &c
 }
 
 // NewRadialGradient creates a new radial gradient object based on the specified parameters.
@@ -216,9 +448,14 @@ func NewRadialGradient(x0, y0, r0, x1, y1, r1 float64) Gradient {
 
 // conicGradient represents a conic (angular) gradient.
 type conicGradient struct {
-	cx, cy   float64
-	rotation float64
-	stops    stops
+	patternMatrix
+	cx, cy        float64
+	rotation      float64
+	stops         stops
+	spread        SpreadMode
+	interpolation colorInterpolation
+	tableSize     int
+	table         *colorTable
 }
 
 // ColorAt returns the color at the specified coordinates (x, y) within the conic gradient.
@@ -232,14 +469,53 @@ func (g *conicGradient) ColorAt(x, y int) color.Color {
 		return color.Transparent
 	}
 
-	a := math.Atan2(float64(y)-g.cy, float64(x)-g.cx)
+	fx, fy := float64(x), float64(y)
+	if g.transformed {
+		fx, fy = g.point(x, y)
+		fx, fy = fx-0.5, fy-0.5
+	}
+
+	a := math.Atan2(fy-g.cy, fx-g.cx)
 	t := norm(a, -math.Pi, math.Pi) - g.rotation
 
 	if t < 0 {
 		t += 1
 	}
 
-	return getColor(t, g.stops)
+	return g.colorAt(t)
+}
+
+// ColorSpan sets dst to the colors of a span of pixels, starting at (x, y). The colors are those returned by ColorAt,
+// unless the gradient has a color table. Then the angles are approximated and the colors are looked up in the table,
+// so they can differ slightly.
+func (g *conicGradient) ColorSpan(dst []color.RGBA64, x, y int) {
+	table := g.table
+	if table == nil {
+		colorAtSpan(g, dst, x, y)
+		return
+	}
+
+	fx, fy := float64(x), float64(y)
+	stepX, stepY := 1.0, 0.0
+	if g.transformed {
+		fx, fy = g.point(x, y)
+		fx, fy = fx-0.5, fy-0.5
+		stepX, stepY = g.inverse.XX, g.inverse.YX
+	}
+
+	for i := range dst {
+		a := fastAtan2(fy+float64(i)*stepY-g.cy, fx+float64(i)*stepX-g.cx)
+		t := norm(a, -math.Pi, math.Pi) - g.rotation
+		if t < 0 {
+			t += 1
+		}
+		dst[i] = table.at(spreadPos(t, g.stops, g.spread))
+	}
+}
+
+// colorAt returns the color of the conic gradient at the fraction t of a turn from its start angle.
+func (g *conicGradient) colorAt(t float64) color.Color {
+	return getColor(spreadPos(t, g.stops, g.spread), g.stops, g.interpolation)
 }
 
 // AddColorStop adds a color stop to the conic gradient at the specified offset position.
@@ -250,6 +526,39 @@ func (g *conicGradient) ColorAt(x, y int) color.Color {
 func (g *conicGradient) AddColorStop(offset float64, color color.Color) {
 	g.stops = append(g.stops, stop{pos: offset, color: color})
 	sort.Sort(g.stops)
+	g.table = newColorTable(g.stops, g.interpolation, g.tableSize)
+}
+
+// SetSpread sets how the conic gradient is painted before its first and after its last color stop.
+func (g *conicGradient) SetSpread(mode SpreadMode) {
+	g.spread = mode
+}
+
+// SetInterpolation sets the color space in which the conic gradient interpolates between its color stops.
+func (g *conicGradient) SetInterpolation(space ColorSpace, hue HueInterpolation) {
+	g.interpolation.space, g.interpolation.hue = space, hue
+	g.table = newColorTable(g.stops, g.interpolation, g.tableSize)
+}
+
+// SetPremultiplied sets whether the conic gradient interpolates with premultiplied alpha in ColorSpaceSRGB too.
+func (g *conicGradient) SetPremultiplied(enabled bool) {
+	g.interpolation.premultiplied = enabled
+	g.table = newColorTable(g.stops, g.interpolation, g.tableSize)
+}
+
+// SetColorTable sets the number of colors of the lookup table that the conic gradient is painted from, or turns it
+// off with a size of zero.
+func (g *conicGradient) SetColorTable(size int) {
+	g.tableSize = max(size, 0)
+	g.table = newColorTable(g.stops, g.interpolation, g.tableSize)
+}
+
+// withMatrix returns a copy of the conic gradient whose transformation is followed by m.
+func (g *conicGradient) withMatrix(m Matrix) Pattern {
+	c := *g
+	c.patternMatrix = g.patternMatrix.then(m)
+
+	return &c
 }
 
 // NewConicGradient creates a new conic gradient with the specified center coordinates and rotation angle in degrees.
@@ -289,12 +598,137 @@ func norm(value, a, b float64) float64 {
 	return (value - a) * (1.0 / (b - a))
 }
 
+// spreadPos maps a position along a gradient into the range of its stops according to a spread mode.
+//
+// With SpreadPad the position is returned unchanged, since getColor already extends the first and last stops.
+func spreadPos(pos float64, stops stops, mode SpreadMode) float64 {
+	first, last := stops[0].pos, stops[len(stops)-1].pos
+	period := last - first
+	if mode == SpreadPad || period <= 0 {
+		return pos
+	}
+
+	t := (pos - first) / period
+	switch mode {
+	case SpreadRepeat:
+		t -= math.Floor(t)
+	case SpreadReflect:
+		t = math.Mod(math.Abs(t), 2)
+		if t > 1 {
+			t = 2 - t
+		}
+	}
+
+	return first + t*period
+}
+
+// interpolationSteps is the number of sRGB segments between two color stops that approximate the other color spaces
+// in vector output.
+const interpolationSteps = 16
+
+// srgbStops returns color stops that approximate the gradient between stops in sRGB, for output formats that can
+// only interpolate sRGB with straight alpha. The stops are returned unchanged for the default sRGB interpolation.
+func srgbStops(stops stops, interpolation colorInterpolation) stops {
+	if interpolation.straight() || len(stops) < 2 {
+		return stops
+	}
+
+	result := stops[:1:1]
+	for i, s := range stops[1:] {
+		prev := stops[i]
+		for k := 1; k < interpolationSteps; k++ {
+			t := float64(k) / interpolationSteps
+			result = append(result, stop{prev.pos + (s.pos-prev.pos)*t, interpolation.lerp(prev.color, s.color, t)})
+		}
+		result = append(result, s)
+	}
+
+	return result
+}
+
+// colorTable is a lookup table of the premultiplied colors of a gradient between its first and last color stops,
+// which is built whenever the gradient changes and used to paint whole spans of pixels.
+type colorTable struct {
+	colors      []color.RGBA64
+	first, last float64
+	scale       float64
+}
+
+// newColorTable samples size colors of a gradient between its first and last stops. It returns nil, for no table, if
+// the size is zero or the gradient has no stops.
+func newColorTable(stops stops, interpolation colorInterpolation, size int) *colorTable {
+	if size <= 0 || len(stops) == 0 {
+		return nil
+	}
+	t := &colorTable{first: stops[0].pos, last: stops[len(stops)-1].pos}
+
+	n := size
+	if t.last <= t.first {
+		n = 1
+	} else {
+		t.scale = float64(n-1) / (t.last - t.first)
+	}
+	t.colors = make([]color.RGBA64, n)
+	for i := range t.colors {
+		pos := t.first
+		if n > 1 {
+			pos += float64(i) / t.scale
+		}
+		t.colors[i] = color.RGBA64Model.Convert(getColor(pos, stops, interpolation)).(color.RGBA64)
+	}
+
+	return t
+}
+
+// at returns the color at a position along the gradient, extending the first and last colors.
+func (t *colorTable) at(pos float64) color.RGBA64 {
+	if !(pos > t.first) {
+		return t.colors[0]
+	}
+	if pos >= t.last {
+		return t.colors[len(t.colors)-1]
+	}
+
+	return t.colors[int((pos-t.first)*t.scale+0.5)]
+}
+
+// colorAtSpan sets dst to the colors returned by the ColorAt method of a pattern for a span of pixels.
+func colorAtSpan(p Pattern, dst []color.RGBA64, x, y int) {
+	for i := range dst {
+		r, g, b, a := p.ColorAt(x+i, y).RGBA()
+		dst[i] = color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+	}
+}
+
+// fastAtan2 approximates math.Atan2 with a polynomial, to within about 1e-5 radians.
+func fastAtan2(y, x float64) float64 {
+	ax, ay := math.Abs(x), math.Abs(y)
+	if ax == 0 && ay == 0 {
+		return 0
+	}
+
+	z := math.Min(ax, ay) / math.Max(ax, ay)
+	z2 := z * z
+	a := z * (0.99997726 + z2*(-0.33262347+z2*(0.19354346+z2*(-0.11643287+z2*(0.05265332+z2*-0.01172120)))))
+	if ay > ax {
+		a = math.Pi/2 - a
+	}
+	if x < 0 {
+		a = math.Pi - a
+	}
+	if y < 0 {
+		a = -a
+	}
+
+	return a
+}
+
 // getColor returns the interpolated color at the specified position along a gradient.
 //
 // This function calculates and returns the interpolated color at a given position
 // within a gradient defined by color stops. It linearly interpolates between two
-// adjacent stops based on the specified position.
-func getColor(pos float64, stops stops) color.Color {
+// adjacent stops based on the specified position, in the given color space.
+func getColor(pos float64, stops stops, interpolation colorInterpolation) color.Color {
 	if pos <= 0.0 || len(stops) == 1 {
 		return stops[0].color
 	}
@@ -308,7 +742,7 @@ func getColor(pos float64, stops stops) color.Color {
 	for i, stop := range stops[1:] {
 		if pos < stop.pos {
 			pos = (pos - stops[i].pos) / (stop.pos - stops[i].pos)
-			return colorLerp(stops[i].color, stop.color, pos)
+			return interpolation.lerp(stops[i].color, stop.color, pos)
 		}
 	}
 
@@ -339,3 +773,329 @@ func colorLerp(c0, c1 color.Color, t float64) color.Color {
 func lerp(a, b uint32, t float64) uint8 {
 	return uint8(int32(float64(a)*(1.0-t)+float64(b)*t) >> 8)
 }
+
+// BilinearGradient is an interface representing a gradient that interpolates between the colors of the four corners
+// of a rectangle.
+type BilinearGradient interface {
+	Pattern
+
+	// SetMatrix sets the transformation from the gradient's space to the space it is painted in, like Gradient.SetMatrix.
+	SetMatrix(m Matrix)
+}
+
+// bilinearGradient represents a four-corner gradient over a rectangle.
+type bilinearGradient struct {
+	patternMatrix
+	x0, y0, x1, y1 float64
+	colors         [4][4]float64
+}
+
+// ColorAt returns the color at the specified (x, y) coordinate within the bilinear gradient, which is transparent
+// outside its rectangle.
+func (g *bilinearGradient) ColorAt(x, y int) color.Color {
+	px, py := g.point(x, y)
+
+	return g.colorAt(px, py)
+}
+
+// ColorSpan sets dst to the colors of a span of pixels, starting at (x, y).
+func (g *bilinearGradient) ColorSpan(dst []color.RGBA64, x, y int) {
+	px, py := g.point(x, y)
+	stepX, stepY := 1.0, 0.0
+	if g.transformed {
+		stepX, stepY = g.inverse.XX, g.inverse.YX
+	}
+
+	for i := range dst {
+		dst[i] = g.colorAt(px+float64(i)*stepX, py+float64(i)*stepY)
+	}
+}
+
+// colorAt returns the color of the bilinear gradient at a point of its space.
+func (g *bilinearGradient) colorAt(px, py float64) color.RGBA64 {
+	if g.x1 == g.x0 || g.y1 == g.y0 {
+		return color.RGBA64{}
+	}
+
+	u := (px - g.x0) / (g.x1 - g.x0)
+	v := (py - g.y0) / (g.y1 - g.y0)
+	if u < 0 || u > 1 || v < 0 || v > 1 {
+		return color.RGBA64{}
+	}
+
+	return bilinearColor(g.colors, u, v)
+}
+
+// patches returns the bilinear gradient as a single patch of a mesh gradient with straight edges.
+func (g *bilinearGradient) patches() []meshPatch {
+	corners := [4]Point{{g.x0, g.y0}, {g.x1, g.y0}, {g.x1, g.y1}, {g.x0, g.y1}}
+
+	var p meshPatch
+	for i, c := range corners {
+		next := corners[(i+1)%4]
+		p.points[i*3] = c
+		p.points[i*3+1] = c.Interpolate(next, 1.0/3)
+		p.points[i*3+2] = c.Interpolate(next, 2.0/3)
+	}
+	p.colors = g.colors
+
+	return []meshPatch{p}
+}
+
+// withMatrix returns a copy of the bilinear gradient whose transformation is followed by m.
+func (g *bilinearGradient) withMatrix(m Matrix) Pattern {
+	c := *g
+	c.patternMatrix = g.patternMatrix.then(m)
+
+	return &c
+}
+
+// NewBilinearGradient creates a new four-corner gradient over the rectangle from (x0, y0) to (x1, y1).
+//
+// The colors are those of the corners (x0, y0), (x1, y0), (x1, y1) and (x0, y1), in that order, and are interpolated
+// bilinearly with premultiplied alpha. The gradient is transparent outside the rectangle.
+func NewBilinearGradient(x0, y0, x1, y1 float64, c0, c1, c2, c3 color.Color) BilinearGradient {
+	return &bilinearGradient{
+		x0: x0, y0: y0,
+		x1: x1, y1: y1,
+		colors: [4][4]float64{premultiplied(c0), premultiplied(c1), premultiplied(c2), premultiplied(c3)},
+	}
+}
+
+// MeshGradient is an interface representing a gradient made of Coons patches, like the type 6 shadings of PDF.
+type MeshGradient interface {
+	Pattern
+
+	// AddPatch adds a Coons patch to the mesh gradient, painted over the patches added before it.
+	//
+	// The boundary of the patch is made of four cubic Bézier curves. The points go around the boundary: points 0, 3, 6
+	// and 9 are the corners of the patch and the other points are the control points of the curves between them, so
+	// the last curve goes from point 9 through points 10 and 11 back to point 0. The colors are those of the corners,
+	// in the same order, and are interpolated bilinearly across the patch with premultiplied alpha.
+	AddPatch(points [12]Point, colors [4]color.Color)
+
+	// SetMatrix sets the transformation from the gradient's space to the space it is painted in, like Gradient.SetMatrix.
+	SetMatrix(m Matrix)
+}
+
+// meshPatch is a Coons patch of a mesh gradient, with premultiplied corner colors.
+type meshPatch struct {
+	points [12]Point
+	colors [4][4]float64
+}
+
+// point returns the point of the patch at the parameters (u, v), where u goes along the first curve and v from the
+// first curve to the third.
+func (p *meshPatch) point(u, v float64) Point {
+	curve := func(i int, t float64) Point {
+		a, b, c, d := p.points[i], p.points[i+1], p.points[i+2], p.points[(i+3)%12]
+		x, y := cubic(a.X, a.Y, b.X, b.Y, c.X, c.Y, d.X, d.Y, t)
+		return Point{x, y}
+	}
+
+	c0 := curve(0, u)
+	c1 := curve(6, 1-u)
+	d0 := curve(9, 1-v)
+	d1 := curve(3, v)
+	k0, k1, k2, k3 := p.points[0], p.points[3], p.points[6], p.points[9]
+
+	return Point{
+		X: (1-v)*c0.X + v*c1.X + (1-u)*d0.X + u*d1.X -
+			((1-u)*(1-v)*k0.X + u*(1-v)*k1.X + u*v*k2.X + (1-u)*v*k3.X),
+		Y: (1-v)*c0.Y + v*c1.Y + (1-u)*d0.Y + u*d1.Y -
+			((1-u)*(1-v)*k0.Y + u*(1-v)*k1.Y + u*v*k2.Y + (1-u)*v*k3.Y),
+	}
+}
+
+// meshGradient represents a gradient made of Coons patches. It is rendered in device space into a cache, which only
+// covers the area being painted.
+type meshGradient struct {
+	patternMatrix
+	patchList []meshPatch
+	cache     *meshCache
+}
+
+// meshCache holds the premultiplied colors of a rendered mesh gradient over an area. Only the part of the area
+// covered by the patches, rect, is stored.
+type meshCache struct {
+	area image.Rectangle
+	rect image.Rectangle
+	pix  []color.RGBA64
+}
+
+// ColorAt returns the color at the specified (x, y) coordinate within the mesh gradient, which is transparent
+// outside its patches.
+func (g *meshGradient) ColorAt(x, y int) color.Color {
+	return g.cacheOver(image.Rect(x, y, x+1, y+1)).at(x, y)
+}
+
+// ColorSpan sets dst to the colors of a span of pixels, starting at (x, y).
+func (g *meshGradient) ColorSpan(dst []color.RGBA64, x, y int) {
+	cache := g.cacheOver(image.Rect(x, y, x+len(dst), y+1))
+	for i := range dst {
+		dst[i] = cache.at(x+i, y)
+	}
+}
+
+// AddPatch adds a Coons patch to the mesh gradient, painted over the patches added before it.
+func (g *meshGradient) AddPatch(points [12]Point, colors [4]color.Color) {
+	p := meshPatch{points: points}
+	for i, c := range colors {
+		p.colors[i] = premultiplied(c)
+	}
+	g.patchList = append(g.patchList, p)
+}
+
+// patches returns the patches of the mesh gradient.
+func (g *meshGradient) patches() []meshPatch {
+	return g.patchList
+}
+
+// withMatrix returns a copy of the mesh gradient whose transformation is followed by m.
+func (g *meshGradient) withMatrix(m Matrix) Pattern {
+	c := *g
+	c.patternMatrix = g.patternMatrix.then(m)
+	c.cache = nil
+
+	return &c
+}
+
+// withCache returns a copy of the mesh gradient that is painted from a cache of its colors over r.
+func (g *meshGradient) withCache(r image.Rectangle) Pattern {
+	c := *g
+	c.cache = g.render(r)
+
+	return &c
+}
+
+// cacheOver returns the cache of the mesh gradient if it covers r, and otherwise renders the mesh gradient over r.
+func (g *meshGradient) cacheOver(r image.Rectangle) *meshCache {
+	if g.cache != nil && r.In(g.cache.area) {
+		return g.cache
+	}
+
+	return g.render(r)
+}
+
+// render renders the mesh gradient over the area r into a new cache. Every patch is divided into a grid of small
+// quadrilaterals, about four pixels wide, which are filled as pairs of Gouraud-shaded triangles.
+func (g *meshGradient) render(area image.Rectangle) *meshCache {
+	type vertex struct {
+		p Point
+		c [4]float64
+	}
+
+	m := g.current()
+	var grids [][][]vertex
+	bounds := image.Rectangle{}
+	for i := range g.patchList {
+		patch := &g.patchList[i]
+
+		length := 0.0
+		for k, p := range patch.points {
+			q := patch.points[(k+1)%12]
+			x0, y0 := m.TransformPoint(p.X, p.Y)
+			x1, y1 := m.TransformPoint(q.X, q.Y)
+			length += math.Hypot(x1-x0, y1-y0)
+		}
+		n := int(math.Max(2, math.Min(64, math.Ceil(length/16))))
+
+		grid := make([][]vertex, n+1)
+		for row := range grid {
+			grid[row] = make([]vertex, n+1)
+			for col := range grid[row] {
+				u, v := float64(col)/float64(n), float64(row)/float64(n)
+				p := patch.point(u, v)
+				p.X, p.Y = m.TransformPoint(p.X, p.Y)
+				c := bilinearColor(patch.colors, u, v)
+				grid[row][col] = vertex{p, [4]float64{float64(c.R), float64(c.G), float64(c.B), float64(c.A)}}
+				bounds = bounds.Union(image.Rect(int(math.Floor(p.X)), int(math.Floor(p.Y)), int(math.Ceil(p.X))+1, int(math.Ceil(p.Y))+1))
+			}
+		}
+		grids = append(grids, grid)
+	}
+
+	bounds = bounds.Intersect(area)
+	cache := &meshCache{area: area, rect: bounds, pix: make([]color.RGBA64, bounds.Dx()*bounds.Dy())}
+	for _, grid := range grids {
+		for row := 0; row+1 < len(grid); row++ {
+			for col := 0; col+1 < len(grid[row]); col++ {
+				a, b := grid[row][col], grid[row][col+1]
+				c, d := grid[row+1][col+1], grid[row+1][col]
+				cache.triangle([3]Point{a.p, b.p, c.p}, [3][4]float64{a.c, b.c, c.c})
+				cache.triangle([3]Point{a.p, c.p, d.p}, [3][4]float64{a.c, c.c, d.c})
+			}
+		}
+	}
+
+	return cache
+}
+
+// at returns the color of the pixel (x, y) of the cache, which is transparent outside of it.
+func (c *meshCache) at(x, y int) color.RGBA64 {
+	if !image.Pt(x, y).In(c.rect) {
+		return color.RGBA64{}
+	}
+
+	return c.pix[(y-c.rect.Min.Y)*c.rect.Dx()+x-c.rect.Min.X]
+}
+
+// triangle sets the pixels of the cache whose centers are inside a triangle to the colors of its vertices,
+// interpolated with barycentric coordinates.
+func (c *meshCache) triangle(p [3]Point, colors [3][4]float64) {
+	area := (p[1].X-p[0].X)*(p[2].Y-p[0].Y) - (p[2].X-p[0].X)*(p[1].Y-p[0].Y)
+	if area == 0 {
+		return
+	}
+
+	x0 := int(math.Floor(math.Min(p[0].X, math.Min(p[1].X, p[2].X))))
+	y0 := int(math.Floor(math.Min(p[0].Y, math.Min(p[1].Y, p[2].Y))))
+	x1 := int(math.Ceil(math.Max(p[0].X, math.Max(p[1].X, p[2].X))))
+	y1 := int(math.Ceil(math.Max(p[0].Y, math.Max(p[1].Y, p[2].Y))))
+	r := image.Rect(x0, y0, x1, y1).Intersect(c.rect)
+
+	// points on an edge shared by two triangles are filled by both, so that there are no gaps between them
+	const eps = -1e-9
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		py := float64(y) + 0.5
+		for x := r.Min.X; x < r.Max.X; x++ {
+			px := float64(x) + 0.5
+			w0 := ((p[1].X-px)*(p[2].Y-py) - (p[2].X-px)*(p[1].Y-py)) / area
+			w1 := ((p[2].X-px)*(p[0].Y-py) - (p[0].X-px)*(p[2].Y-py)) / area
+			w2 := 1 - w0 - w1
+			if w0 < eps || w1 < eps || w2 < eps {
+				continue
+			}
+
+			var v [4]uint16
+			for k := range v {
+				v[k] = uint16(math.Round(math.Max(0, math.Min(0xffff, w0*colors[0][k]+w1*colors[1][k]+w2*colors[2][k]))))
+			}
+			c.pix[(y-c.rect.Min.Y)*c.rect.Dx()+x-c.rect.Min.X] = color.RGBA64{v[0], v[1], v[2], v[3]}
+		}
+	}
+}
+
+// NewMeshGradient creates a new, empty mesh gradient. Patches are added with AddPatch.
+func NewMeshGradient() MeshGradient {
+	return &meshGradient{}
+}
+
+// premultiplied returns the premultiplied components of a color, between 0 and 1.
+func premultiplied(c color.Color) [4]float64 {
+	r, g, b, a := c.RGBA()
+
+	return [4]float64{float64(r) / 0xffff, float64(g) / 0xffff, float64(b) / 0xffff, float64(a) / 0xffff}
+}
+
+// bilinearColor interpolates between the premultiplied colors of the corners (0, 0), (1, 0), (1, 1) and (0, 1) at (u, v).
+func bilinearColor(colors [4][4]float64, u, v float64) color.RGBA64 {
+	var c [4]uint16
+	for k := range c {
+		top := colors[0][k] + (colors[1][k]-colors[0][k])*u
+		bottom := colors[3][k] + (colors[2][k]-colors[3][k])*u
+		c[k] = uint16(math.Round(math.Max(0, math.Min(1, top+(bottom-top)*v)) * 0xffff))
+	}
+
+	return color.RGBA64{c[0], c[1], c[2], c[3]}
+}
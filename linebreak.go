@@ -0,0 +1,373 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"strings"
+	"unicode"
+)
+
+// breakClass is a line breaking class of the Unicode line breaking algorithm, see https://unicode.org/reports/tr14/.
+type breakClass uint8
+
+const (
+	breakAL  breakClass = iota // Alphabetic, the default.
+	breakBK                    // Mandatory break.
+	breakCR                    // Carriage return.
+	breakLF                    // Line feed.
+	breakNL                    // Next line.
+	breakSP                    // Space.
+	breakZW                    // Zero width space.
+	breakZWJ                   // Zero width joiner.
+	breakWJ                    // Word joiner.
+	breakGL                    // Non-breaking glue.
+	breakCM                    // Combining mark.
+	breakBA                    // Break after.
+	breakBB                    // Break before.
+	breakB2                    // Break on either side, but not between a pair.
+	breakHY                    // Hyphen.
+	breakCB                    // Contingent break.
+	breakCL                    // Close punctuation.
+	breakCP                    // Close parenthesis.
+	breakEX                    // Exclamation and interrogation.
+	breakIN                    // Inseparable.
+	breakNS                    // Nonstarter.
+	breakOP                    // Open punctuation.
+	breakQU                    // Quotation.
+	breakIS                    // Infix numeric separator.
+	breakNU                    // Numeric.
+	breakPO                    // Postfix numeric.
+	breakPR                    // Prefix numeric.
+	breakSY                    // Symbol allowing a break after.
+	breakID                    // Ideographic.
+	breakSA                    // Complex context dependent, like Thai.
+)
+
+const (
+	softHyphen     = '\u00ad'
+	zeroWidthSpace = '\u200b'
+)
+
+// lineBreakClass returns the line breaking class of a rune, with the classes that the algorithm resolves first, AI, SG,
+// XX and CJ, already resolved like CSS does for normal line breaking. Complex context dependent marks are combining
+// marks, and Hangul syllables are ideographic.
+func lineBreakClass(r rune) breakClass {
+	switch r {
+	case '\t', '|', softHyphen, '\u058a', '\u2010', '\u2012', '\u2013', '\u3000', '\u0e5a', '\u0e5b':
+		return breakBA
+	case '\n':
+		return breakLF
+	case '\r':
+		return breakCR
+	case '\v', '\f', '\u2028', '\u2029':
+		return breakBK
+	case '\u0085':
+		return breakNL
+	case ' ':
+		return breakSP
+	case '!', '?', '\uff01', '\uff1f':
+		return breakEX
+	case '"', '\'', '\u00ab', '\u00bb', '\u2018', '\u2019', '\u201b', '\u201c', '\u201d', '\u201f', '\u2039', '\u203a':
+		return breakQU
+	case '$', '+', '\\', '\u00a3', '\u00a4', '\u00a5', '\u00b1', '\u2116', '\uff04':
+		return breakPR
+	case '%', '\u00a2', '\u00b0', '\u2103', '\uff05':
+		return breakPO
+	case '(', '[', '{', '\u00a1', '\u00bf', '\u201a', '\u201e', '\u301d', '\uff08', '\uff3b', '\uff5b', '\uff5f', '\uff62':
+		return breakOP
+	case ')', ']', '\uff09', '\uff3d':
+		return breakCP
+	case '}', '\u3001', '\u3002', '\u301e', '\u301f', '\uff0c', '\uff0e', '\uff5d', '\uff60', '\uff61', '\uff63', '\uff64':
+		return breakCL
+	case ',', '.', ':', ';', '\u037e', '\u2044':
+		return breakIS
+	case '-':
+		return breakHY
+	case '/':
+		return breakSY
+	case '\u00a0', '\u034f', '\u2007', '\u2011', '\u202f', '\u180e', '\u0f0c':
+		return breakGL
+	case '\u00b4', '\u02c8', '\u02cc':
+		return breakBB
+	case '\u2014', '\u2e3a', '\u2e3b':
+		return breakB2
+	case zeroWidthSpace:
+		return breakZW
+	case '\u200c':
+		return breakCM
+	case '\u200d':
+		return breakZWJ
+	case '\u2060', '\ufeff':
+		return breakWJ
+	case '\u2024', '\u2025', '\u2026', '\ufe19':
+		return breakIN
+	case '\ufffc':
+		return breakCB
+	case '\u203c', '\u203d', '\u3005', '\u301c', '\u303b', '\u303c', '\u309b', '\u309c', '\u309d', '\u309e',
+		'\u30a0', '\u30fb', '\u30fd', '\u30fe', '\uff1a', '\uff1b', '\uff65':
+		return breakNS
+	}
+
+	switch {
+	case r < ' ' || r >= '\u007f' && r < '\u00a0':
+		return breakCM
+	case r >= '\u2000' && r <= '\u200a':
+		return breakBA
+	case r >= '\u2030' && r <= '\u2037':
+		return breakPO
+	case r >= '\u20a0' && r <= '\u20cf':
+		return breakPR
+	case r >= '\u3008' && r <= '\u3011', r >= '\u3014' && r <= '\u301b':
+		// the CJK brackets alternate between opening and closing
+		if r%2 == 0 {
+			return breakOP
+		}
+		return breakCL
+	case r >= '\u0e50' && r <= '\u0e59', r >= '\u0ed0' && r <= '\u0ed9':
+		return breakNU
+	case unicode.In(r, unicode.Mn, unicode.Mc, unicode.Me):
+		return breakCM
+	case r >= '\u1160' && r <= '\u11ff':
+		// Hangul vowel and trailing jamo stay with their leading jamo
+		return breakCM
+	case r >= '\U0001f3fb' && r <= '\U0001f3ff':
+		// emoji modifiers stay with their base
+		return breakCM
+	case r >= '\u0e00' && r <= '\u0eff', r >= '\u1000' && r <= '\u109f', r >= '\u1780' && r <= '\u17ff',
+		r >= '\u1a20' && r <= '\u1aaf':
+		return breakSA
+	case r >= '\u1100' && r <= '\u115f', r >= '\u2e80' && r <= '\u2fff', r >= '\u3003' && r <= '\u33ff',
+		r >= '\u3400' && r <= '\u4dbf', r >= '\u4e00' && r <= '\u9fff', r >= '\ua000' && r <= '\ua4cf',
+		r >= '\uac00' && r <= '\ud7a3', r >= '\uf900' && r <= '\ufaff', r >= '\ufe30' && r <= '\ufe4f',
+		r >= '\uff00' && r <= '\uffef', r >= '\U0001f000' && r <= '\U0001faff', r >= '\U00020000' && r <= '\U0003fffd':
+		return breakID
+	case unicode.Is(unicode.Nd, r):
+		return breakNU
+	case unicode.Is(unicode.Ps, r):
+		return breakOP
+	case unicode.Is(unicode.Pe, r):
+		return breakCL
+	case unicode.In(r, unicode.Pi, unicode.Pf):
+		return breakQU
+	case unicode.Is(unicode.Zs, r):
+		return breakBA
+	}
+
+	return breakAL
+}
+
+// lineSegment is a part of a text between two line break opportunities. It includes the spaces that follow it.
+type lineSegment struct {
+	text      string
+	mandatory bool // Whether the line must break after the segment.
+}
+
+// lineSegments splits a text at its line break opportunities, following the pair rules of the Unicode line breaking
+// algorithm. Hangul jamo sequences, regional indicator pairs and emoji modifiers are simplified, and Thai and Lao, which
+// need a dictionary to be broken between words, are broken between syllables only where a vowel makes it obvious.
+func lineSegments(s string) []lineSegment {
+	var segments []lineSegment
+	start := 0
+
+	var prevRune rune
+	prev := breakClass(0)      // the class of the previous rune, combining marks resolved to their base
+	lastSolid := breakClass(0) // the class of the last rune that is not a space
+	afterZW := false           // whether the spaces before the current rune follow a zero width space
+
+	for i, r := range s {
+		cur := lineBreakClass(r)
+		if i == 0 {
+			if cur == breakCM || cur == breakZWJ {
+				cur = breakAL
+			}
+			prev, lastSolid, prevRune = cur, cur, r
+			afterZW = cur == breakZW
+			continue
+		}
+
+		brk, mandatory := lineBreakBetween(prev, cur, lastSolid, afterZW, prevRune, r)
+		if brk {
+			segments = append(segments, lineSegment{s[start:i], mandatory})
+			start = i
+		}
+
+		switch {
+		case cur == breakCM || cur == breakZWJ:
+			// LB9 and LB10: combining marks take the class of their base, unless it is a space or a break
+			switch prev {
+			case breakBK, breakCR, breakLF, breakNL, breakSP, breakZW:
+				prev, lastSolid = breakAL, breakAL
+			}
+			if cur == breakZWJ {
+				prev = breakZWJ
+			}
+		case cur == breakSP:
+			prev = cur
+		default:
+			prev, lastSolid = cur, cur
+			afterZW = cur == breakZW
+		}
+		prevRune = r
+	}
+
+	if start < len(s) {
+		segments = append(segments, lineSegment{s[start:], lineBreakClass(prevRune) == breakBK})
+	}
+
+	return segments
+}
+
+// lineBreakBetween tells whether a line can break between two runes of the given classes, and whether it must.
+// lastSolid is the class of the last rune that is not a space, and afterZW whether it is a zero width space.
+func lineBreakBetween(prev, cur, lastSolid breakClass, afterZW bool, prevRune, r rune) (brk, mandatory bool) {
+	switch {
+	case prev == breakBK || prev == breakLF || prev == breakNL || prev == breakCR && cur != breakLF:
+		return true, true
+	case cur == breakBK || cur == breakCR || cur == breakLF || cur == breakNL:
+		return false, false
+	case cur == breakSP || cur == breakZW:
+		return false, false
+	case afterZW && (prev == breakZW || prev == breakSP):
+		return true, false
+	case prev == breakZWJ:
+		return false, false
+	case cur == breakCM || cur == breakZWJ:
+		if prev != breakSP {
+			return false, false
+		}
+		// a combining mark after a space stands alone, as a letter
+		cur = breakAL
+	}
+
+	if prev == breakSA && cur == breakSA {
+		return syllableBreak(prevRune, r), false
+	}
+	if prev == breakSA {
+		prev = breakAL
+	}
+	if cur == breakSA {
+		cur = breakAL
+	}
+	if lastSolid == breakSA {
+		lastSolid = breakAL
+	}
+
+	switch {
+	case prev == breakWJ || cur == breakWJ:
+		return false, false
+	case prev == breakGL:
+		return false, false
+	case cur == breakGL && prev != breakSP && prev != breakBA && prev != breakHY:
+		return false, false
+	case cur == breakCL || cur == breakCP || cur == breakEX || cur == breakIS || cur == breakSY:
+		return false, false
+	case lastSolid == breakOP:
+		return false, false
+	case lastSolid == breakQU && cur == breakOP:
+		return false, false
+	case (lastSolid == breakCL || lastSolid == breakCP) && cur == breakNS:
+		return false, false
+	case lastSolid == breakB2 && cur == breakB2:
+		return false, false
+	case prev == breakSP:
+		return true, false
+	case prev == breakQU || cur == breakQU:
+		return false, false
+	case prev == breakCB || cur == breakCB:
+		return true, false
+	case cur == breakBA || cur == breakHY || cur == breakNS || prev == breakBB:
+		return false, false
+	case cur == breakIN:
+		return false, false
+	case prev == breakAL && cur == breakNU || prev == breakNU && cur == breakAL:
+		return false, false
+	case prev == breakPR && cur == breakID || prev == breakID && cur == breakPO:
+		return false, false
+	case (prev == breakPR || prev == breakPO) && cur == breakAL || prev == breakAL && (cur == breakPR || cur == breakPO):
+		return false, false
+	case numericPair(prev, cur):
+		return false, false
+	case prev == breakAL && cur == breakAL:
+		return false, false
+	case prev == breakIS && cur == breakAL:
+		return false, false
+	case (prev == breakAL || prev == breakNU) && cur == breakOP || prev == breakCP && (cur == breakAL || cur == breakNU):
+		return false, false
+	}
+
+	return true, false
+}
+
+// numericPair tells whether two classes are kept together in a number, like "$(12.50)" or "-3%", after LB25.
+func numericPair(prev, cur breakClass) bool {
+	switch cur {
+	case breakNU:
+		switch prev {
+		case breakPO, breakPR, breakHY, breakIS, breakNU, breakSY:
+			return true
+		}
+	case breakPO, breakPR:
+		return prev == breakCL || prev == breakCP || prev == breakNU
+	case breakOP:
+		return prev == breakPO || prev == breakPR
+	}
+
+	return false
+}
+
+// syllableBreak tells whether a line can break between two runes of Thai or Lao. Without a dictionary, it only breaks
+// before the vowels that are written before their consonant, and after the vowels and marks that end a syllable.
+func syllableBreak(prev, r rune) bool {
+	switch {
+	case r >= '\u0e40' && r <= '\u0e44', r >= '\u0ec0' && r <= '\u0ec4':
+		// a vowel written before its consonant starts a syllable, unless it follows another one
+		return !(prev >= '\u0e40' && prev <= '\u0e44' || prev >= '\u0ec0' && prev <= '\u0ec4')
+	case r == '\u0e46' || r == '\u0ec6':
+		return false
+	}
+
+	switch prev {
+	case '\u0e2f', '\u0e30', '\u0e33', '\u0e46', '\u0eaf', '\u0eb0', '\u0eb3', '\u0ec6':
+		return true
+	}
+
+	return false
+}
+
+// lineText returns a line of wrapped text as it is drawn: without its surrounding spaces, zero width spaces and soft
+// hyphens, except for a soft hyphen at its end, which becomes a visible hyphen.
+func lineText(s string) string {
+	s = strings.TrimSpace(s)
+	hyphen := strings.HasSuffix(s, string(softHyphen))
+	if strings.ContainsRune(s, softHyphen) || strings.ContainsRune(s, zeroWidthSpace) {
+		s = strings.Map(func(r rune) rune {
+			if r == softHyphen || r == zeroWidthSpace {
+				return -1
+			}
+			return r
+		}, s)
+		s = strings.TrimSpace(s)
+	}
+	if hyphen {
+		s += "-"
+	}
+
+	return s
+}
+
+// clusterBreaks returns the byte offsets inside s where it can be broken between characters, keeping combining marks
+// and joined characters with their base.
+func clusterBreaks(s string) []int {
+	var breaks []int
+	prev := rune(-1)
+	for i, r := range s {
+		if prev >= 0 && prev != '\u200d' && r != '\u200d' && lineBreakClass(r) != breakCM {
+			breaks = append(breaks, i)
+		}
+		prev = r
+	}
+
+	return breaks
+}
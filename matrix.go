@@ -118,3 +118,19 @@ func (a Matrix) Rotate(angle float64) Matrix {
 func (a Matrix) Shear(x, y float64) Matrix {
 	return Shear(x, y).Multiply(a)
 }
+
+// Invert returns the inverse of matrix 'a', which undoes its transformation. The second result is false
+// if the matrix is singular and has no inverse.
+func (a Matrix) Invert() (Matrix, bool) {
+	det := a.XX*a.YY - a.YX*a.XY
+	if det == 0 || math.IsNaN(det) || math.IsInf(det, 0) {
+		return Matrix{}, false
+	}
+
+	return Matrix{
+		a.YY / det, -a.YX / det,
+		-a.XY / det, a.XX / det,
+		(a.XY*a.Y0 - a.YY*a.X0) / det,
+		(a.YX*a.X0 - a.XX*a.Y0) / det,
+	}, true
+}
@@ -0,0 +1,160 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"image"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// TextMetrics holds the measurements of a string drawn with a font face, in pixels.
+type TextMetrics struct {
+	Width     float64 // The advance width of the string, which MeasureString rounds down to whole pixels.
+	Ascent    float64 // The height of the face above the baseline.
+	Descent   float64 // The depth of the face below the baseline.
+	LineGap   float64 // The space the face leaves between the descent of a line and the ascent of the next one.
+	XHeight   float64 // The height of the lowercase letters, or zero if the face doesn't tell.
+	CapHeight float64 // The height of the uppercase letters, or zero if the face doesn't tell.
+
+	// InkX1, InkY1, InkX2 and InkY2 are the tight bounding box of the glyphs as drawn, relative to the origin of the
+	// string on its baseline, with y growing downwards. The box is empty, all zeros, for strings without ink.
+	InkX1, InkY1, InkX2, InkY2 float64
+}
+
+// TextBox selects the box of a string that the anchors of DrawStringAnchored are relative to.
+type TextBox int
+
+const (
+	TextBoxLine     TextBox = iota // The box of MeasureString: the advance width, and the font height above the baseline.
+	TextBoxBaseline                // The advance width on the baseline: ay leaves the baseline at y.
+	TextBoxInk                     // The tight bounding box of the ink of the glyphs.
+	TextBoxEm                      // The advance width, from the ascent of the face down to its descent.
+)
+
+// MeasureText measures a single-line text string drawn with the current font face.
+//
+// Unlike MeasureString, whose height is the font height, it returns the metrics of the face, its ascent, descent,
+// line gap, x-height and cap height, and the tight bounding box of the ink of the string. The string is measured as
+// horizontal text, whatever the writing mode.
+func (dc *Context) MeasureText(s string) TextMetrics {
+	m := dc.fontFace.Metrics()
+	shaped := shapeString(dc.fontFace, s)
+	tm := TextMetrics{
+		Width:     unfix(shaped.advance),
+		Ascent:    unfix(m.Ascent),
+		Descent:   unfix(m.Descent),
+		LineGap:   unfix(m.Height - m.Ascent - m.Descent),
+		XHeight:   unfix(m.XHeight),
+		CapHeight: unfix(m.CapHeight),
+	}
+
+	var ink fixed.Rectangle26_6
+	for _, g := range shaped.glyphs {
+		if b, ok := g.bounds(); ok {
+			ink = ink.Union(b.Add(g.dot))
+		}
+	}
+	if !ink.Empty() {
+		tm.InkX1, tm.InkY1 = unfix(ink.Min.X), unfix(ink.Min.Y)
+		tm.InkX2, tm.InkY2 = unfix(ink.Max.X), unfix(ink.Max.Y)
+	}
+
+	return tm
+}
+
+// textBox returns a box of a string relative to its origin on the baseline, with y growing downwards.
+func (dc *Context) textBox(s string, box TextBox) (x1, y1, x2, y2 float64) {
+	switch box {
+	case TextBoxBaseline:
+		w, _ := dc.MeasureString(s)
+		return 0, 0, w, 0
+	case TextBoxInk:
+		tm := dc.MeasureText(s)
+		return tm.InkX1, tm.InkY1, tm.InkX2, tm.InkY2
+	case TextBoxEm:
+		tm := dc.MeasureText(s)
+		return 0, -tm.Ascent, tm.Width, tm.Descent
+	}
+
+	w, h := dc.MeasureString(s)
+
+	return 0, -h, w, 0
+}
+
+// faceHeight returns the font height of a face. Faces created by FontNewFace, whichever way they were loaded, have their
+// size in points times 72/96, and other faces, like bitmap faces, the height of their lines. Font families have the
+// font height of their first face.
+func faceHeight(face font.Face) float64 {
+	if f, ok := face.(*fontFamily); ok {
+		return faceHeight(f.faces[0])
+	}
+	if _, points, ok := faceFont(face); ok {
+		return points * 72 / 96
+	}
+
+	return unfix(face.Metrics().Height)
+}
+
+// bounds returns the ink bounds of a glyph relative to its dot, and false for glyphs without ink or that aren't drawn.
+func (g shapedGlyph) bounds() (fixed.Rectangle26_6, bool) {
+	f, ok := g.face.(*fontFace)
+	if !ok {
+		return maskBounds(g.face, g.r)
+	}
+	if g.gid == 0 {
+		return fixed.Rectangle26_6{}, false
+	}
+
+	if colors := colorGlyphsOf(f); colors != nil {
+		if layers := colors.layers(uint16(g.gid)); layers != nil {
+			var b fixed.Rectangle26_6
+			for _, l := range layers {
+				if lb, _, err := f.font.GlyphBounds(&f.buf, sfnt.GlyphIndex(l.gid), f.scale, f.hinting); err == nil {
+					b = b.Union(lb)
+				}
+			}
+			return b, !b.Empty()
+		}
+		ppem := unfix(f.scale)
+		if bm := colors.bitmap(uint16(g.gid), ppem); bm != nil {
+			k := ppem / bm.ppem
+			size := bm.im.Bounds().Size()
+			return fixed.Rectangle26_6{
+				Min: fixp(bm.x*k, bm.y*k),
+				Max: fixp((bm.x+float64(size.X))*k, (bm.y+float64(size.Y))*k),
+			}, true
+		}
+	}
+
+	b, _, err := f.font.GlyphBounds(&f.buf, g.gid, f.scale, f.hinting)
+
+	return b, err == nil && !b.Empty()
+}
+
+// maskBounds returns the bounds of the opaque pixels of the mask of a glyph relative to its dot, for faces that only
+// tell the bounds of the cells of their glyphs, like bitmap faces.
+func maskBounds(face font.Face, r rune) (fixed.Rectangle26_6, bool) {
+	dr, mask, maskp, _, ok := face.Glyph(fixed.Point26_6{}, r)
+	if !ok || mask == nil {
+		return fixed.Rectangle26_6{}, false
+	}
+
+	ink := image.Rectangle{}
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		for x := dr.Min.X; x < dr.Max.X; x++ {
+			if _, _, _, a := mask.At(maskp.X+x-dr.Min.X, maskp.Y+y-dr.Min.Y).RGBA(); a > 0 {
+				ink = ink.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if ink.Empty() {
+		return fixed.Rectangle26_6{}, false
+	}
+
+	return fixed.R(ink.Min.X, ink.Min.Y, ink.Max.X, ink.Max.Y), true
+}
@@ -0,0 +1,1003 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"bytes"
+
+	"golang.org/x/image/font/opentype"
+)
+
+// otData is a part of an OpenType table. Reads past its end return zero, so damaged tables are read as empty ones.
+type otData []byte
+
+func (d otData) u8(i int) uint8 {
+	if i < 0 || i >= len(d) {
+		return 0
+	}
+
+	return d[i]
+}
+
+func (d otData) u16(i int) uint16 {
+	if i < 0 || i+2 > len(d) {
+		return 0
+	}
+
+	return uint16(d[i])<<8 | uint16(d[i+1])
+}
+
+func (d otData) i16(i int) int16 {
+	return int16(d.u16(i))
+}
+
+func (d otData) u32(i int) uint32 {
+	return uint32(d.u16(i))<<16 | uint32(d.u16(i+2))
+}
+
+// offset returns the data at the 16-bit offset stored at i, relative to the start of d, or nil for a null offset.
+func (d otData) offset(i int) otData {
+	o := int(d.u16(i))
+	if o == 0 || o >= len(d) {
+		return nil
+	}
+
+	return d[o:]
+}
+
+// offset32 returns the data at the 32-bit offset stored at i, relative to the start of d, or nil for a null offset.
+func (d otData) offset32(i int) otData {
+	o := int(d.u32(i))
+	if o == 0 || o >= len(d) {
+		return nil
+	}
+
+	return d[o:]
+}
+
+// coverage returns the coverage index of a glyph in a Coverage table, or -1 if the glyph isn't covered.
+func (d otData) coverage(g uint16) int {
+	switch d.u16(0) {
+	case 1:
+		lo, hi := 0, int(d.u16(2))
+		for lo < hi {
+			m := (lo + hi) / 2
+			switch v := d.u16(4 + 2*m); {
+			case v < g:
+				lo = m + 1
+			case v > g:
+				hi = m
+			default:
+				return m
+			}
+		}
+	case 2:
+		lo, hi := 0, int(d.u16(2))
+		for lo < hi {
+			m := (lo + hi) / 2
+			at := 4 + 6*m
+			switch start, end := d.u16(at), d.u16(at+2); {
+			case g < start:
+				hi = m
+			case g > end:
+				lo = m + 1
+			default:
+				return int(d.u16(at+4)) + int(g-start)
+			}
+		}
+	}
+
+	return -1
+}
+
+// class returns the class of a glyph in a ClassDef table, 0 for glyphs that aren't listed.
+func (d otData) class(g uint16) int {
+	switch d.u16(0) {
+	case 1:
+		start, n := d.u16(2), int(d.u16(4))
+		if g >= start && int(g-start) < n {
+			return int(d.u16(6 + 2*int(g-start)))
+		}
+	case 2:
+		lo, hi := 0, int(d.u16(2))
+		for lo < hi {
+			m := (lo + hi) / 2
+			at := 4 + 6*m
+			switch start, end := d.u16(at), d.u16(at+2); {
+			case g < start:
+				hi = m
+			case g > end:
+				lo = m + 1
+			default:
+				return int(d.u16(at + 4))
+			}
+		}
+	}
+
+	return 0
+}
+
+// otTag is an OpenType tag, four ASCII characters read as a big endian number.
+type otTag uint32
+
+// makeTag returns the tag of a four-character string.
+func makeTag(s string) otTag {
+	return otTag(uint32(s[0])<<24 | uint32(s[1])<<16 | uint32(s[2])<<8 | uint32(s[3]))
+}
+
+// The glyph classes of the GDEF table.
+const (
+	otClassBase      = 1
+	otClassLigature  = 2
+	otClassMark      = 3
+	otClassComponent = 4
+)
+
+// The lookup flags of the GSUB and GPOS tables.
+const (
+	otIgnoreBaseGlyphs    = 0x0002
+	otIgnoreLigatures     = 0x0004
+	otIgnoreMarks         = 0x0008
+	otUseMarkFilteringSet = 0x0010
+)
+
+// otLayout holds the OpenType layout tables of a font: the glyph definitions, substitutions and positionings.
+type otLayout struct {
+	glyphClasses otData // the glyph class definitions of GDEF, nil if the font has none
+	markClasses  otData // the mark attachment classes of GDEF
+	markSets     otData // the mark glyph sets of GDEF
+	gsub, gpos   *otTable
+}
+
+// otTable is a GSUB or a GPOS table.
+type otTable struct {
+	scripts  otData
+	features otData
+	lookups  []otLookup
+	gpos     bool
+}
+
+// otLookup is a lookup of a GSUB or a GPOS table, with its extension subtables resolved.
+type otLookup struct {
+	kind      uint16
+	flag      uint16
+	markSet   uint16
+	subtables []otData
+}
+
+// fontTables holds the tables of a font that sfnt doesn't read.
+type fontTables struct {
+	layout   *otLayout
+	colors   *colorGlyphs
+	vertical *verticalMetrics
+}
+
+// usedTables are the tags of the tables that fontTables are read from.
+var usedTables = map[string]bool{
+	"GDEF": true, "GSUB": true, "GPOS": true,
+	"COLR": true, "CPAL": true, "CBLC": true, "CBDT": true, "sbix": true,
+	"vhea": true, "vmtx": true, "VORG": true,
+}
+
+// fontTables returns the tables of the font of the face, reading them the first time. They belong to the face, so
+// they are released with it. The tables are nil if the source of the font isn't available, which is the case for the
+// fonts of collections.
+func (f *fontFace) fontTables() *fontTables {
+	f.tablesOnce.Do(func() {
+		f.tables = readTables(f.font)
+	})
+
+	return f.tables
+}
+
+// readTables reads the tables of a parsed font. The tables that are used are copied out of the source of the font,
+// so that the rest of it isn't kept.
+func readTables(f *opentype.Font) *fontTables {
+	t := &fontTables{}
+	var raw bytes.Buffer
+	if _, err := f.WriteSourceTo(nil, &raw); err != nil {
+		return t
+	}
+
+	tables := tableDirectory(raw.Bytes())
+	for tag, data := range tables {
+		if usedTables[tag] {
+			tables[tag] = bytes.Clone(data)
+		} else {
+			delete(tables, tag)
+		}
+	}
+	t.layout = parseLayout(tables)
+	t.colors = parseColorGlyphs(tables, f.NumGlyphs())
+	t.vertical = parseVerticalMetrics(tables)
+
+	return t
+}
+
+// layout returns the layout tables of the font of the face, or nil if they can't be read.
+func (f *fontFace) layout() *otLayout {
+	return f.fontTables().layout
+}
+
+// tableDirectory returns the tables of the source of a font by tag.
+func tableDirectory(raw []byte) map[string]otData {
+	d := otData(raw)
+	tables := make(map[string]otData)
+	for i := 0; i < int(d.u16(4)); i++ {
+		rec := 12 + 16*i
+		if rec+16 > len(d) {
+			break
+		}
+		offset, length := int(d.u32(rec+8)), int(d.u32(rec+12))
+		if offset >= 0 && length >= 0 && offset+length <= len(d) {
+			tables[string(d[rec:rec+4])] = d[offset : offset+length]
+		}
+	}
+
+	return tables
+}
+
+// parseLayout reads the GDEF, GSUB and GPOS tables of a font.
+func parseLayout(tables map[string]otData) *otLayout {
+	l := &otLayout{}
+	if gdef := tables["GDEF"]; gdef != nil {
+		l.glyphClasses = gdef.offset(4)
+		l.markClasses = gdef.offset(10)
+		if gdef.u16(2) >= 2 {
+			l.markSets = gdef.offset(12)
+		}
+	}
+	if gsub := tables["GSUB"]; gsub != nil {
+		l.gsub = parseLayoutTable(gsub, 7)
+	}
+	if gpos := tables["GPOS"]; gpos != nil {
+		l.gpos = parseLayoutTable(gpos, 9)
+		l.gpos.gpos = true
+	}
+
+	return l
+}
+
+// parseLayoutTable reads the script, feature and lookup lists of a GSUB or GPOS table, whose extension lookups have
+// the given type.
+func parseLayoutTable(d otData, extension uint16) *otTable {
+	t := &otTable{scripts: d.offset(4), features: d.offset(6)}
+
+	list := d.offset(8)
+	for i := 0; i < int(list.u16(0)); i++ {
+		ld := list.offset(2 + 2*i)
+		lookup := otLookup{kind: ld.u16(0), flag: ld.u16(2)}
+		n := int(ld.u16(4))
+		if lookup.flag&otUseMarkFilteringSet != 0 {
+			lookup.markSet = ld.u16(6 + 2*n)
+		}
+		for j := 0; j < n; j++ {
+			sub := ld.offset(6 + 2*j)
+			if ld.u16(0) == extension {
+				// all the subtables of an extension lookup have the same type
+				lookup.kind = sub.u16(2)
+				sub = sub.offset32(4)
+			}
+			lookup.subtables = append(lookup.subtables, sub)
+		}
+		t.lookups = append(t.lookups, lookup)
+	}
+
+	return t
+}
+
+// langSys returns the default language system of the first of the scripts that the table has.
+func (t *otTable) langSys(scripts []otTag) otData {
+	for _, want := range scripts {
+		for i := 0; i < int(t.scripts.u16(0)); i++ {
+			rec := 2 + 6*i
+			if otTag(t.scripts.u32(rec)) != want {
+				continue
+			}
+			script := t.scripts.offset(rec + 4)
+			if ls := script.offset(0); ls != nil {
+				return ls
+			}
+			if script.u16(2) > 0 {
+				return script.offset(8)
+			}
+		}
+	}
+
+	return nil
+}
+
+// hasFeature tells whether the table has a feature, for any script.
+func (t *otTable) hasFeature(tag otTag) bool {
+	for i := 0; i < int(t.features.u16(0)); i++ {
+		if otTag(t.features.u32(2+6*i)) == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// otStageLookup is a lookup enabled by the features of a stage, with the mask of the glyphs it applies to.
+type otStageLookup struct {
+	index int
+	mask  uint32
+}
+
+// stageLookups returns the lookups of the features of a language system that are in a stage, in the order in which
+// they are applied, with the masks of their features.
+func (t *otTable) stageLookups(langSys otData, stage map[otTag]uint32) []otStageLookup {
+	if langSys == nil {
+		return nil
+	}
+
+	masks := make(map[int]uint32)
+	addFeature := func(index int) {
+		if index >= int(t.features.u16(0)) {
+			return
+		}
+		rec := 2 + 6*index
+		mask, ok := stage[otTag(t.features.u32(rec))]
+		if !ok {
+			return
+		}
+		feature := t.features.offset(rec + 4)
+		for k := 0; k < int(feature.u16(2)); k++ {
+			if lookup := int(feature.u16(4 + 2*k)); lookup < len(t.lookups) {
+				masks[lookup] |= mask
+			}
+		}
+	}
+
+	if required := langSys.u16(2); required != 0xffff {
+		addFeature(int(required))
+	}
+	for i := 0; i < int(langSys.u16(4)); i++ {
+		addFeature(int(langSys.u16(6 + 2*i)))
+	}
+
+	var lookups []otStageLookup
+	for i := range t.lookups {
+		if mask, ok := masks[i]; ok {
+			lookups = append(lookups, otStageLookup{i, mask})
+		}
+	}
+
+	return lookups
+}
+
+// otGlyph is a glyph of the buffer of a shaper.
+type otGlyph struct {
+	gid        uint16
+	r          rune // the rune the glyph was mapped from, or -1 once it is substituted
+	start, end int  // the bytes of the source string the glyph comes from
+	mask       uint32
+	class      int
+	syllable   int  // the syllable of the glyph, for the scripts that have them
+	reph       bool // whether the glyph is the reph form of an Indic ra
+
+	xAdvance, xOffset, yOffset int32 // the positioning adjustments, in font units
+	attach                     int   // the index of the glyph a mark is attached to, or -1
+}
+
+// otShaper applies the lookups of a layout to a buffer of glyphs.
+type otShaper struct {
+	layout *otLayout
+	table  *otTable
+	glyphs []otGlyph
+	lookup *otLookup // the lookup being applied
+	depth  int       // the nesting depth of contextual lookups
+}
+
+// glyphClass returns the GDEF class of a glyph, or def if the font doesn't classify its glyphs.
+func (l *otLayout) glyphClass(gid uint16, def int) int {
+	if l.glyphClasses == nil {
+		return def
+	}
+
+	return l.glyphClasses.class(gid)
+}
+
+// ignored tells whether the current lookup skips a glyph.
+func (s *otShaper) ignored(i int) bool {
+	g := &s.glyphs[i]
+	flag := s.lookup.flag
+	switch g.class {
+	case otClassBase:
+		return flag&otIgnoreBaseGlyphs != 0
+	case otClassLigature:
+		return flag&otIgnoreLigatures != 0
+	case otClassMark:
+		if flag&otIgnoreMarks != 0 {
+			return true
+		}
+		if flag&otUseMarkFilteringSet != 0 {
+			return s.layout.markSets.offset32(4+4*int(s.lookup.markSet)).coverage(g.gid) < 0
+		}
+		if t := int(flag >> 8); t != 0 {
+			return s.layout.markClasses.class(g.gid) != t
+		}
+	}
+
+	return false
+}
+
+// next returns the index of the next glyph after i that the current lookup doesn't skip, or -1.
+func (s *otShaper) next(i int) int {
+	for i++; i < len(s.glyphs); i++ {
+		if !s.ignored(i) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// prev returns the index of the previous glyph before i that the current lookup doesn't skip, or -1.
+func (s *otShaper) prev(i int) int {
+	for i--; i >= 0; i-- {
+		if !s.ignored(i) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// applyLookups applies lookups to the glyphs with their masks, in order.
+func (s *otShaper) applyLookups(lookups []otStageLookup) {
+	for _, sl := range lookups {
+		s.lookup = &s.table.lookups[sl.index]
+
+		if !s.table.gpos && s.lookup.kind == 8 {
+			// reverse chaining substitutions go from the end of the text
+			for i := len(s.glyphs) - 1; i >= 0; i-- {
+				if s.glyphs[i].mask&sl.mask != 0 && !s.ignored(i) {
+					s.apply(i)
+				}
+			}
+			continue
+		}
+
+		for i := 0; i < len(s.glyphs); {
+			if s.glyphs[i].mask&sl.mask != 0 && !s.ignored(i) {
+				if next, ok := s.apply(i); ok {
+					i = max(next, i+1)
+					continue
+				}
+			}
+			i++
+		}
+	}
+}
+
+// apply applies the first subtable of the current lookup that matches at glyph i, and returns the index of the glyph
+// to continue from.
+func (s *otShaper) apply(i int) (int, bool) {
+	for _, sub := range s.lookup.subtables {
+		var next int
+		var ok bool
+		if s.table.gpos {
+			next, ok = s.applyPositioning(sub, i)
+		} else {
+			next, ok = s.applySubstitution(sub, i)
+		}
+		if ok {
+			return next, true
+		}
+	}
+
+	return 0, false
+}
+
+// applyNested applies a lookup at glyph i from a contextual lookup, whatever the masks of the glyphs.
+func (s *otShaper) applyNested(index, i int) {
+	if index >= len(s.table.lookups) || i >= len(s.glyphs) || s.depth >= 8 {
+		return
+	}
+
+	lookup := s.lookup
+	s.lookup = &s.table.lookups[index]
+	s.depth++
+	if !s.ignored(i) {
+		s.apply(i)
+	}
+	s.depth--
+	s.lookup = lookup
+}
+
+// substitute replaces the glyph at i.
+func (s *otShaper) substitute(i int, gid uint16) {
+	g := &s.glyphs[i]
+	g.gid = gid
+	g.r = -1
+	g.class = s.layout.glyphClass(gid, g.class)
+}
+
+// applySubstitution applies a GSUB subtable at glyph i.
+func (s *otShaper) applySubstitution(sub otData, i int) (int, bool) {
+	g := s.glyphs[i]
+	switch s.lookup.kind {
+	case 1: // single
+		c := sub.offset(2).coverage(g.gid)
+		if c < 0 {
+			return 0, false
+		}
+		gid := g.gid + sub.u16(4)
+		if sub.u16(0) == 2 {
+			if c >= int(sub.u16(4)) {
+				return 0, false
+			}
+			gid = sub.u16(6 + 2*c)
+		}
+		s.substitute(i, gid)
+		return i + 1, true
+
+	case 2: // multiple
+		c := sub.offset(2).coverage(g.gid)
+		if c < 0 || c >= int(sub.u16(4)) {
+			return 0, false
+		}
+		seq := sub.offset(6 + 2*c)
+		n := int(seq.u16(0))
+		glyphs := make([]otGlyph, n)
+		for k := range glyphs {
+			glyphs[k] = g
+			glyphs[k].gid = seq.u16(2 + 2*k)
+			glyphs[k].r = -1
+			glyphs[k].class = s.layout.glyphClass(glyphs[k].gid, g.class)
+			if k > 0 {
+				glyphs[k].start = g.end
+			}
+		}
+		s.glyphs = append(s.glyphs[:i], append(glyphs, s.glyphs[i+1:]...)...)
+		return i + n, true
+
+	case 3: // alternate, the first alternate is used
+		c := sub.offset(2).coverage(g.gid)
+		if c < 0 || c >= int(sub.u16(4)) {
+			return 0, false
+		}
+		set := sub.offset(6 + 2*c)
+		if set.u16(0) == 0 {
+			return 0, false
+		}
+		s.substitute(i, set.u16(2))
+		return i + 1, true
+
+	case 4: // ligature
+		c := sub.offset(2).coverage(g.gid)
+		if c < 0 || c >= int(sub.u16(4)) {
+			return 0, false
+		}
+		set := sub.offset(6 + 2*c)
+	ligatures:
+		for k := 0; k < int(set.u16(0)); k++ {
+			lig := set.offset(2 + 2*k)
+			n := int(lig.u16(2))
+			matched := []int{i}
+			for m, j := 1, i; m < n; m++ {
+				if j = s.next(j); j < 0 || s.glyphs[j].gid != lig.u16(4+2*(m-1)) {
+					continue ligatures
+				}
+				matched = append(matched, j)
+			}
+			s.substitute(i, lig.u16(0))
+			if s.layout.glyphClasses == nil {
+				s.glyphs[i].class = otClassLigature
+			}
+			for m := len(matched) - 1; m > 0; m-- {
+				j := matched[m]
+				s.glyphs[i].start = min(s.glyphs[i].start, s.glyphs[j].start)
+				s.glyphs[i].end = max(s.glyphs[i].end, s.glyphs[j].end)
+				s.glyphs = append(s.glyphs[:j], s.glyphs[j+1:]...)
+			}
+			return i + 1, true
+		}
+		return 0, false
+
+	case 5, 6: // contextual and chained contextual
+		return s.applyContext(sub, s.lookup.kind == 6, i)
+
+	case 8: // reverse chained contextual single
+		c := sub.offset(2).coverage(g.gid)
+		if c < 0 {
+			return 0, false
+		}
+		nb := int(sub.u16(4))
+		backtrack := otSequence{data: sub, at: 6, kind: otMatchCoverage}
+		at := 6 + 2*nb
+		nl := int(sub.u16(at))
+		lookahead := otSequence{data: sub, at: at + 2, kind: otMatchCoverage}
+		at += 2 + 2*nl
+		if c >= int(sub.u16(at)) || !s.matchAround(i, i, backtrack, nb, lookahead, nl) {
+			return 0, false
+		}
+		s.substitute(i, sub.u16(at+2+2*c))
+		return i, true
+	}
+
+	return 0, false
+}
+
+// The kinds of the values of an otSequence.
+const (
+	otMatchGlyph = iota
+	otMatchClass
+	otMatchCoverage
+)
+
+// otSequence is a sequence of glyph ids, glyph classes or coverage offsets in a contextual lookup.
+type otSequence struct {
+	data     otData
+	at       int
+	kind     int
+	classDef otData
+}
+
+// matches tells whether the k-th value of the sequence matches a glyph.
+func (q otSequence) matches(k int, g uint16) bool {
+	switch q.kind {
+	case otMatchGlyph:
+		return q.data.u16(q.at+2*k) == g
+	case otMatchClass:
+		return q.classDef.class(g) == int(q.data.u16(q.at+2*k))
+	}
+
+	return q.data.offset(q.at+2*k).coverage(g) >= 0
+}
+
+// matchInput matches the glyphs after i to the values 1 to n-1 of a sequence, and returns their indexes with i.
+func (s *otShaper) matchInput(i int, input otSequence, n int) ([]int, bool) {
+	positions := []int{i}
+	for k, j := 1, i; k < n; k++ {
+		if j = s.next(j); j < 0 || !input.matches(k, s.glyphs[j].gid) {
+			return nil, false
+		}
+		positions = append(positions, j)
+	}
+
+	return positions, true
+}
+
+// matchAround matches the glyphs before first to a backtrack sequence and the glyphs after last to a lookahead one.
+func (s *otShaper) matchAround(first, last int, backtrack otSequence, nb int, lookahead otSequence, nl int) bool {
+	for k, j := 0, first; k < nb; k++ {
+		if j = s.prev(j); j < 0 || !backtrack.matches(k, s.glyphs[j].gid) {
+			return false
+		}
+	}
+	for k, j := 0, last; k < nl; k++ {
+		if j = s.next(j); j < 0 || !lookahead.matches(k, s.glyphs[j].gid) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// applyContext applies a contextual or chained contextual subtable at glyph i, in any of its three formats.
+func (s *otShaper) applyContext(sub otData, chained bool, i int) (int, bool) {
+	gid := s.glyphs[i].gid
+
+	// rule applies a rule of format 1 or 2, whose values are matched as the given kind
+	rule := func(rule otData, kind int, backtrackDef, inputDef, lookaheadDef otData) (int, bool) {
+		nb, at := 0, 0
+		backtrack := otSequence{data: rule, kind: kind, classDef: backtrackDef}
+		if chained {
+			nb = int(rule.u16(0))
+			backtrack.at = 2
+			at = 2 + 2*nb
+		}
+		n := int(rule.u16(at))
+		var records, count int
+		if chained {
+			records = at + 2 + 2*(n-1)
+		} else {
+			count = int(rule.u16(at + 2))
+			records = at + 4 + 2*(n-1)
+		}
+		// the input values start with the second glyph
+		input := otSequence{data: rule, at: records - 2*n, kind: kind, classDef: inputDef}
+		positions, ok := s.matchInput(i, input, n)
+		if !ok {
+			return 0, false
+		}
+		nl := 0
+		lookahead := otSequence{data: rule, kind: kind, classDef: lookaheadDef}
+		if chained {
+			nl = int(rule.u16(records))
+			lookahead.at = records + 2
+			records += 2 + 2*nl
+			count = int(rule.u16(records))
+			records += 2
+		}
+		if !s.matchAround(i, positions[len(positions)-1], backtrack, nb, lookahead, nl) {
+			return 0, false
+		}
+		return s.applyRecords(positions, rule, records, count), true
+	}
+
+	switch sub.u16(0) {
+	case 1:
+		c := sub.offset(2).coverage(gid)
+		if c < 0 || c >= int(sub.u16(4)) {
+			return 0, false
+		}
+		set := sub.offset(6 + 2*c)
+		for k := 0; k < int(set.u16(0)); k++ {
+			if next, ok := rule(set.offset(2+2*k), otMatchGlyph, nil, nil, nil); ok {
+				return next, true
+			}
+		}
+
+	case 2:
+		if sub.offset(2).coverage(gid) < 0 {
+			return 0, false
+		}
+		var backtrackDef, inputDef, lookaheadDef otData
+		sets := 8
+		if chained {
+			backtrackDef, inputDef, lookaheadDef = sub.offset(4), sub.offset(6), sub.offset(8)
+			sets = 12
+		} else {
+			inputDef = sub.offset(4)
+		}
+		c := inputDef.class(gid)
+		if c >= int(sub.u16(sets-2)) {
+			return 0, false
+		}
+		set := sub.offset(sets + 2*c)
+		for k := 0; k < int(set.u16(0)); k++ {
+			if next, ok := rule(set.offset(2+2*k), otMatchClass, backtrackDef, inputDef, lookaheadDef); ok {
+				return next, true
+			}
+		}
+
+	case 3:
+		if !chained {
+			n, count := int(sub.u16(2)), int(sub.u16(4))
+			input := otSequence{data: sub, at: 6, kind: otMatchCoverage}
+			if n == 0 || !input.matches(0, gid) {
+				return 0, false
+			}
+			positions, ok := s.matchInput(i, input, n)
+			if !ok {
+				return 0, false
+			}
+			return s.applyRecords(positions, sub, 6+2*n, count), true
+		}
+
+		nb := int(sub.u16(2))
+		backtrack := otSequence{data: sub, at: 4, kind: otMatchCoverage}
+		at := 4 + 2*nb
+		n := int(sub.u16(at))
+		input := otSequence{data: sub, at: at + 2, kind: otMatchCoverage}
+		at += 2 + 2*n
+		nl := int(sub.u16(at))
+		lookahead := otSequence{data: sub, at: at + 2, kind: otMatchCoverage}
+		at += 2 + 2*nl
+		if n == 0 || !input.matches(0, gid) {
+			return 0, false
+		}
+		positions, ok := s.matchInput(i, input, n)
+		if !ok || !s.matchAround(i, positions[len(positions)-1], backtrack, nb, lookahead, nl) {
+			return 0, false
+		}
+		return s.applyRecords(positions, sub, at+2, int(sub.u16(at))), true
+	}
+
+	return 0, false
+}
+
+// applyRecords applies the nested lookups of the sequence lookup records of a matched context, and returns the index of
+// the glyph after the context.
+func (s *otShaper) applyRecords(positions []int, d otData, at, count int) int {
+	end := positions[len(positions)-1] + 1
+	for r := 0; r < count; r++ {
+		seqIndex, lookup := int(d.u16(at+4*r)), int(d.u16(at+4*r+2))
+		if seqIndex >= len(positions) {
+			continue
+		}
+		n := len(s.glyphs)
+		s.applyNested(lookup, positions[seqIndex])
+		if delta := len(s.glyphs) - n; delta != 0 {
+			for k := seqIndex + 1; k < len(positions); k++ {
+				positions[k] += delta
+			}
+			end += delta
+		}
+	}
+
+	return min(max(end, positions[0]+1), len(s.glyphs))
+}
+
+// otValue reads a value record of the given format at i, and returns its adjustments and its size.
+func otValue(d otData, i int, format uint16) (xPlacement, yPlacement, xAdvance int32, size int) {
+	for bit := uint16(1); bit <= 0x80; bit <<= 1 {
+		if format&bit == 0 {
+			continue
+		}
+		v := int32(d.i16(i + size))
+		switch bit {
+		case 1:
+			xPlacement = v
+		case 2:
+			yPlacement = v
+		case 4:
+			xAdvance = v
+		}
+		size += 2
+	}
+
+	return
+}
+
+// adjust applies the value record of the given format at i to the glyph at index g.
+func (s *otShaper) adjust(d otData, i int, format uint16, g int) {
+	xp, yp, xa, _ := otValue(d, i, format)
+	s.glyphs[g].xOffset += xp
+	s.glyphs[g].yOffset += yp
+	s.glyphs[g].xAdvance += xa
+}
+
+// anchor returns the coordinates of an Anchor table.
+func anchor(d otData) (x, y int32, ok bool) {
+	if d == nil {
+		return 0, 0, false
+	}
+
+	return int32(d.i16(2)), int32(d.i16(4)), true
+}
+
+// applyPositioning applies a GPOS subtable at glyph i.
+func (s *otShaper) applyPositioning(sub otData, i int) (int, bool) {
+	g := s.glyphs[i]
+	switch s.lookup.kind {
+	case 1: // single adjustment
+		c := sub.offset(2).coverage(g.gid)
+		if c < 0 {
+			return 0, false
+		}
+		format := sub.u16(4)
+		if sub.u16(0) == 1 {
+			s.adjust(sub, 6, format, i)
+		} else {
+			if c >= int(sub.u16(6)) {
+				return 0, false
+			}
+			_, _, _, size := otValue(sub, 8, format)
+			s.adjust(sub, 8+c*size, format, i)
+		}
+		return i + 1, true
+
+	case 2: // pair adjustment
+		c := sub.offset(2).coverage(g.gid)
+		j := s.next(i)
+		if c < 0 || j < 0 {
+			return 0, false
+		}
+		format1, format2 := sub.u16(4), sub.u16(6)
+		_, _, _, size1 := otValue(sub, 0, format1)
+		_, _, _, size2 := otValue(sub, 0, format2)
+		second := s.glyphs[j].gid
+
+		var d otData
+		at := -1
+		switch sub.u16(0) {
+		case 1:
+			if c >= int(sub.u16(8)) {
+				return 0, false
+			}
+			d = sub.offset(10 + 2*c)
+			lo, hi := 0, int(d.u16(0))
+			for lo < hi {
+				m := (lo + hi) / 2
+				rec := 2 + m*(2+size1+size2)
+				switch v := d.u16(rec); {
+				case v < second:
+					lo = m + 1
+				case v > second:
+					hi = m
+				default:
+					at = rec + 2
+					lo = hi
+				}
+			}
+		case 2:
+			c1, c2 := sub.offset(8).class(g.gid), sub.offset(10).class(second)
+			n1, n2 := int(sub.u16(12)), int(sub.u16(14))
+			if c1 < n1 && c2 < n2 {
+				d = sub
+				at = 16 + (c1*n2+c2)*(size1+size2)
+			}
+		}
+		if at < 0 {
+			return 0, false
+		}
+		s.adjust(d, at, format1, i)
+		s.adjust(d, at+size1, format2, j)
+		if format2 != 0 {
+			return j + 1, true
+		}
+		return j, true
+
+	case 4, 5: // mark to base and mark to ligature
+		mc := sub.offset(2).coverage(g.gid)
+		if mc < 0 {
+			return 0, false
+		}
+		j := i - 1
+		for j >= 0 && s.glyphs[j].class == otClassMark {
+			j--
+		}
+		if j < 0 {
+			return 0, false
+		}
+		bc := sub.offset(4).coverage(s.glyphs[j].gid)
+		if bc < 0 {
+			return 0, false
+		}
+		classes := int(sub.u16(6))
+		marks, bases := sub.offset(8), sub.offset(10)
+		class := int(marks.u16(2 + 4*mc))
+		if bc >= int(bases.u16(0)) || class >= classes {
+			return 0, false
+		}
+		var ax, ay int32
+		var ok bool
+		if s.lookup.kind == 4 {
+			ax, ay, ok = anchor(bases.offset(2 + 2*(bc*classes+class)))
+		} else {
+			// marks attach to the last component of ligatures
+			attach := bases.offset(2 + 2*bc)
+			if n := int(attach.u16(0)); n > 0 {
+				ax, ay, ok = anchor(attach.offset(2 + 2*((n-1)*classes+class)))
+			}
+		}
+		if !ok {
+			return 0, false
+		}
+		s.attach(i, j, ax, ay, marks.offset(2+4*mc+2))
+		return i + 1, true
+
+	case 6: // mark to mark
+		mc := sub.offset(2).coverage(g.gid)
+		j := s.prev(i)
+		if mc < 0 || j < 0 || s.glyphs[j].class != otClassMark {
+			return 0, false
+		}
+		bc := sub.offset(4).coverage(s.glyphs[j].gid)
+		if bc < 0 {
+			return 0, false
+		}
+		classes := int(sub.u16(6))
+		marks, bases := sub.offset(8), sub.offset(10)
+		class := int(marks.u16(2 + 4*mc))
+		if bc >= int(bases.u16(0)) || class >= classes {
+			return 0, false
+		}
+		ax, ay, ok := anchor(bases.offset(2 + 2*(bc*classes+class)))
+		if !ok {
+			return 0, false
+		}
+		s.attach(i, j, ax, ay, marks.offset(2+4*mc+2))
+		return i + 1, true
+
+	case 7, 8: // contextual and chained contextual
+		return s.applyContext(sub, s.lookup.kind == 8, i)
+	}
+
+	return 0, false
+}
+
+// attach attaches the mark at i to the glyph at j, so that the anchor of the mark meets the anchor (x, y) of the glyph.
+func (s *otShaper) attach(i, j int, x, y int32, markAnchor otData) {
+	mx, my, _ := anchor(markAnchor)
+	g := &s.glyphs[i]
+	g.attach = j
+	g.xOffset = x - mx
+	g.yOffset = y - my
+}
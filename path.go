@@ -11,62 +11,330 @@ import (
 	"golang.org/x/image/math/fixed"
 )
 
+// PathOp identifies the kind of a path segment.
+type PathOp int
+
+const (
+	PathMoveTo  PathOp = iota // Starts a new subpath at Points[0].
+	PathLineTo                // Straight line to Points[0].
+	PathQuadTo                // Quadratic Bézier curve through Points[0] to Points[1].
+	PathCubicTo               // Cubic Bézier curve through Points[0] and Points[1] to Points[2].
+	PathClose                 // Closes the current subpath with a straight line to its start.
+)
+
+// PathSegment is a single segment of a Path. Only as many Points as the Op needs are used.
+type PathSegment struct {
+	Op     PathOp
+	Points [3]Point
+}
+
+// Path is a sequence of subpaths made of straight lines and Bézier curves, kept in full floating-point precision.
+//
+// A Path is built independently of any Context, so a shape can be constructed once and then appended to a
+// context with AppendPath any number of times, under different transformations. Its segments can be
+// iterated over with a plain range loop.
+type Path []PathSegment
+
+// MoveTo starts a new subpath at the specified point (x, y).
+func (p *Path) MoveTo(x, y float64) {
+	*p = append(*p, PathSegment{Op: PathMoveTo, Points: [3]Point{{x, y}}})
+}
+
+// LineTo adds a straight line segment to the current subpath.
+//
+// If the path is empty, this method behaves as if MoveTo() was called with the same coordinates.
+func (p *Path) LineTo(x, y float64) {
+	if len(*p) == 0 {
+		p.MoveTo(x, y)
+		return
+	}
+
+	*p = append(*p, PathSegment{Op: PathLineTo, Points: [3]Point{{x, y}}})
+}
+
+// QuadraticTo adds a quadratic Bézier curve segment with the control point (x1, y1) ending at (x2, y2).
+//
+// If the path is empty, this method behaves as if MoveTo() was called with the control point first.
+func (p *Path) QuadraticTo(x1, y1, x2, y2 float64) {
+	if len(*p) == 0 {
+		p.MoveTo(x1, y1)
+	}
+
+	*p = append(*p, PathSegment{Op: PathQuadTo, Points: [3]Point{{x1, y1}, {x2, y2}}})
+}
+
+// CubicTo adds a cubic Bézier curve segment with the control points (x1, y1) and (x2, y2) ending at (x3, y3).
+//
+// If the path is empty, this method behaves as if MoveTo() was called with the first control point first.
+func (p *Path) CubicTo(x1, y1, x2, y2, x3, y3 float64) {
+	if len(*p) == 0 {
+		p.MoveTo(x1, y1)
+	}
+
+	*p = append(*p, PathSegment{Op: PathCubicTo, Points: [3]Point{{x1, y1}, {x2, y2}, {x3, y3}}})
+}
+
+// Close closes the current subpath by adding a straight line to its starting point. It has no effect on an empty path.
+func (p *Path) Close() {
+	if len(*p) > 0 {
+		*p = append(*p, PathSegment{Op: PathClose})
+	}
+}
+
+// pointCount returns the number of points used by a segment.
+func (s PathSegment) pointCount() int {
+	switch s.Op {
+	case PathClose:
+		return 0
+	case PathQuadTo:
+		return 2
+	case PathCubicTo:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// Transform returns a copy of the path with every point transformed by the matrix m.
+func (p Path) Transform(m Matrix) Path {
+	result := make(Path, len(p))
+
+	for i, s := range p {
+		result[i].Op = s.Op
+		for j := 0; j < s.pointCount(); j++ {
+			x, y := m.TransformPoint(s.Points[j].X, s.Points[j].Y)
+			result[i].Points[j] = Point{x, y}
+		}
+	}
+
+	return result
+}
+
+// reverse returns the path with every subpath traversed backwards, and the subpaths in reverse order.
+func (p Path) reverse() Path {
+	var result Path
+	for _, points := range p.flatten() {
+		sub := make(Path, 0, len(points))
+		for i := len(points) - 1; i >= 0; i-- {
+			op := PathLineTo
+			if i == len(points)-1 {
+				op = PathMoveTo
+			}
+			sub = append(sub, PathSegment{Op: op, Points: [3]Point{points[i]}})
+		}
+		result = append(sub, result...)
+	}
+
+	return result
+}
+
+// Length returns the length of the path, measured along its flattened curves. The gaps between subpaths don't count.
+func (p Path) Length() float64 {
+	length := 0.0
+	for _, points := range p.flatten() {
+		for i := 1; i < len(points); i++ {
+			length += points[i-1].Distance(points[i])
+		}
+	}
+
+	return length
+}
+
+// Bounds returns the tight bounding box of the path, as its top-left (x0, y0) and bottom-right (x1, y1) corners.
+//
+// Curves are measured by their extreme points rather than their control points. An empty path has an empty box at the origin.
+func (p Path) Bounds() (x0, y0, x1, y1 float64) {
+	if len(p) == 0 {
+		return 0, 0, 0, 0
+	}
+
+	x0, y0 = math.Inf(1), math.Inf(1)
+	x1, y1 = math.Inf(-1), math.Inf(-1)
+	add := func(q Point) {
+		x0, y0 = math.Min(x0, q.X), math.Min(y0, q.Y)
+		x1, y1 = math.Max(x1, q.X), math.Max(y1, q.Y)
+	}
+
+	var start, current Point
+	for _, s := range p {
+		switch s.Op {
+		case PathMoveTo:
+			start, current = s.Points[0], s.Points[0]
+			add(current)
+		case PathLineTo:
+			current = s.Points[0]
+			add(current)
+		case PathQuadTo:
+			for _, t := range quadraticExtrema(current, s.Points[0], s.Points[1]) {
+				x, y := quadratic(current.X, current.Y, s.Points[0].X, s.Points[0].Y, s.Points[1].X, s.Points[1].Y, t)
+				add(Point{x, y})
+			}
+			current = s.Points[1]
+			add(current)
+		case PathCubicTo:
+			for _, t := range cubicExtrema(current, s.Points[0], s.Points[1], s.Points[2]) {
+				x, y := cubic(current.X, current.Y, s.Points[0].X, s.Points[0].Y, s.Points[1].X, s.Points[1].Y, s.Points[2].X, s.Points[2].Y, t)
+				add(Point{x, y})
+			}
+			current = s.Points[2]
+			add(current)
+		case PathClose:
+			current = start
+		}
+	}
+
+	return x0, y0, x1, y1
+}
+
+// quadraticExtrema returns the parameters in (0, 1) at which a quadratic Bézier curve reaches a horizontal or vertical extreme.
+func quadraticExtrema(p0, p1, p2 Point) []float64 {
+	var result []float64
+
+	for _, v := range [][3]float64{{p0.X, p1.X, p2.X}, {p0.Y, p1.Y, p2.Y}} {
+		d := v[0] - 2*v[1] + v[2]
+		if d == 0 {
+			continue
+		}
+		if t := (v[0] - v[1]) / d; t > 0 && t < 1 {
+			result = append(result, t)
+		}
+	}
+
+	return result
+}
+
+// cubicExtrema returns the parameters in (0, 1) at which a cubic Bézier curve reaches a horizontal or vertical extreme.
+func cubicExtrema(p0, p1, p2, p3 Point) []float64 {
+	var result []float64
+
+	for _, v := range [][4]float64{{p0.X, p1.X, p2.X, p3.X}, {p0.Y, p1.Y, p2.Y, p3.Y}} {
+		// The derivative divided by 3 is a*t^2 + b*t + c.
+		a := -v[0] + 3*v[1] - 3*v[2] + v[3]
+		b := 2 * (v[0] - 2*v[1] + v[2])
+		c := v[1] - v[0]
+		for _, t := range solveQuadratic(a, b, c) {
+			if t > 0 && t < 1 {
+				result = append(result, t)
+			}
+		}
+	}
+
+	return result
+}
+
+// solveQuadratic returns the real roots of a*t^2 + b*t + c = 0, degrading to the linear case when a is zero.
+func solveQuadratic(a, b, c float64) []float64 {
+	if math.Abs(a) < 1e-12 {
+		if b == 0 {
+			return nil
+		}
+		return []float64{-c / b}
+	}
+
+	d := b*b - 4*a*c
+	if d < 0 {
+		return nil
+	}
+	d = math.Sqrt(d)
+
+	return []float64{(-b + d) / (2 * a), (-b - d) / (2 * a)}
+}
+
+// strokePolylines flattens the path like flatten, but starts every closed subpath in the middle of its first
+// segment and ends it there again, so that a stroker joins the segments meeting at the subpath's starting point.
+func (p Path) strokePolylines() [][]Point {
+	var result [][]Point
+
+	for i := 0; i < len(p); {
+		j := i + 1
+		for j < len(p) && p[j].Op != PathMoveTo {
+			j++
+		}
+		for _, points := range p[i:j].flatten() {
+			if p[j-1].Op == PathClose && len(points) > 2 {
+				mid := points[0].Interpolate(points[1], 0.5)
+				points = append(append([]Point{mid}, points[1:]...), mid)
+			}
+			result = append(result, points)
+		}
+		i = j
+	}
+
+	return result
+}
+
+// contains reports whether a point lies inside the path according to a fill rule, closing every subpath.
+func (p Path) contains(pt Point, fillRule FillRule) bool {
+	var winding int
+	for _, e := range booleanEdges(p, 0) {
+		winding += windingContribution(e, pt)
+	}
+
+	return fillRuleInside(winding, fillRule)
+}
+
+// flatten converts the path into polylines, one per subpath, approximating curves with line segments.
+func (p Path) flatten() [][]Point {
+	var result [][]Point
+	var points []Point
+	var start, current Point
+
+	for _, s := range p {
+		switch s.Op {
+		case PathMoveTo:
+			if len(points) > 0 {
+				result = append(result, points)
+			}
+			points = []Point{s.Points[0]}
+			start, current = s.Points[0], s.Points[0]
+		case PathLineTo:
+			points = append(points, s.Points[0])
+			current = s.Points[0]
+		case PathQuadTo:
+			points = append(points, QuadraticBezier(current.X, current.Y, s.Points[0].X, s.Points[0].Y, s.Points[1].X, s.Points[1].Y)[1:]...)
+			current = s.Points[1]
+		case PathCubicTo:
+			points = append(points, CubicBezier(current.X, current.Y, s.Points[0].X, s.Points[0].Y, s.Points[1].X, s.Points[1].Y, s.Points[2].X, s.Points[2].Y)[1:]...)
+			current = s.Points[2]
+		case PathClose:
+			points = append(points, start)
+			current = start
+		}
+	}
+
+	if len(points) > 0 {
+		result = append(result, points)
+	}
+
+	return result
+}
+
 // flattenPath converts a raster.Path into a slice of slices of Point, representing flattened path segments.
 //
-// This function processes a raster.Path, which is typically a series of fixed-point commands and coordinates, and flattens it into a list of connected Point segments.
+// This function processes a raster.Path, which is typically a series of fixed-point commands and coordinates, and flattens it into a list of connected Point segments. The path is flattened by Path.flatten, so that strokes and the paths of the package are flattened the same way.
 func flattenPath(p raster.Path) [][]Point {
-	var result [][]Point
-	var path []Point
-	var cx, cy float64
+	var path Path
 
 	for i := 0; i < len(p); {
 		switch p[i] {
 		case 0:
-			if len(path) > 0 {
-				result = append(result, path)
-				path = nil
-			}
-			x := unfix(p[i+1])
-			y := unfix(p[i+2])
-			path = append(path, Point{x, y})
-			cx, cy = x, y
+			path.MoveTo(unfix(p[i+1]), unfix(p[i+2]))
 			i += 4
 		case 1:
-			x := unfix(p[i+1])
-			y := unfix(p[i+2])
-			path = append(path, Point{x, y})
-			cx, cy = x, y
+			path.LineTo(unfix(p[i+1]), unfix(p[i+2]))
 			i += 4
 		case 2:
-			x1 := unfix(p[i+1])
-			y1 := unfix(p[i+2])
-			x2 := unfix(p[i+3])
-			y2 := unfix(p[i+4])
-			points := QuadraticBezier(cx, cy, x1, y1, x2, y2)
-			path = append(path, points...)
-			cx, cy = x2, y2
+			path.QuadraticTo(unfix(p[i+1]), unfix(p[i+2]), unfix(p[i+3]), unfix(p[i+4]))
 			i += 6
 		case 3:
-			x1 := unfix(p[i+1])
-			y1 := unfix(p[i+2])
-			x2 := unfix(p[i+3])
-			y2 := unfix(p[i+4])
-			x3 := unfix(p[i+5])
-			y3 := unfix(p[i+6])
-			points := CubicBezier(cx, cy, x1, y1, x2, y2, x3, y3)
-			path = append(path, points...)
-			cx, cy = x3, y3
+			path.CubicTo(unfix(p[i+1]), unfix(p[i+2]), unfix(p[i+3]), unfix(p[i+4]), unfix(p[i+5]), unfix(p[i+6]))
 			i += 8
 		default:
 			panic("bad path")
 		}
 	}
 
-	if len(path) > 0 {
-		result = append(result, path)
-	}
-
-	return result
+	return path.flatten()
 }
 
 // dashPath creates a dashed representation of a path.
@@ -7,6 +7,7 @@ package gg
 import (
 	"image"
 	"image/color"
+	"math"
 
 	"github.com/golang/freetype/raster"
 )
@@ -28,6 +29,123 @@ type Pattern interface {
 	ColorAt(x, y int) color.Color
 }
 
+// SpanPattern is an optional interface for patterns that can compute the colors of a whole horizontal span of
+// pixels at once, which saves a ColorAt call per pixel when the pattern is painted.
+type SpanPattern interface {
+	Pattern
+
+	// ColorSpan sets dst to the premultiplied colors of the pixels (x, y) to (x+len(dst)-1, y).
+	ColorSpan(dst []color.RGBA64, x, y int)
+}
+
+// SurfacePattern is an interface representing a pattern that paints an image.
+type SurfacePattern interface {
+	Pattern
+
+	// SetMatrix sets the transformation from the pattern's space to the space it is painted in.
+	//
+	// Patterns are painted in device space, so the pattern's pixel (x, y) is normally painted at the image's pixel (x, y).
+	// The matrix moves, scales, rotates or shears the pattern instead; see Context.SetUserSpacePatterns to also make it
+	// follow the context's transformation. A matrix that can't be inverted is ignored.
+	SetMatrix(m Matrix)
+}
+
+// patternMatrix holds the transformation of a pattern and is embedded by the package's own patterns.
+type patternMatrix struct {
+	matrix      Matrix
+	inverse     Matrix
+	transformed bool
+}
+
+// SetMatrix sets the transformation from the pattern's space to the space it is painted in.
+func (t *patternMatrix) SetMatrix(m Matrix) {
+	inverse, ok := m.Invert()
+	if !ok {
+		return
+	}
+
+	t.matrix, t.inverse = m, inverse
+	t.transformed = m != Identity()
+}
+
+// current returns the transformation of the pattern.
+func (t *patternMatrix) current() Matrix {
+	if !t.transformed {
+		return Identity()
+	}
+
+	return t.matrix
+}
+
+// then returns the transformation of the pattern followed by m.
+func (t patternMatrix) then(m Matrix) patternMatrix {
+	t.SetMatrix(t.current().Multiply(m))
+
+	return t
+}
+
+// point returns the center of the pixel (x, y) in the pattern's space.
+func (t *patternMatrix) point(x, y int) (float64, float64) {
+	px, py := float64(x)+0.5, float64(y)+0.5
+	if !t.transformed {
+		return px, py
+	}
+
+	return t.inverse.TransformPoint(px, py)
+}
+
+// transformedPattern is implemented by the package's own patterns, which can be copied with an additional transformation.
+type transformedPattern interface {
+	// withMatrix returns a copy of the pattern whose transformation is followed by m.
+	withMatrix(m Matrix) Pattern
+}
+
+// cachedPattern is implemented by patterns that are painted from a cache of their colors, like mesh gradients.
+type cachedPattern interface {
+	// withCache returns a copy of the pattern that is painted from a cache of its colors over r.
+	withCache(r image.Rectangle) Pattern
+}
+
+// patternOver returns a pattern that paints p over the area r. Patterns with a cache get one that covers r, so that
+// it is built before painting and isn't larger than the area.
+func patternOver(p Pattern, r image.Rectangle) Pattern {
+	if cp, ok := p.(cachedPattern); ok {
+		return cp.withCache(r)
+	}
+
+	return p
+}
+
+// userSpacePattern paints any other Pattern with a transformation, by sampling it at the nearest pixel.
+type userSpacePattern struct {
+	p       Pattern
+	inverse Matrix
+}
+
+// ColorAt returns the color of the transformed pattern at the specified coordinates (x, y).
+func (p *userSpacePattern) ColorAt(x, y int) color.Color {
+	px, py := p.inverse.TransformPoint(float64(x)+0.5, float64(y)+0.5)
+
+	return p.p.ColorAt(int(math.Floor(px)), int(math.Floor(py)))
+}
+
+// transformPattern returns a pattern that paints p transformed by m.
+func transformPattern(p Pattern, m Matrix) Pattern {
+	if _, ok := p.(*solidPattern); ok || m == Identity() {
+		return p
+	}
+	if tp, ok := p.(transformedPattern); ok {
+		return tp.withMatrix(m)
+	}
+
+	inverse, ok := m.Invert()
+	if !ok {
+		return p
+	}
+
+	return &userSpacePattern{p: p, inverse: inverse}
+}
+
 // solidPattern is an implementation of the Pattern interface representing a solid color pattern.
 // It provides a consistent color regardless of the sampling coordinates (x, y).
 type solidPattern struct {
@@ -46,6 +164,7 @@ func NewSolidPattern(color color.Color) Pattern {
 
 // surfacePattern is a pattern based on an image that can be repeated in different ways.
 type surfacePattern struct {
+	patternMatrix
 	im image.Image
 	op RepeatOp
 }
@@ -54,38 +173,58 @@ type surfacePattern struct {
 // The behavior of color retrieval depends on the repetition behavior defined by the RepeatOp.
 func (p *surfacePattern) ColorAt(x, y int) color.Color {
 	b := p.im.Bounds()
+	if b.Empty() {
+		return color.Transparent
+	}
 
+	if p.transformed {
+		px, py := p.point(x, y)
+		x, y = int(math.Floor(px)), int(math.Floor(py))
+	}
+
+	outX := x < 0 || x >= b.Dx()
+	outY := y < 0 || y >= b.Dy()
 	switch p.op {
 	case RepeatX:
-		if y >= b.Dy() {
+		if outY {
 			return color.Transparent
 		}
 	case RepeatY:
-		if x >= b.Dx() {
+		if outX {
 			return color.Transparent
 		}
 	case RepeatNone:
-		if x >= b.Dx() || y >= b.Dy() {
+		if outX || outY {
 			return color.Transparent
 		}
 	}
 
-	x = x%b.Dx() + b.Min.X
-	y = y%b.Dy() + b.Min.Y
+	x = (x%b.Dx()+b.Dx())%b.Dx() + b.Min.X
+	y = (y%b.Dy()+b.Dy())%b.Dy() + b.Min.Y
 
 	return p.im.At(x, y)
 }
 
+// withMatrix returns a copy of the surface pattern whose transformation is followed by m.
+func (p *surfacePattern) withMatrix(m Matrix) Pattern {
+	c := *p
+	c.patternMatrix = p.patternMatrix.then(m)
+
+	return &c
+}
+
 // NewSurfacePattern creates a new surface pattern from the given image and repetition behavior.
-func NewSurfacePattern(im image.Image, op RepeatOp) Pattern {
+func NewSurfacePattern(im image.Image, op RepeatOp) SurfacePattern {
 	return &surfacePattern{im: im, op: op}
 }
 
 // patternPainter is responsible for painting patterns onto an image using a mask.
 type patternPainter struct {
-	im   *image.RGBA
-	mask *image.Alpha
-	p    Pattern
+	im    *image.RGBA
+	mask  *image.Alpha
+	p     Pattern
+	alpha uint32
+	span  []color.RGBA64
 }
 
 // Paint paints a sequence of spans onto the target image with the specified mask.
@@ -117,6 +256,15 @@ func (r *patternPainter) Paint(ss []raster.Span, _ bool) {
 		i0 := (s.Y-r.im.Rect.Min.Y)*r.im.Stride + (s.X0-r.im.Rect.Min.X)*4
 		i1 := i0 + (s.X1-s.X0)*4
 
+		sp, spans := r.p.(SpanPattern)
+		if spans {
+			if n := s.X1 - s.X0; cap(r.span) < n {
+				r.span = make([]color.RGBA64, n)
+			}
+			r.span = r.span[:s.X1-s.X0]
+			sp.ColorSpan(r.span, x0, y)
+		}
+
 		for i, x := i0, x0; i < i1; i, x = i+4, x+1 {
 			ma := s.Alpha
 
@@ -126,9 +274,17 @@ func (r *patternPainter) Paint(ss []raster.Span, _ bool) {
 					continue
 				}
 			}
+			if r.alpha < m {
+				ma = ma * r.alpha / m
+			}
 
-			c := r.p.ColorAt(x, y)
-			cr, cg, cb, ca := c.RGBA()
+			var cr, cg, cb, ca uint32
+			if spans {
+				c := r.span[x-x0]
+				cr, cg, cb, ca = uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A)
+			} else {
+				cr, cg, cb, ca = r.p.ColorAt(x, y).RGBA()
+			}
 			dr := uint32(r.im.Pix[i+0])
 			dg := uint32(r.im.Pix[i+1])
 			db := uint32(r.im.Pix[i+2])
@@ -144,7 +300,8 @@ func (r *patternPainter) Paint(ss []raster.Span, _ bool) {
 }
 
 // newPatternPainter creates a new patternPainter, which is a painter that applies a given pattern to an RGBA image
-// while respecting an optional mask. It is used to paint patterns onto an image.
+// while respecting an optional mask. It is used to paint patterns onto an image. The pattern is painted fully opaque
+// until the alpha field is lowered.
 func newPatternPainter(im *image.RGBA, mask *image.Alpha, p Pattern) *patternPainter {
-	return &patternPainter{im, mask, p}
+	return &patternPainter{im: im, mask: mask, p: p, alpha: 1<<16 - 1}
 }
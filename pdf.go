@@ -0,0 +1,690 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// pdfEncoder writes recorded pages as a PDF document.
+//
+// Objects are numbered as they are allocated and may be written in any order; the cross-reference table
+// written at the end records where each of them starts.
+type pdfEncoder struct {
+	buf       bytes.Buffer
+	offsets   []int
+	resources int
+	fonts     map[any]*pdfFont
+	fontList  []*pdfFont
+	images    map[image.Image]string
+	states    map[string]string
+	shadings  []string
+	xobjects  []string
+	sfntBuf   sfnt.Buffer
+	alpha     float64
+}
+
+// newPDFEncoder creates a pdfEncoder.
+func newPDFEncoder() *pdfEncoder {
+	return &pdfEncoder{
+		fonts:  make(map[any]*pdfFont),
+		images: make(map[image.Image]string),
+		states: make(map[string]string),
+	}
+}
+
+// alloc reserves the next object number.
+func (e *pdfEncoder) alloc() int {
+	e.offsets = append(e.offsets, -1)
+	return len(e.offsets)
+}
+
+// object writes an indirect object with the given number and body.
+func (e *pdfEncoder) object(n int, body string) {
+	e.offsets[n-1] = e.buf.Len()
+	fmt.Fprintf(&e.buf, "%d 0 obj\n%s\nendobj\n", n, body)
+}
+
+// stream writes an indirect stream object with the given number, extra dictionary entries and data,
+// compressing the data.
+func (e *pdfEncoder) stream(n int, dict string, data []byte) {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(data)
+	zw.Close()
+
+	e.offsets[n-1] = e.buf.Len()
+	fmt.Fprintf(&e.buf, "%d 0 obj\n<< %s /Filter /FlateDecode /Length %d >>\nstream\n", n, dict, z.Len())
+	e.buf.Write(z.Bytes())
+	fmt.Fprintf(&e.buf, "\nendstream\nendobj\n")
+}
+
+// encode writes the complete PDF document for the given pages to w.
+func (e *pdfEncoder) encode(w io.Writer, pages []*recordPage) error {
+	e.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	catalog := e.alloc()
+	pagesObj := e.alloc()
+	e.resources = e.alloc()
+
+	kids := make([]string, len(pages))
+	for i, page := range pages {
+		content := e.alloc()
+		pageObj := e.alloc()
+		kids[i] = fmt.Sprintf("%d 0 R", pageObj)
+		e.stream(content, "", e.content(page))
+		e.object(pageObj, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources %d 0 R /Contents %d 0 R >>",
+			pagesObj, pdfNum(page.width), pdfNum(page.height), e.resources, content))
+	}
+
+	fonts := make([]string, 0)
+	for i, f := range e.fontList {
+		fonts = append(fonts, e.writeFont(f, pdfSubsetTag(i)))
+	}
+
+	var states []string
+	for key, name := range e.states {
+		states = append(states, fmt.Sprintf("/%s %s", name, key))
+	}
+	sort.Strings(states)
+
+	e.object(e.resources, fmt.Sprintf("<< /ProcSet [/PDF /Text /ImageB /ImageC] /Font << %s >> /XObject << %s >> /ExtGState << %s >> /Shading << %s >> >>",
+		strings.Join(fonts, " "), strings.Join(e.xobjects, " "), strings.Join(states, " "), strings.Join(e.shadings, " ")))
+	e.object(pagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	e.object(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesObj))
+
+	xref := e.buf.Len()
+	fmt.Fprintf(&e.buf, "xref\n0 %d\n0000000000 65535 f \n", len(e.offsets)+1)
+	for _, offset := range e.offsets {
+		fmt.Fprintf(&e.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&e.buf, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(e.offsets)+1, catalog, xref)
+
+	_, err := w.Write(e.buf.Bytes())
+
+	return err
+}
+
+// content returns the content stream of a page. The page's coordinate space is flipped first so that,
+// like the context, the origin is the top-left corner and Y increases down.
+func (e *pdfEncoder) content(page *recordPage) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "1 0 0 -1 0 %s cm\n", pdfNum(page.height))
+
+	for _, op := range page.ops {
+		b.WriteString("q\n")
+		e.clip(&b, op.clip, page)
+		if mode := pdfBlendMode(op.operator); mode != "" {
+			fmt.Fprintf(&b, "/%s gs\n", e.state(fmt.Sprintf("<< /BM /%s >>", mode)))
+		}
+		e.alpha = op.alpha
+		if op.alpha < 1 {
+			fmt.Fprintf(&b, "/%s gs\n", e.state(fmt.Sprintf("<< /CA %[1]s /ca %[1]s >>", pdfNum(op.alpha))))
+		}
+		switch op.kind {
+		case recordFill:
+			e.paint(&b, op.pattern, op.path, op.fillRule == FillRuleEvenOdd, page)
+		case recordStroke:
+			e.stroke(&b, op, page)
+		case recordImage:
+			e.image(&b, op.image, op.matrix)
+		case recordText:
+			e.text(&b, op)
+		}
+		b.WriteString("Q\n")
+	}
+
+	return b.Bytes()
+}
+
+// clip intersects the clipping region with every node of a clip chain, starting at the root.
+func (e *pdfEncoder) clip(b *bytes.Buffer, c *recordClip, page *recordPage) {
+	if c == nil {
+		return
+	}
+
+	e.clip(b, c.parent, page)
+
+	if c.mask != nil {
+		fmt.Fprintf(b, "/%s gs\n", e.softMask(c.mask, page))
+		return
+	}
+
+	pdfPath(b, c.path)
+	if c.fillRule == FillRuleEvenOdd {
+		b.WriteString("W* n\n")
+	} else {
+		b.WriteString("W n\n")
+	}
+}
+
+// paint fills a path with a pattern. Solid colors and gradients with a PDF equivalent are written as such;
+// conic gradients are approximated with solid wedges, and any other pattern, including repeating linear and
+// radial gradients, is sampled into an image.
+func (e *pdfEncoder) paint(b *bytes.Buffer, p Pattern, path Path, evenOdd bool, page *recordPage) {
+	if sp, ok := p.(*solidPattern); ok {
+		e.fillColor(b, sp.color)
+		pdfPath(b, path)
+		if evenOdd {
+			b.WriteString("f*\n")
+		} else {
+			b.WriteString("f\n")
+		}
+		return
+	}
+
+	pdfPath(b, path)
+	if evenOdd {
+		b.WriteString("W* n\n")
+	} else {
+		b.WriteString("W n\n")
+	}
+
+	switch p := p.(type) {
+	case *linearGradient:
+		// PDF shadings can only extend their end colors, so repeating gradients are sampled
+		if p.spread != SpreadPad {
+			e.sample(b, p, path, page)
+		} else if len(p.stops) > 0 {
+			pdfTransform(b, p.patternMatrix)
+			fmt.Fprintf(b, "/%s sh\n", e.shading(2, fmt.Sprintf("[%s %s %s %s]", pdfNum(p.x0), pdfNum(p.y0), pdfNum(p.x1), pdfNum(p.y1)), srgbStops(p.stops, p.interpolation)))
+		}
+	case *radialGradient:
+		if p.spread != SpreadPad {
+			e.sample(b, p, path, page)
+		} else if len(p.stops) > 0 {
+			pdfTransform(b, p.patternMatrix)
+			fmt.Fprintf(b, "/%s sh\n", e.shading(3, fmt.Sprintf("[%s %s %s %s %s %s]",
+				pdfNum(p.c0.x), pdfNum(p.c0.y), pdfNum(p.c0.r), pdfNum(p.c1.x), pdfNum(p.c1.y), pdfNum(p.c1.r)), srgbStops(p.stops, p.interpolation)))
+		}
+	case *conicGradient:
+		if len(p.stops) > 0 {
+			pdfTransform(b, p.patternMatrix)
+			e.conic(b, p, page)
+		}
+	case *bilinearGradient:
+		pdfTransform(b, p.patternMatrix)
+		fmt.Fprintf(b, "/%s sh\n", e.meshShading(p.patches()))
+	case *meshGradient:
+		if len(p.patchList) > 0 {
+			pdfTransform(b, p.patternMatrix)
+			fmt.Fprintf(b, "/%s sh\n", e.meshShading(p.patches()))
+		}
+	default:
+		e.sample(b, p, path, page)
+	}
+}
+
+// stroke strokes a path. Solid colors use the PDF stroke operator directly; other patterns paint the
+// outline of the stroke, which is computed the same way as for raster output.
+func (e *pdfEncoder) stroke(b *bytes.Buffer, op recordOp, page *recordPage) {
+	sp, ok := op.pattern.(*solidPattern)
+	if !ok {
+		e.paint(b, op.pattern, strokeOutline(op), false, page)
+		return
+	}
+
+	e.strokeColor(b, sp.color)
+	fmt.Fprintf(b, "%s w %d J %d j\n", pdfNum(op.lineWidth), pdfLineCap(op.lineCap), pdfLineJoin(op.lineJoin))
+	if op.lineJoin == LineJoinMiter {
+		fmt.Fprintf(b, "%s M\n", pdfNum(op.miterLimit))
+	}
+	if len(op.dashes) > 0 {
+		dashes := make([]string, len(op.dashes))
+		for i, d := range op.dashes {
+			dashes[i] = pdfNum(d)
+		}
+		fmt.Fprintf(b, "[%s] %s d\n", strings.Join(dashes, " "), pdfNum(op.dashOffset))
+	}
+	pdfPath(b, op.path)
+	b.WriteString("S\n")
+}
+
+// sample paints a pattern over the bounds of a path, clipped to the page, as an image of its colors.
+func (e *pdfEncoder) sample(b *bytes.Buffer, p Pattern, path Path, page *recordPage) {
+	bounds := pathBounds(path).Intersect(image.Rect(0, 0, int(math.Ceil(page.width)), int(math.Ceil(page.height))))
+	if bounds.Empty() {
+		return
+	}
+	im := image.NewRGBA(bounds)
+	p = patternOver(p, bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			im.Set(x, y, p.ColorAt(x, y))
+		}
+	}
+	e.image(b, im, Identity())
+}
+
+// strokeOutline returns the outline of a recorded stroke as a path that can be filled with the nonzero rule.
+func strokeOutline(op recordOp) Path {
+	dc := &Context{
+		strokePath: rasterPath(op.path.flatten()),
+		path:       op.path,
+		lineWidth:  op.lineWidth,
+		lineCap:    op.lineCap,
+		lineJoin:   op.lineJoin,
+		miterLimit: op.miterLimit,
+		dashes:     op.dashes,
+		dashOffset: op.dashOffset,
+	}
+
+	return dc.strokeOutline()
+}
+
+// pathBounds returns the integer bounding box of a recorded path.
+func pathBounds(path Path) image.Rectangle {
+	if len(path) == 0 {
+		return image.Rectangle{}
+	}
+
+	x0, y0, x1, y1 := path.Bounds()
+
+	return image.Rect(int(math.Floor(x0)), int(math.Floor(y0)), int(math.Ceil(x1)), int(math.Ceil(y1)))
+}
+
+// conic paints a conic gradient over the clipping region as solid wedges around the gradient center.
+func (e *pdfEncoder) conic(b *bytes.Buffer, g *conicGradient, page *recordPage) {
+	r := conicRadius(g, page.width, page.height)
+
+	for i := 0; i < conicWedges; i++ {
+		t0 := float64(i) / conicWedges
+		t1 := float64(i+1) / conicWedges
+		a0 := (t0+g.rotation)*2*math.Pi - math.Pi
+		// the wedges overlap slightly to hide anti-aliasing seams
+		a1 := (t1+g.rotation)*2*math.Pi - math.Pi + 0.5/r
+		e.fillColor(b, g.colorAt((t0+t1)/2))
+		fmt.Fprintf(b, "%s %s m %s %s l %s %s l h f\n",
+			pdfNum(g.cx), pdfNum(g.cy),
+			pdfNum(g.cx+r*math.Cos(a0)), pdfNum(g.cy+r*math.Sin(a0)),
+			pdfNum(g.cx+r*math.Cos(a1)), pdfNum(g.cy+r*math.Sin(a1)))
+	}
+}
+
+// shading writes an axial (2) or radial (3) shading for gradient stops and returns its resource name.
+// PDF shadings have no alpha, so the stop colors are written opaque.
+func (e *pdfEncoder) shading(shadingType int, coords string, stops stops) string {
+	name := fmt.Sprintf("Sh%d", len(e.shadings)+1)
+	n := e.alloc()
+
+	e.object(n, fmt.Sprintf("<< /ShadingType %d /ColorSpace /DeviceRGB /Coords %s /Function %s /Extend [true true] >>",
+		shadingType, coords, pdfStopsFunction(stops)))
+	e.shadings = append(e.shadings, fmt.Sprintf("/%s %d 0 R", name, n))
+
+	return name
+}
+
+// meshShading writes a Coons patch mesh shading (6) for the patches of a mesh gradient and returns its resource name.
+// Like the other shadings, the colors are written opaque.
+func (e *pdfEncoder) meshShading(patches []meshPatch) string {
+	x0, y0 := math.Inf(1), math.Inf(1)
+	x1, y1 := math.Inf(-1), math.Inf(-1)
+	for _, patch := range patches {
+		for _, p := range patch.points {
+			x0, y0 = math.Min(x0, p.X), math.Min(y0, p.Y)
+			x1, y1 = math.Max(x1, p.X), math.Max(y1, p.Y)
+		}
+	}
+	// whole numbers keep the decode range exact
+	x0, y0 = math.Floor(x0), math.Floor(y0)
+	x1, y1 = math.Max(math.Ceil(x1), x0+1), math.Max(math.Ceil(y1), y0+1)
+
+	var data []byte
+	for _, patch := range patches {
+		// every patch has its own four corners, so its edge flag is 0
+		data = append(data, 0)
+		for _, p := range patch.points {
+			data = binary.BigEndian.AppendUint32(data, uint32(math.Round((p.X-x0)/(x1-x0)*math.MaxUint32)))
+			data = binary.BigEndian.AppendUint32(data, uint32(math.Round((p.Y-y0)/(y1-y0)*math.MaxUint32)))
+		}
+		for _, c := range patch.colors {
+			for k := 0; k < 3; k++ {
+				v := 0.0
+				if c[3] > 0 {
+					v = math.Min(1, c[k]/c[3])
+				}
+				data = binary.BigEndian.AppendUint16(data, uint16(math.Round(v*0xffff)))
+			}
+		}
+	}
+
+	name := fmt.Sprintf("Sh%d", len(e.shadings)+1)
+	n := e.alloc()
+	e.stream(n, fmt.Sprintf("/ShadingType 6 /ColorSpace /DeviceRGB /BitsPerCoordinate 32 /BitsPerComponent 16 /BitsPerFlag 8 /Decode [%s %s %s %s 0 1 0 1 0 1]",
+		pdfNum(x0), pdfNum(x1), pdfNum(y0), pdfNum(y1)), data)
+	e.shadings = append(e.shadings, fmt.Sprintf("/%s %d 0 R", name, n))
+
+	return name
+}
+
+// pdfStopsFunction returns an inline function mapping [0, 1] to the colors of gradient stops, stitching
+// together one exponential interpolation function per pair of stops.
+func pdfStopsFunction(stops stops) string {
+	rgb := func(c color.Color) string {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		return fmt.Sprintf("[%s %s %s]", pdfNum(float64(n.R)/255), pdfNum(float64(n.G)/255), pdfNum(float64(n.B)/255))
+	}
+
+	var clamped []stop
+	for _, s := range stops {
+		s.pos = math.Max(0, math.Min(1, s.pos))
+		clamped = append(clamped, s)
+	}
+	if clamped[0].pos > 0 {
+		clamped = append([]stop{{0, clamped[0].color}}, clamped...)
+	}
+	if last := clamped[len(clamped)-1]; last.pos < 1 {
+		clamped = append(clamped, stop{1, last.color})
+	}
+	if len(clamped) == 1 {
+		clamped = append(clamped, clamped[0])
+	}
+
+	var functions, bounds, encode []string
+	for i := 1; i < len(clamped); i++ {
+		functions = append(functions, fmt.Sprintf("<< /FunctionType 2 /Domain [0 1] /C0 %s /C1 %s /N 1 >>", rgb(clamped[i-1].color), rgb(clamped[i].color)))
+		encode = append(encode, "0 1")
+		if i < len(clamped)-1 {
+			bounds = append(bounds, pdfNum(clamped[i].pos))
+		}
+	}
+
+	return fmt.Sprintf("<< /FunctionType 3 /Domain [0 1] /Functions [%s] /Bounds [%s] /Encode [%s] >>",
+		strings.Join(functions, " "), strings.Join(bounds, " "), strings.Join(encode, " "))
+}
+
+// fillColor sets the fill color, including its alpha multiplied by the alpha of the current operation.
+func (e *pdfEncoder) fillColor(b *bytes.Buffer, c color.Color) {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if n.A < 255 {
+		fmt.Fprintf(b, "/%s gs\n", e.state(fmt.Sprintf("<< /ca %s >>", pdfNum(float64(n.A)/255*e.alpha))))
+	}
+	fmt.Fprintf(b, "%s %s %s rg\n", pdfNum(float64(n.R)/255), pdfNum(float64(n.G)/255), pdfNum(float64(n.B)/255))
+}
+
+// strokeColor sets the stroke color, including its alpha multiplied by the alpha of the current operation.
+func (e *pdfEncoder) strokeColor(b *bytes.Buffer, c color.Color) {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if n.A < 255 {
+		fmt.Fprintf(b, "/%s gs\n", e.state(fmt.Sprintf("<< /CA %s >>", pdfNum(float64(n.A)/255*e.alpha))))
+	}
+	fmt.Fprintf(b, "%s %s %s RG\n", pdfNum(float64(n.R)/255), pdfNum(float64(n.G)/255), pdfNum(float64(n.B)/255))
+}
+
+// state returns the resource name of a graphics state parameter dictionary, adding it if needed.
+func (e *pdfEncoder) state(dict string) string {
+	if name, ok := e.states[dict]; ok {
+		return name
+	}
+
+	name := fmt.Sprintf("GS%d", len(e.states)+1)
+	e.states[dict] = name
+
+	return name
+}
+
+// softMask returns the name of a graphics state that installs a raster mask as a luminosity soft mask.
+func (e *pdfEncoder) softMask(mask *image.Alpha, page *recordPage) string {
+	b := mask.Bounds()
+	gray := &image.Gray{Pix: make([]uint8, b.Dx()*b.Dy()), Stride: b.Dx(), Rect: b}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			gray.Pix[gray.PixOffset(x, y)] = mask.AlphaAt(x, y).A
+		}
+	}
+
+	im := e.imageObject(gray)
+	form := e.alloc()
+	content := fmt.Sprintf("q %d 0 0 %d %d %d cm /Im Do Q", b.Dx(), -b.Dy(), b.Min.X, b.Max.Y)
+	e.stream(form, fmt.Sprintf("/Type /XObject /Subtype /Form /BBox [0 0 %s %s] /Group << /S /Transparency /CS /DeviceGray >> /Resources << /XObject << /Im %d 0 R >> >>",
+		pdfNum(page.width), pdfNum(page.height), im), []byte(content))
+
+	return e.state(fmt.Sprintf("<< /SMask << /Type /Mask /S /Luminosity /G %d 0 R >> >>", form))
+}
+
+// image draws an image with the given matrix, mapping the image's pixel grid like the raster output does.
+func (e *pdfEncoder) image(b *bytes.Buffer, im image.Image, m Matrix) {
+	// images are shared between uses when they can be told apart by identity
+	comparable := reflect.TypeOf(im).Comparable()
+	name, ok := "", false
+	if comparable {
+		name, ok = e.images[im]
+	}
+	if !ok {
+		name = fmt.Sprintf("Im%d", len(e.xobjects)+1)
+		e.xobjects = append(e.xobjects, fmt.Sprintf("/%s %d 0 R", name, e.imageObject(im)))
+		if comparable {
+			e.images[im] = name
+		}
+	}
+
+	r := im.Bounds()
+	fmt.Fprintf(b, "%s cm\n", pdfMatrix(m))
+	fmt.Fprintf(b, "%d 0 0 %d %d %d cm /%s Do\n", r.Dx(), -r.Dy(), r.Min.X, r.Max.Y, name)
+}
+
+// imageObject writes an image XObject, with a soft mask holding its alpha if it isn't opaque, and returns
+// its object number.
+func (e *pdfEncoder) imageObject(im image.Image) int {
+	r := im.Bounds()
+
+	if gray, ok := im.(*image.Gray); ok {
+		n := e.alloc()
+		data := make([]byte, 0, r.Dx()*r.Dy())
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			i := gray.PixOffset(r.Min.X, y)
+			data = append(data, gray.Pix[i:i+r.Dx()]...)
+		}
+		e.stream(n, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8", r.Dx(), r.Dy()), data)
+		return n
+	}
+
+	rgb := make([]byte, 0, r.Dx()*r.Dy()*3)
+	alpha := make([]byte, 0, r.Dx()*r.Dy())
+	opaque := true
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.NRGBAModel.Convert(im.At(x, y)).(color.NRGBA)
+			rgb = append(rgb, c.R, c.G, c.B)
+			alpha = append(alpha, c.A)
+			opaque = opaque && c.A == 255
+		}
+	}
+
+	smask := ""
+	if !opaque {
+		n := e.alloc()
+		e.stream(n, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8", r.Dx(), r.Dy()), alpha)
+		smask = fmt.Sprintf(" /SMask %d 0 R", n)
+	}
+
+	n := e.alloc()
+	e.stream(n, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8%s", r.Dx(), r.Dy(), smask), rgb)
+
+	return n
+}
+
+// text writes a recorded string with the embedded font of its face. The glyphs are shaped, so their positions are set
+// with adjustments and rises where they differ from the widths of the font.
+func (e *pdfEncoder) text(b *bytes.Buffer, op recordOp) {
+	pf, size := e.font(op.face)
+	e.fillColor(b, op.color)
+	fmt.Fprintf(b, "%s cm\nBT\n1 0 0 -1 %s %s Tm\n/%s %s Tf\n", pdfMatrix(op.matrix), pdfNum(op.x), pdfNum(op.y), pf.name, pdfNum(size))
+
+	var run strings.Builder
+	flush := func() {
+		if run.Len() > 0 {
+			fmt.Fprintf(b, "[%s] TJ\n", run.String())
+			run.Reset()
+		}
+	}
+
+	pen, rise := 0.0, 0.0
+	for _, g := range shapeString(op.face, op.text).glyphs {
+		if pf.font != nil && g.gid == 0 || pf.font == nil && g.r < 0 {
+			continue
+		}
+		cid := e.cid(pf, g, op.text[g.start:g.end])
+		if y := -unfix(g.dot.Y); y != rise {
+			flush()
+			fmt.Fprintf(b, "%s Ts\n", pdfNum(y))
+			rise = y
+		}
+		x := unfix(g.dot.X)
+		// the positions are rounded to 1/64 pixel, so smaller differences are rounding errors
+		if d := x - pen; math.Abs(d) >= 1.0/64 {
+			fmt.Fprintf(&run, " %s ", pdfNum(-d/size*1000))
+		}
+		fmt.Fprintf(&run, "<%04x>", cid)
+		if cid > 0 {
+			pen = x + float64(pf.glyphs[cid-1].advance)/float64(pf.units)*size
+		} else {
+			pen = x
+		}
+	}
+	flush()
+	if rise != 0 {
+		b.WriteString("0 Ts\n")
+	}
+
+	b.WriteString("ET\n")
+}
+
+// glyphPath converts glyph outline segments into a recorded path in glyph space, where Y increases up.
+func glyphPath(segments sfnt.Segments) Path {
+	path := make(Path, 0, len(segments))
+	pt := func(p fixed.Point26_6) Point {
+		return Point{unfix(p.X), -unfix(p.Y)}
+	}
+
+	for _, s := range segments {
+		a := s.Args
+		switch s.Op {
+		case sfnt.SegmentOpMoveTo:
+			if len(path) > 0 {
+				path = append(path, PathSegment{Op: PathClose})
+			}
+			path = append(path, PathSegment{Op: PathMoveTo, Points: [3]Point{pt(a[0])}})
+		case sfnt.SegmentOpLineTo:
+			path = append(path, PathSegment{Op: PathLineTo, Points: [3]Point{pt(a[0])}})
+		case sfnt.SegmentOpQuadTo:
+			path = append(path, PathSegment{Op: PathQuadTo, Points: [3]Point{pt(a[0]), pt(a[1])}})
+		case sfnt.SegmentOpCubeTo:
+			path = append(path, PathSegment{Op: PathCubicTo, Points: [3]Point{pt(a[0]), pt(a[1]), pt(a[2])}})
+		}
+	}
+
+	if len(path) > 0 {
+		path = append(path, PathSegment{Op: PathClose})
+	}
+
+	return path
+}
+
+// pdfPath writes a recorded path as PDF path construction operators. Quadratic curves are raised to cubic
+// ones, since PDF has no quadratic curves.
+func pdfPath(b *bytes.Buffer, path Path) {
+	var current Point
+
+	for _, s := range path {
+		switch s.Op {
+		case PathMoveTo:
+			fmt.Fprintf(b, "%s %s m\n", pdfNum(s.Points[0].X), pdfNum(s.Points[0].Y))
+			current = s.Points[0]
+		case PathLineTo:
+			fmt.Fprintf(b, "%s %s l\n", pdfNum(s.Points[0].X), pdfNum(s.Points[0].Y))
+			current = s.Points[0]
+		case PathQuadTo:
+			c1 := current.Interpolate(s.Points[0], 2.0/3)
+			c2 := s.Points[1].Interpolate(s.Points[0], 2.0/3)
+			fmt.Fprintf(b, "%s %s %s %s %s %s c\n", pdfNum(c1.X), pdfNum(c1.Y), pdfNum(c2.X), pdfNum(c2.Y), pdfNum(s.Points[1].X), pdfNum(s.Points[1].Y))
+			current = s.Points[1]
+		case PathCubicTo:
+			fmt.Fprintf(b, "%s %s %s %s %s %s c\n",
+				pdfNum(s.Points[0].X), pdfNum(s.Points[0].Y), pdfNum(s.Points[1].X), pdfNum(s.Points[1].Y), pdfNum(s.Points[2].X), pdfNum(s.Points[2].Y))
+			current = s.Points[2]
+		case PathClose:
+			b.WriteString("h\n")
+		}
+	}
+}
+
+// pdfLineCap returns the PDF line cap style of a LineCap.
+func pdfLineCap(lineCap LineCap) int {
+	switch lineCap {
+	case LineCapRound:
+		return 1
+	case LineCapSquare:
+		return 2
+	}
+
+	return 0
+}
+
+// pdfLineJoin returns the PDF line join style of a LineJoin.
+func pdfLineJoin(lineJoin LineJoin) int {
+	switch lineJoin {
+	case LineJoinRound:
+		return 1
+	case LineJoinBevel:
+		return 2
+	}
+
+	return 0
+}
+
+// pdfBlendMode returns the PDF blend mode of a composite operator, or an empty string for source-over and
+// the Porter-Duff operators, which PDF can't express.
+func pdfBlendMode(op CompositeOperator) string {
+	name := svgBlendMode(op)
+	if name == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, word := range strings.Split(name, "-") {
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+
+	return b.String()
+}
+
+// pdfTransform concatenates the matrix of a pattern to the current transformation, if it is transformed. It is only
+// used after the clip of an operation is set, when nothing but the pattern is left to paint.
+func pdfTransform(b *bytes.Buffer, t patternMatrix) {
+	if t.transformed {
+		fmt.Fprintf(b, "%s cm\n", pdfMatrix(t.matrix))
+	}
+}
+
+// pdfMatrix formats a matrix as the operands of the cm operator.
+func pdfMatrix(m Matrix) string {
+	return fmt.Sprintf("%s %s %s %s %s %s", pdfNum(m.XX), pdfNum(m.YX), pdfNum(m.XY), pdfNum(m.YY), pdfNum(m.X0), pdfNum(m.Y0))
+}
+
+// pdfNum formats a number with at most four decimals, as PDF does not accept exponents.
+func pdfNum(v float64) string {
+	v = math.Round(v*10000) / 10000
+	if v == 0 {
+		v = 0
+	}
+
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
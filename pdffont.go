@@ -0,0 +1,591 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// pdfMaskUnits is the number of units per em of the fonts traced from the masks of faces without outlines, where one
+// em is one pixel and one unit is 1/64 pixel, like fixed.Int26_6.
+const pdfMaskUnits = 64
+
+// pdfFont is an embedded TrueType font holding the subset of glyphs of a face used by a document, written as a Type 0
+// font whose character codes are the glyph indices of the subset. Faces created by FontNewFace are subset from the
+// outlines of their font, which are shared by the faces of all sizes; other faces have no outlines, so their glyphs are
+// traced from their masks, pixel by pixel.
+type pdfFont struct {
+	font   *opentype.Font // the font of the face, or nil for faces traced from their masks
+	face   font.Face
+	name   string
+	units  int // the units per em of the glyphs
+	glyphs []pdfGlyph
+	cids   map[pdfGlyphKey]int
+}
+
+// pdfGlyphKey identifies a glyph of a face: by index in a font, or by rune for faces traced from their masks.
+type pdfGlyphKey struct {
+	gid sfnt.GlyphIndex
+	r   rune
+}
+
+// pdfGlyph is a glyph of the subset of a pdfFont.
+type pdfGlyph struct {
+	key     pdfGlyphKey
+	advance int    // the advance width, in the units of the font
+	text    string // the text the glyph stands for, empty for glyphs added by substitutions
+}
+
+// font returns the embedded font of a face, adding it if needed, and the size of its text in user space units per em.
+// Faces that can't be told apart, because their type isn't comparable, get a font of their own.
+func (e *pdfEncoder) font(face font.Face) (*pdfFont, float64) {
+	f, points, ok := faceFont(face)
+	var key any = face
+	size, units := 1.0, pdfMaskUnits
+	if ok {
+		key, size, units = f, points, int(f.UnitsPerEm())
+	}
+
+	shared := ok || reflect.TypeOf(face).Comparable()
+	if shared {
+		if pf := e.fonts[key]; pf != nil {
+			return pf, size
+		}
+	}
+	pf := &pdfFont{font: f, face: face, name: fmt.Sprintf("F%d", len(e.fontList)+1), units: units, cids: make(map[pdfGlyphKey]int)}
+	if shared {
+		e.fonts[key] = pf
+	}
+	e.fontList = append(e.fontList, pf)
+
+	return pf, size
+}
+
+// cid returns the character code of a shaped glyph, which is its index in the subset, adding the glyph and the text it
+// stands for if needed. Glyph 0 of the subset is the missing glyph, which is also used once the subset is full.
+func (e *pdfEncoder) cid(f *pdfFont, g shapedGlyph, text string) int {
+	key := pdfGlyphKey{gid: g.gid}
+	if f.font == nil {
+		key = pdfGlyphKey{r: g.r}
+	}
+	if cid, ok := f.cids[key]; ok {
+		return cid
+	}
+	if len(f.glyphs) == math.MaxUint16 {
+		return 0
+	}
+
+	// the advances of fonts are in 26.6 font units, and those of faces in 1/64 pixel, the units of their traced glyphs
+	var advance int
+	if f.font != nil {
+		a, _ := f.font.GlyphAdvance(&e.sfntBuf, key.gid, fixed.I(f.units), font.HintingNone)
+		advance = a.Round()
+	} else {
+		a, _ := f.face.GlyphAdvance(key.r)
+		advance = int(a)
+	}
+	f.glyphs = append(f.glyphs, pdfGlyph{key: key, advance: advance, text: text})
+	cid := len(f.glyphs)
+	f.cids[key] = cid
+
+	return cid
+}
+
+// writeFont writes the Type 0 font of a subset, with its CIDFont, descriptor, TrueType font program and ToUnicode CMap,
+// and returns its resource dictionary entry. The name of the font is prefixed with the tag of the subset.
+func (e *pdfEncoder) writeFont(f *pdfFont, tag string) string {
+	glyphs := make([]ttGlyph, len(f.glyphs)+1)
+	runes := make(map[rune]int)
+	var widths, chars []string
+	for i, g := range f.glyphs {
+		cid := i + 1
+		glyphs[cid] = ttGlyph{contours: e.contours(f, g.key), advance: g.advance}
+		widths = append(widths, pdfNum(float64(g.advance)*1000/float64(f.units)))
+
+		// glyphs added by substitutions stand for no text of their own
+		if g.text == "" {
+			continue
+		}
+		if r, size := utf8.DecodeRuneInString(g.text); size == len(g.text) && runes[r] == 0 {
+			runes[r] = cid
+		}
+		var char strings.Builder
+		fmt.Fprintf(&char, "<%04x> <", cid)
+		for _, c := range utf16.Encode([]rune(g.text)) {
+			fmt.Fprintf(&char, "%04x", c)
+		}
+		char.WriteString(">\n")
+		chars = append(chars, char.String())
+	}
+
+	// a CMap holds at most 100 mappings per block
+	var cmap strings.Builder
+	for start := 0; start < len(chars); start += 100 {
+		block := chars[start:min(start+100, len(chars))]
+		fmt.Fprintf(&cmap, "%d beginbfchar\n%sendbfchar\n", len(block), strings.Join(block, ""))
+	}
+
+	var ascent, descent, capHeight fixed.Int26_6
+	if f.font != nil {
+		if m, err := f.font.Metrics(&e.sfntBuf, fixed.I(f.units), font.HintingNone); err == nil {
+			ascent, descent, capHeight = m.Ascent/64, m.Descent/64, m.CapHeight/64
+		}
+	} else {
+		m := f.face.Metrics()
+		ascent, descent, capHeight = m.Ascent, m.Descent, m.CapHeight
+	}
+	if capHeight == 0 {
+		capHeight = ascent
+	}
+	program, bounds := trueTypeFont(f.units, int(ascent), int(descent), glyphs, runes)
+	scale := func(v int) string {
+		return pdfNum(float64(v) * 1000 / float64(f.units))
+	}
+
+	name := tag + "+" + f.postScriptName(&e.sfntBuf)
+	fontFile := e.alloc()
+	e.stream(fontFile, fmt.Sprintf("/Length1 %d", len(program)), program)
+	descriptor := e.alloc()
+	e.object(descriptor, fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 4 /FontBBox [%s %s %s %s] /ItalicAngle 0 "+
+		"/Ascent %s /Descent %s /CapHeight %s /StemV 80 /FontFile2 %d 0 R >>",
+		name, scale(bounds[0]), scale(bounds[1]), scale(bounds[2]), scale(bounds[3]),
+		scale(int(ascent)), scale(-int(descent)), scale(int(capHeight)), fontFile))
+	cidFont := e.alloc()
+	e.object(cidFont, fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s "+
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R "+
+		"/DW 0 /W [1 [%s]] /CIDToGIDMap /Identity >>", name, descriptor, strings.Join(widths, " ")))
+	toUnicode := e.alloc()
+	e.stream(toUnicode, "", []byte("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n"+
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n"+
+		"1 begincodespacerange\n<0000> <ffff>\nendcodespacerange\n"+cmap.String()+"endcmap\n"+
+		"CMapName currentdict /CMap defineresource pop\nend\nend\n"))
+	fontObj := e.alloc()
+	e.object(fontObj, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		name, cidFont, toUnicode))
+
+	return fmt.Sprintf("/%s %d 0 R", f.name, fontObj)
+}
+
+// contours returns the outline of a glyph of a subset as TrueType contours, in the units of the font with y up.
+func (e *pdfEncoder) contours(f *pdfFont, key pdfGlyphKey) [][]ttPoint {
+	if f.font == nil {
+		return maskContours(f.face, key.r)
+	}
+
+	segments, err := f.font.LoadGlyph(&e.sfntBuf, key.gid, fixed.I(f.units), nil)
+	if err != nil {
+		return nil
+	}
+
+	return segmentContours(segments)
+}
+
+// postScriptName returns the PostScript name of the font of a subset, with the characters that PDF names can't hold
+// left out, or the resource name of the subset if it has none.
+func (f *pdfFont) postScriptName(buf *sfnt.Buffer) string {
+	name := ""
+	if f.font != nil {
+		name, _ = f.font.Name(buf, sfnt.NameIDPostScript)
+	}
+	name = strings.Map(func(r rune) rune {
+		if r > ' ' && r < 0x7f && !strings.ContainsRune("()<>[]{}/%#", r) {
+			return r
+		}
+		return -1
+	}, name)
+	if name == "" {
+		return f.name
+	}
+
+	return name
+}
+
+// pdfSubsetTag returns the tag of six uppercase letters that prefixes the name of the i'th font subset of a document.
+func pdfSubsetTag(i int) string {
+	tag := []byte("GGAAAA")
+	for j := len(tag) - 1; j >= 2 && i > 0; j-- {
+		tag[j] += byte(i % 26)
+		i /= 26
+	}
+
+	return string(tag)
+}
+
+// ttPoint is a point of a TrueType contour, in font units with y up.
+type ttPoint struct {
+	x, y int
+	on   bool // whether the point is on the curve, or the control point of a quadratic curve
+}
+
+// ttGlyph is a glyph of a TrueType font.
+type ttGlyph struct {
+	contours [][]ttPoint
+	advance  int
+}
+
+// segmentContours converts glyph outline segments, in 26.6 font units with y down, into TrueType contours. Cubic
+// curves, which TrueType doesn't have, are split into quadratic curves within a font unit of them.
+func segmentContours(segments sfnt.Segments) [][]ttPoint {
+	var contours [][]ttPoint
+	var current Point
+	add := func(p Point, on bool) {
+		if len(contours) == 0 {
+			contours = append(contours, nil)
+		}
+		c := &contours[len(contours)-1]
+		*c = append(*c, ttPoint{x: int(math.Round(p.X)), y: int(math.Round(p.Y)), on: on})
+	}
+	pt := func(p fixed.Point26_6) Point {
+		return Point{float64(p.X) / 64, -float64(p.Y) / 64}
+	}
+
+	for _, s := range segments {
+		a := s.Args
+		switch s.Op {
+		case sfnt.SegmentOpMoveTo:
+			contours = append(contours, nil)
+			current = pt(a[0])
+			add(current, true)
+		case sfnt.SegmentOpLineTo:
+			current = pt(a[0])
+			add(current, true)
+		case sfnt.SegmentOpQuadTo:
+			add(pt(a[0]), false)
+			current = pt(a[1])
+			add(current, true)
+		case sfnt.SegmentOpCubeTo:
+			p0, p1, p2, p3 := current, pt(a[0]), pt(a[1]), pt(a[2])
+			// the distance of a cubic curve from its closest quadratic one falls with the cube of the number of pieces
+			d := Point{p3.X - 3*p2.X + 3*p1.X - p0.X, p3.Y - 3*p2.Y + 3*p1.Y - p0.Y}
+			n := max(1, min(16, int(math.Ceil(math.Cbrt(math.Sqrt(3)/36*math.Hypot(d.X, d.Y))))))
+			at := func(t float64) (Point, Point) {
+				u := 1 - t
+				p := Point{
+					u*u*u*p0.X + 3*u*u*t*p1.X + 3*u*t*t*p2.X + t*t*t*p3.X,
+					u*u*u*p0.Y + 3*u*u*t*p1.Y + 3*u*t*t*p2.Y + t*t*t*p3.Y,
+				}
+				tangent := Point{
+					3*u*u*(p1.X-p0.X) + 6*u*t*(p2.X-p1.X) + 3*t*t*(p3.X-p2.X),
+					3*u*u*(p1.Y-p0.Y) + 6*u*t*(p2.Y-p1.Y) + 3*t*t*(p3.Y-p2.Y),
+				}
+				return p, tangent
+			}
+			for i := 0; i < n; i++ {
+				t0, t1 := float64(i)/float64(n), float64(i+1)/float64(n)
+				q0, d0 := at(t0)
+				q1, d1 := at(t1)
+				k := (t1 - t0) / 4
+				add(Point{(q0.X+q1.X)/2 + k*(d0.X-d1.X), (q0.Y+q1.Y)/2 + k*(d0.Y-d1.Y)}, false)
+				add(q1, true)
+			}
+			current = p3
+		}
+	}
+
+	// contours are closed, so a last point on the first one is left out
+	var closed [][]ttPoint
+	for _, c := range contours {
+		if n := len(c); n > 1 && c[n-1] == c[0] {
+			c = c[:n-1]
+		}
+		if len(c) > 1 {
+			closed = append(closed, c)
+		}
+	}
+
+	return closed
+}
+
+// maskContours traces the pixels of the mask of a glyph of a face that are at least half opaque into square contours,
+// in 1/64 pixel with y up, relative to the dot of the glyph. The runs of pixels of a row that are the same as in the
+// row above extend its squares down.
+func maskContours(face font.Face, r rune) [][]ttPoint {
+	dr, mask, maskp, _, ok := face.Glyph(fixed.Point26_6{}, r)
+	if !ok || mask == nil {
+		return nil
+	}
+	opaque := func(x, y int) bool {
+		_, _, _, a := mask.At(maskp.X+x-dr.Min.X, maskp.Y+y-dr.Min.Y).RGBA()
+		return a >= 0x8000
+	}
+
+	var rects []image.Rectangle
+	above := make(map[[2]int]int)
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		row := make(map[[2]int]int)
+		for x := dr.Min.X; x < dr.Max.X; x++ {
+			if !opaque(x, y) {
+				continue
+			}
+			x0 := x
+			for x < dr.Max.X && opaque(x, y) {
+				x++
+			}
+			span := [2]int{x0, x}
+			if i, ok := above[span]; ok {
+				rects[i].Max.Y = y + 1
+				row[span] = i
+			} else {
+				row[span] = len(rects)
+				rects = append(rects, image.Rect(x0, y, x, y+1))
+			}
+		}
+		above = row
+	}
+
+	contours := make([][]ttPoint, len(rects))
+	for i, rect := range rects {
+		x0, x1, top, bottom := rect.Min.X*64, rect.Max.X*64, -rect.Min.Y*64, -rect.Max.Y*64
+		contours[i] = []ttPoint{{x0, top, true}, {x1, top, true}, {x1, bottom, true}, {x0, bottom, true}}
+	}
+
+	return contours
+}
+
+// data returns the glyf table data of a simple glyph, with its bounds, xMin, yMin, xMax and yMax, and its number of
+// points. Glyphs without contours have no data.
+func (g ttGlyph) data() ([]byte, [4]int, int) {
+	var bounds [4]int
+	points := 0
+	for _, c := range g.contours {
+		for _, p := range c {
+			if points == 0 {
+				bounds = [4]int{p.x, p.y, p.x, p.y}
+			}
+			bounds = [4]int{min(bounds[0], p.x), min(bounds[1], p.y), max(bounds[2], p.x), max(bounds[3], p.y)}
+			points++
+		}
+	}
+	if points == 0 {
+		return nil, bounds, 0
+	}
+
+	b := binary.BigEndian.AppendUint16(nil, uint16(len(g.contours)))
+	for _, v := range bounds {
+		b = binary.BigEndian.AppendUint16(b, uint16(v))
+	}
+	end := -1
+	for _, c := range g.contours {
+		end += len(c)
+		b = binary.BigEndian.AppendUint16(b, uint16(end))
+	}
+	b = binary.BigEndian.AppendUint16(b, 0)
+
+	// the flags are written one per point, and the coordinates as 16-bit deltas
+	for _, c := range g.contours {
+		for _, p := range c {
+			flag := byte(0)
+			if p.on {
+				flag = 1
+			}
+			b = append(b, flag)
+		}
+	}
+	for axis := 0; axis < 2; axis++ {
+		last := 0
+		for _, c := range g.contours {
+			for _, p := range c {
+				v := p.x
+				if axis == 1 {
+					v = p.y
+				}
+				b = binary.BigEndian.AppendUint16(b, uint16(v-last))
+				last = v
+			}
+		}
+	}
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+
+	return b, bounds, points
+}
+
+// trueTypeFont writes a TrueType font program for glyphs, glyph 0 being the missing glyph, with a cmap table that maps
+// the runes of the Basic Multilingual Plane in runes to their glyphs. It returns the program with the bounds of all the
+// glyphs.
+func trueTypeFont(units, ascent, descent int, glyphs []ttGlyph, runes map[rune]int) ([]byte, [4]int) {
+	u16 := func(b []byte, v int) []byte { return binary.BigEndian.AppendUint16(b, uint16(v)) }
+	u32 := func(b []byte, v int) []byte { return binary.BigEndian.AppendUint32(b, uint32(v)) }
+
+	var glyf, loca, hmtx []byte
+	var fontBounds [4]int
+	maxPoints, maxContours, maxAdvance := 0, 0, 0
+	minLeft, minRight, maxExtent := math.MaxInt16, math.MaxInt16, 0
+	inked := false
+	for _, g := range glyphs {
+		loca = u32(loca, len(glyf))
+		data, bounds, points := g.data()
+		glyf = append(glyf, data...)
+		hmtx = u16(u16(hmtx, g.advance), bounds[0])
+		maxAdvance = max(maxAdvance, g.advance)
+		if points == 0 {
+			continue
+		}
+		if !inked {
+			fontBounds, inked = bounds, true
+		}
+		fontBounds = [4]int{min(fontBounds[0], bounds[0]), min(fontBounds[1], bounds[1]), max(fontBounds[2], bounds[2]), max(fontBounds[3], bounds[3])}
+		maxPoints, maxContours = max(maxPoints, points), max(maxContours, len(g.contours))
+		minLeft, minRight, maxExtent = min(minLeft, bounds[0]), min(minRight, g.advance-bounds[2]), max(maxExtent, bounds[2])
+	}
+	loca = u32(loca, len(glyf))
+	if !inked {
+		minLeft, minRight = 0, 0
+	}
+
+	head := u32(u32(nil, 0x00010000), 0x00010000)
+	head = u32(u32(head, 0), 0x5f0f3cf5)
+	head = u16(u16(head, 3), units)
+	head = append(head, make([]byte, 16)...)
+	for _, v := range fontBounds {
+		head = u16(head, v)
+	}
+	head = u16(u16(u16(u16(u16(head, 0), 8), 2), 1), 0)
+
+	hhea := u32(nil, 0x00010000)
+	hhea = u16(u16(u16(hhea, ascent), -descent), 0)
+	hhea = u16(u16(u16(u16(hhea, maxAdvance), minLeft), minRight), maxExtent)
+	hhea = u16(u16(u16(hhea, 1), 0), 0)
+	hhea = append(hhea, make([]byte, 10)...)
+	hhea = u16(hhea, len(glyphs))
+
+	maxp := u16(u16(u16(u32(nil, 0x00010000), len(glyphs)), maxPoints), maxContours)
+	maxp = u16(u16(u16(maxp, 0), 0), 2)
+	maxp = append(maxp, make([]byte, 16)...)
+
+	post := u32(u32(nil, 0x00030000), 0)
+	post = append(post, make([]byte, 24)...)
+
+	tables := map[string][]byte{
+		"cmap": trueTypeCmap(runes),
+		"glyf": glyf,
+		"head": head,
+		"hhea": hhea,
+		"hmtx": hmtx,
+		"loca": loca,
+		"maxp": maxp,
+		"post": post,
+	}
+
+	return sfntTables(tables), fontBounds
+}
+
+// trueTypeCmap returns a cmap table with a format 4 subtable that maps the runes of the Basic Multilingual Plane in
+// runes to their glyphs, with a segment for every run of consecutive runes and glyphs.
+func trueTypeCmap(runes map[rune]int) []byte {
+	var codes []rune
+	for r := range runes {
+		if r < 0xffff {
+			codes = append(codes, r)
+		}
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+
+	// the segments hold the first and last rune of a run and the glyph of the first one
+	var segments [][3]int
+	for _, r := range codes {
+		if n := len(segments); n > 0 && int(r) == segments[n-1][1]+1 && runes[r] == segments[n-1][2]+int(r)-segments[n-1][0] {
+			segments[n-1][1] = int(r)
+			continue
+		}
+		if len(segments) == 8000 {
+			break
+		}
+		segments = append(segments, [3]int{int(r), int(r), runes[r]})
+	}
+	segments = append(segments, [3]int{0xffff, 0xffff, 0})
+
+	u16 := func(b []byte, v int) []byte { return binary.BigEndian.AppendUint16(b, uint16(v)) }
+	u32 := func(b []byte, v int) []byte { return binary.BigEndian.AppendUint32(b, uint32(v)) }
+	n := len(segments)
+	searchRange := 2 << (bits.Len(uint(n)) - 1)
+	sub := u16(u16(u16(nil, 4), 16+8*n), 0)
+	sub = u16(u16(u16(u16(sub, 2*n), searchRange), bits.Len(uint(searchRange))-2), 2*n-searchRange)
+	for _, s := range segments {
+		sub = u16(sub, s[1])
+	}
+	sub = u16(sub, 0)
+	for _, s := range segments {
+		sub = u16(sub, s[0])
+	}
+	for _, s := range segments {
+		if s[0] == 0xffff {
+			sub = u16(sub, 1)
+		} else {
+			sub = u16(sub, s[2]-s[0])
+		}
+	}
+	for range segments {
+		sub = u16(sub, 0)
+	}
+
+	// one subtable, for Unicode on Windows
+	return append(u32(u16(u16(u16(u16(nil, 0), 1), 3), 1), 12), sub...)
+}
+
+// sfntTables writes the tables of a TrueType font program, sorted by tag, and sets the checksum adjustment of its head
+// table.
+func sfntTables(tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	checksum := func(b []byte) uint32 {
+		var sum uint32
+		for i := 0; i < len(b); i += 4 {
+			var word [4]byte
+			copy(word[:], b[i:])
+			sum += binary.BigEndian.Uint32(word[:])
+		}
+		return sum
+	}
+
+	n := len(tags)
+	searchRange := 16 << (bits.Len(uint(n)) - 1)
+	b := binary.BigEndian.AppendUint32(nil, 0x00010000)
+	b = binary.BigEndian.AppendUint16(b, uint16(n))
+	b = binary.BigEndian.AppendUint16(b, uint16(searchRange))
+	b = binary.BigEndian.AppendUint16(b, uint16(bits.Len(uint(n))-1))
+	b = binary.BigEndian.AppendUint16(b, uint16(16*n-searchRange))
+	offset := 12 + 16*n
+	head := 0
+	for _, tag := range tags {
+		data := tables[tag]
+		if tag == "head" {
+			head = offset
+		}
+		b = append(b, tag...)
+		b = binary.BigEndian.AppendUint32(b, checksum(data))
+		b = binary.BigEndian.AppendUint32(b, uint32(offset))
+		b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+		offset += (len(data) + 3) &^ 3
+	}
+	for _, tag := range tags {
+		b = append(b, tables[tag]...)
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+	}
+	binary.BigEndian.PutUint32(b[head+8:], 0xb1b0afba-checksum(b))
+
+	return b
+}
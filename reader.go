@@ -13,9 +13,13 @@ import (
 	"io/fs"
 	"os"
 	"strings"
+	"sync"
 
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
 )
 
 // LoadImage loads an image from the specified file path and returns it as an image.Image.
@@ -271,9 +275,40 @@ func FontNewFace(f *opentype.Font, points float64, hinting ...font.Hinting) (fon
 		hint = hinting[0]
 	}
 
-	return opentype.NewFace(f, &opentype.FaceOptions{
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{
 		Size:    points,
 		DPI:     72,
 		Hinting: hint,
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &fontFace{Face: face, font: f, points: points, scale: fixed.Int26_6(0.5 + points*64), hinting: hint}, nil
+}
+
+// fontFace is the font.Face returned by FontNewFace. It behaves exactly like the wrapped face, but also
+// remembers the parsed font and the point size, which the vector backends need to describe text, and
+// the scale and hinting of the face, which shaping needs to measure and draw glyphs by index.
+type fontFace struct {
+	font.Face
+	font    *opentype.Font
+	points  float64
+	scale   fixed.Int26_6
+	hinting font.Hinting
+	buf     sfnt.Buffer
+	rast    vector.Rasterizer
+
+	tablesOnce sync.Once
+	tables     *fontTables
+}
+
+// faceFont returns the parsed font and point size behind a font.Face created by FontNewFace.
+// The boolean result is false for faces that were created some other way.
+func faceFont(face font.Face) (*opentype.Font, float64, bool) {
+	if f, ok := face.(*fontFace); ok {
+		return f.font, f.points, true
+	}
+
+	return nil, 0, false
 }
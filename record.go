@@ -0,0 +1,419 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"reflect"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+)
+
+// recordKind identifies the kind of a recorded drawing operation.
+type recordKind int
+
+const (
+	recordFill   recordKind = iota // The path is filled with the pattern.
+	recordStroke                   // The path is stroked with the pattern.
+	recordImage                    // The image is drawn with the matrix.
+	recordText                     // The text is drawn with the face, color and matrix.
+)
+
+// recordClip is a node in the chain of clipping regions of a recording. A node either holds a clip path
+// or a snapshot of a raster mask, and the effective clip is the intersection of a node and all its parents.
+type recordClip struct {
+	parent   *recordClip
+	path     Path
+	fillRule FillRule
+	mask     *image.Alpha
+}
+
+// recordOp is a single drawing operation captured by a recorder, together with the part of the
+// context state that is needed to replay it.
+type recordOp struct {
+	kind       recordKind
+	clip       *recordClip
+	operator   CompositeOperator
+	alpha      float64
+	path       Path
+	pattern    Pattern
+	fillRule   FillRule
+	lineWidth  float64
+	lineCap    LineCap
+	lineJoin   LineJoin
+	miterLimit float64
+	dashes     []float64
+	dashOffset float64
+	matrix     Matrix
+	image      image.Image
+	text       string
+	face       font.Face
+	color      color.Color
+	x, y       float64
+}
+
+// recordPage is a single page of a recording.
+type recordPage struct {
+	width, height float64
+	ops           []recordOp
+}
+
+// recorder collects the drawing operations issued on a context while recording is active. Operations are
+// added to the current page until ShowPage finishes it.
+type recorder struct {
+	width, height float64
+	pages         []*recordPage
+	page          *recordPage
+	stopped       bool
+	images        map[image.Image]image.Image // The last snapshot taken of each image drawn.
+}
+
+// newRecorder creates a recorder whose pages have the given size.
+func newRecorder(width, height float64) *recorder {
+	return &recorder{
+		width:  width,
+		height: height,
+		page:   &recordPage{width: width, height: height},
+	}
+}
+
+// allPages returns the finished pages followed by the current page, unless the current page is empty and
+// at least one page was already finished.
+func (r *recorder) allPages() []*recordPage {
+	if len(r.page.ops) == 0 && len(r.pages) > 0 {
+		return r.pages
+	}
+
+	return append(r.pages[:len(r.pages):len(r.pages)], r.page)
+}
+
+// lastPage returns the current page, or the last finished page if nothing was drawn since.
+func (r *recorder) lastPage() *recordPage {
+	pages := r.allPages()
+
+	return pages[len(pages)-1]
+}
+
+// StartRecording starts recording the drawing operations issued on the context.
+//
+// While recording, paths, fills, strokes, clips, text and images are captured alongside the raster output, so
+// that the same drawing can later be written out by a vector backend such as EncodeSVG. Any previous recording
+// is discarded. Raster masks set with SetMask or InvertMask are recorded as image masks. Images are recorded as
+// copies, so an image can be changed and drawn again without affecting what was recorded before.
+func (dc *Context) StartRecording() {
+	dc.recorder = newRecorder(float64(dc.width), float64(dc.height))
+	dc.clipChain = nil
+}
+
+// StopRecording stops recording the drawing operations issued on the context.
+//
+// The operations recorded so far are kept and can still be written out by a vector backend.
+func (dc *Context) StopRecording() {
+	if dc.recorder != nil {
+		dc.recorder.stopped = true
+	}
+}
+
+// SetPageSize sets the size of the pages recorded on the context, in points.
+//
+// One unit of the context's coordinate space is one point on the page, and the top-left corner of the
+// context is the top-left corner of the page. By default pages are as large as the context. The size
+// applies to the current page and to all the pages started after it. It has no effect unless recording.
+func (dc *Context) SetPageSize(width, height float64) {
+	if dc.recorder == nil {
+		return
+	}
+
+	dc.recorder.width = width
+	dc.recorder.height = height
+	dc.recorder.page.width = width
+	dc.recorder.page.height = height
+}
+
+// ShowPage finishes the current page of the recording and starts a new, empty one.
+//
+// Like a printer ejecting a sheet, it also clears the context's image to transparent so that the next page
+// can be drawn from scratch. The rest of the drawing state, including the clip, is left unchanged.
+func (dc *Context) ShowPage() {
+	draw.Draw(dc.im, dc.im.Bounds(), image.Transparent, image.Point{}, draw.Src)
+
+	if !dc.recording() {
+		return
+	}
+
+	r := dc.recorder
+	r.pages = append(r.pages, r.page)
+	r.page = &recordPage{width: r.width, height: r.height}
+}
+
+// PageCount returns the number of pages in the recording, including the current page if anything
+// was drawn on it.
+func (dc *Context) PageCount() int {
+	if dc.recorder == nil {
+		return 0
+	}
+
+	n := len(dc.recorder.pages)
+	if len(dc.recorder.page.ops) > 0 {
+		n++
+	}
+
+	return n
+}
+
+// recording reports whether drawing operations are currently being recorded.
+func (dc *Context) recording() bool {
+	return dc.recorder != nil && !dc.recorder.stopped
+}
+
+// record appends an operation to the recording, filling in the clip chain, the composite operator and the global alpha.
+func (dc *Context) record(op recordOp) {
+	op.clip = dc.clipChain
+	op.operator = dc.compositeOp
+	op.alpha = dc.globalAlpha
+	dc.recorder.page.ops = append(dc.recorder.page.ops, op)
+}
+
+// recordPath records a fill or stroke of the current path with the given pattern.
+func (dc *Context) recordPath(kind recordKind, pattern Pattern) {
+	if !dc.recording() || len(dc.path) == 0 {
+		return
+	}
+
+	dc.record(recordOp{
+		kind:       kind,
+		path:       append(Path(nil), dc.path...),
+		pattern:    pattern,
+		fillRule:   dc.fillRule,
+		lineWidth:  dc.lineWidth,
+		lineCap:    dc.lineCap,
+		lineJoin:   dc.lineJoin,
+		miterLimit: dc.miterLimit,
+		dashes:     append([]float64(nil), dc.dashes...),
+		dashOffset: dc.dashOffset,
+	})
+}
+
+// recordClipPath intersects the recorded clip with the current path.
+func (dc *Context) recordClipPath() {
+	if !dc.recording() {
+		return
+	}
+
+	dc.clipChain = &recordClip{
+		parent:   dc.clipChain,
+		path:     append(Path(nil), dc.path...),
+		fillRule: dc.fillRule,
+	}
+}
+
+// recordMask replaces the recorded clip with a snapshot of the current raster mask.
+func (dc *Context) recordMask() {
+	if !dc.recording() {
+		return
+	}
+
+	if dc.mask == nil {
+		dc.clipChain = nil
+		return
+	}
+
+	mask := image.NewAlpha(dc.mask.Bounds())
+	copy(mask.Pix, dc.mask.Pix)
+	dc.clipChain = &recordClip{mask: mask}
+}
+
+// recordClear records a Clear, which replaces everything drawn so far.
+func (dc *Context) recordClear(c color.Color) {
+	if !dc.recording() {
+		return
+	}
+
+	w, h := float64(dc.width), float64(dc.height)
+	page := dc.recorder.page
+	page.ops = append(page.ops[:0], recordOp{
+		kind: recordFill,
+		path: Path{
+			{Op: PathMoveTo, Points: [3]Point{{0, 0}}},
+			{Op: PathLineTo, Points: [3]Point{{w, 0}}},
+			{Op: PathLineTo, Points: [3]Point{{w, h}}},
+			{Op: PathLineTo, Points: [3]Point{{0, h}}},
+			{Op: PathClose},
+		},
+		pattern: NewSolidPattern(c),
+		alpha:   1,
+	})
+}
+
+// recordPixel records a SetPixel as a one pixel square.
+func (dc *Context) recordPixel(x, y int, c color.Color) {
+	if !dc.recording() {
+		return
+	}
+
+	fx, fy := float64(x), float64(y)
+	dc.recorder.page.ops = append(dc.recorder.page.ops, recordOp{
+		kind: recordFill,
+		path: Path{
+			{Op: PathMoveTo, Points: [3]Point{{fx, fy}}},
+			{Op: PathLineTo, Points: [3]Point{{fx + 1, fy}}},
+			{Op: PathLineTo, Points: [3]Point{{fx + 1, fy + 1}}},
+			{Op: PathLineTo, Points: [3]Point{{fx, fy + 1}}},
+			{Op: PathClose},
+		},
+		pattern: NewSolidPattern(c),
+		alpha:   1,
+	})
+}
+
+// recordImage records a snapshot of an image drawn with the given matrix, so that later changes to the image
+// don't rewrite what was already drawn.
+func (dc *Context) recordImage(im image.Image, m Matrix) {
+	if !dc.recording() {
+		return
+	}
+
+	dc.record(recordOp{kind: recordImage, image: dc.recorder.snapshot(im), matrix: m})
+}
+
+// snapshot returns a copy of an image. An image drawn again unchanged gets its previous copy, so that vector
+// backends can still share it.
+func (r *recorder) snapshot(im image.Image) image.Image {
+	c := cloneImage(im)
+	if !reflect.TypeOf(im).Comparable() {
+		return c
+	}
+
+	if prev, ok := r.images[im]; ok && samePixels(prev, c) {
+		return prev
+	}
+
+	if r.images == nil {
+		r.images = make(map[image.Image]image.Image)
+	}
+	r.images[im] = c
+
+	return c
+}
+
+// cloneImage copies an image. The common image types keep their type, so that backends can still encode them
+// compactly, and any other image is copied to an *image.NRGBA.
+func cloneImage(im image.Image) image.Image {
+	b := im.Bounds()
+	switch src := im.(type) {
+	case *image.Gray:
+		dst := image.NewGray(b)
+		draw.Copy(dst, b.Min, src, b, draw.Src, nil)
+		return dst
+	case *image.Alpha:
+		dst := image.NewAlpha(b)
+		draw.Copy(dst, b.Min, src, b, draw.Src, nil)
+		return dst
+	case *image.RGBA:
+		dst := image.NewRGBA(b)
+		draw.Copy(dst, b.Min, src, b, draw.Src, nil)
+		return dst
+	case *image.Paletted:
+		dst := image.NewPaletted(b, append(color.Palette(nil), src.Palette...))
+		draw.Copy(dst, b.Min, src, b, draw.Src, nil)
+		return dst
+	}
+
+	dst := image.NewNRGBA(b)
+	draw.Copy(dst, b.Min, im, b, draw.Src, nil)
+
+	return dst
+}
+
+// samePixels reports whether two images made by cloneImage are identical.
+func samePixels(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() || reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *image.Gray:
+		return bytes.Equal(a.Pix, b.(*image.Gray).Pix)
+	case *image.Alpha:
+		return bytes.Equal(a.Pix, b.(*image.Alpha).Pix)
+	case *image.RGBA:
+		return bytes.Equal(a.Pix, b.(*image.RGBA).Pix)
+	case *image.Paletted:
+		p := b.(*image.Paletted)
+		return bytes.Equal(a.Pix, p.Pix) && reflect.DeepEqual(a.Palette, p.Palette)
+	case *image.NRGBA:
+		return bytes.Equal(a.Pix, b.(*image.NRGBA).Pix)
+	}
+
+	return false
+}
+
+// recordText records a string drawn with its baseline origin at (x, y) in user space. The text of a font family is
+// recorded as one string per face.
+func (dc *Context) recordText(s string, x, y float64) {
+	if !dc.recording() {
+		return
+	}
+
+	for _, run := range faceRuns(dc.fontFace, s) {
+		dc.record(recordOp{
+			kind:   recordText,
+			text:   run.text,
+			face:   run.face,
+			color:  dc.color,
+			matrix: dc.matrix,
+			x:      x + run.offset,
+			y:      y,
+		})
+	}
+}
+
+// recordWords records a line of shaped glyphs, drawn from s with their origin at (x, y), word by word, at the position
+// of the glyphs of each word, for lines whose words were moved apart by justification.
+func (dc *Context) recordWords(s string, glyphs []shapedGlyph, x, y float64) {
+	if !dc.recording() {
+		return
+	}
+
+	var word glyphCluster
+	inWord := false
+	for _, c := range glyphClusters(s, shapedString{glyphs: glyphs}) {
+		if strings.TrimSpace(s[c.start:c.end]) == "" {
+			if inWord {
+				dc.recordText(s[word.start:word.end], x+word.x, y)
+				inWord = false
+			}
+			continue
+		}
+		if !inWord {
+			word, inWord = c, true
+			continue
+		}
+		word.start, word.end = min(word.start, c.start), max(word.end, c.end)
+	}
+	if inWord {
+		dc.recordText(s[word.start:word.end], x+word.x, y)
+	}
+}
+
+// recordGroup records a group painted with an opacity and a composite operator as an image covering the context.
+func (dc *Context) recordGroup(layer *image.RGBA, opacity float64, op CompositeOperator) {
+	if !dc.recording() {
+		return
+	}
+
+	dc.recorder.page.ops = append(dc.recorder.page.ops, recordOp{
+		kind:     recordImage,
+		clip:     dc.clipChain,
+		operator: op,
+		alpha:    opacity,
+		image:    layer,
+		matrix:   Identity(),
+	})
+}
@@ -0,0 +1,388 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"image/color"
+	"math"
+	"strings"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+)
+
+// TextSpan is a run of text with its own style, one of the parts of a rich text.
+type TextSpan struct {
+	Text string
+
+	// Face is the font face of the span. If it is nil, the context's font face is used.
+	Face font.Face
+	// Font and Size, if both are set, create the font face of the span instead of Face.
+	Font *opentype.Font
+	Size float64
+
+	// Color is the color of the span. If it is nil, the context's color is used.
+	Color color.Color
+	// Pattern, if it is not nil, fills the glyph outlines instead of Color. Faces without outlines use Color.
+	Pattern Pattern
+
+	// LetterSpacing is added after every character, in pixels.
+	LetterSpacing float64
+	// BaselineShift raises the span above the baseline, or lowers it below when negative, in pixels.
+	BaselineShift float64
+
+	Underline     bool
+	Strikethrough bool
+}
+
+// RichTextRun is a part of a span placed on a line of a rich text layout.
+type RichTextRun struct {
+	Span  int     // The index of the span the run belongs to.
+	Text  string  // The text of the run.
+	X, Y  float64 // The origin of the run on its baseline, including the baseline shift.
+	Width float64 // The advance width of the run.
+}
+
+// RichTextLine is a line of a rich text layout.
+type RichTextLine struct {
+	Runs    []RichTextRun
+	X, Y    float64 // The left end of the line on its baseline.
+	Width   float64 // The advance width of the line.
+	Ascent  float64 // The height of the line above its baseline.
+	Descent float64 // The depth of the line below its baseline.
+	Height  float64 // The height of the line box, including the line spacing.
+}
+
+// RichTextLayout is the result of laying out a rich text. Positions are relative to the top-left corner of the layout.
+type RichTextLayout struct {
+	Lines  []RichTextLine
+	Width  float64 // The width of the layout: the wrapping width, or the width of the widest line without wrapping.
+	Height float64 // The total height of the line boxes.
+
+	spans []TextSpan
+	faces []font.Face
+}
+
+// richTextPiece is the part of a span between two line break opportunities or span boundaries, the unit of rich text
+// wrapping. Its text keeps its trailing spaces and soft hyphens, see lineText.
+type richTextPiece struct {
+	span       int
+	text       string
+	width      float64
+	breakAfter bool // Whether a line can break after the piece.
+	br         bool // Whether a line must break after the piece.
+}
+
+// LayoutRichText lays out spans of rich text into lines no wider than width, breaking lines like WordWrap, at the line
+// break opportunities of the Unicode line breaking algorithm, even where they fall between spans. A width of zero or less
+// only breaks lines at line breaks.
+//
+// Each line box is as tall as the tallest ascent and descent of its spans, baseline shifts included, multiplied by
+// lineSpacing, and its line is centered in it. Lines are aligned with align within the width of the layout.
+func (dc *Context) LayoutRichText(spans []TextSpan, width, lineSpacing float64, align Align) *RichTextLayout {
+	layout := &RichTextLayout{spans: spans, faces: make([]font.Face, len(spans))}
+	for i, s := range spans {
+		layout.faces[i] = dc.spanFace(s)
+	}
+
+	// split the text of all the spans at its line break opportunities, and the segments at the span boundaries
+	var text strings.Builder
+	ends := make([]int, len(spans))
+	for i, s := range spans {
+		text.WriteString(s.Text)
+		ends[i] = text.Len()
+	}
+	var pieces []richTextPiece
+	start, span := 0, 0
+	for _, segment := range lineSegments(text.String()) {
+		end := start + len(segment.text)
+		for start < end {
+			for ends[span] <= start {
+				span++
+			}
+			cut := min(end, ends[span])
+			p := richTextPiece{span: span, text: text.String()[start:cut], breakAfter: cut == end}
+			if p.breakAfter && segment.mandatory {
+				p.br = true
+				p.text = strings.TrimRight(p.text, "\r\n")
+			}
+			p.width = measureRun(layout.faces[span], richText(p.text), spans[span].LetterSpacing)
+			pieces = append(pieces, p)
+			start = cut
+		}
+	}
+
+	// wrap the pieces greedily, segment by segment; a segment can span several spans
+	var lines [][]richTextPiece
+	var current []richTextPiece
+	lineWidth := 0.0
+	flush := func() {
+		lines = append(lines, trimSpacePieces(current))
+		current, lineWidth = nil, 0
+	}
+	for i := 0; i < len(pieces); {
+		k := i
+		for k < len(pieces)-1 && !pieces[k].breakAfter {
+			k++
+		}
+		unit := pieces[i : k+1]
+		w := 0.0
+		for _, p := range unit {
+			w += p.width
+		}
+		// the unit has to fit as it would end a line: without its trailing spaces, and with the hyphen of a soft hyphen
+		last := unit[len(unit)-1]
+		end := strings.TrimRightFunc(last.text, unicode.IsSpace)
+		if strings.HasSuffix(end, string(softHyphen)) {
+			end += "-"
+		}
+		fit := w - last.width + measureRun(layout.faces[last.span], richText(end), spans[last.span].LetterSpacing)
+
+		if width > 0 && lineWidth+fit > width && len(trimSpacePieces(current)) > 0 {
+			flush()
+		}
+		current = append(current, unit...)
+		lineWidth += w
+		if last.br {
+			flush()
+		}
+		i = k + 1
+	}
+	if len(current) > 0 || len(lines) == 0 {
+		if len(current) == 0 && len(spans) > 0 {
+			current = append(current, richTextPiece{span: len(spans) - 1})
+		}
+		flush()
+	}
+
+	// place the runs of every line
+	y := 0.0
+	for _, pieces := range lines {
+		line := RichTextLine{}
+		for _, p := range pieces {
+			if len(spans) == 0 {
+				break
+			}
+			m := layout.faces[p.span].Metrics()
+			shift := spans[p.span].BaselineShift
+			line.Ascent = math.Max(line.Ascent, unfix(m.Ascent)+shift)
+			line.Descent = math.Max(line.Descent, unfix(m.Descent)-shift)
+			if p.text == "" {
+				continue
+			}
+
+			if n := len(line.Runs); n > 0 && line.Runs[n-1].Span == p.span {
+				line.Runs[n-1].Text += p.text
+			} else {
+				line.Runs = append(line.Runs, RichTextRun{Span: p.span, Text: p.text, Y: -shift})
+			}
+		}
+		for i := range line.Runs {
+			line.Runs[i].Text = richText(line.Runs[i].Text)
+		}
+		if n := len(pieces); n > 0 && strings.HasSuffix(pieces[n-1].text, string(softHyphen)) {
+			line.Runs[len(line.Runs)-1].Text += "-"
+		}
+		for i := range line.Runs {
+			r := &line.Runs[i]
+			r.X = line.Width
+			r.Width = measureRun(layout.faces[r.Span], r.Text, spans[r.Span].LetterSpacing)
+			line.Width += r.Width
+		}
+
+		line.Height = (line.Ascent + line.Descent) * lineSpacing
+		line.Y = y + (line.Height-line.Ascent-line.Descent)/2 + line.Ascent
+		y += line.Height
+		layout.Lines = append(layout.Lines, line)
+		layout.Width = math.Max(layout.Width, line.Width)
+	}
+	layout.Height = y
+	if width > 0 {
+		layout.Width = width
+	}
+
+	for i := range layout.Lines {
+		line := &layout.Lines[i]
+		switch align {
+		case AlignCenter:
+			line.X = (layout.Width - line.Width) / 2
+		case AlignRight:
+			line.X = layout.Width - line.Width
+		}
+		for j := range line.Runs {
+			line.Runs[j].X += line.X
+			line.Runs[j].Y += line.Y
+		}
+	}
+
+	return layout
+}
+
+// MeasureRichText returns the size of spans of rich text laid out with LayoutRichText.
+func (dc *Context) MeasureRichText(spans []TextSpan, width, lineSpacing float64) (w, h float64) {
+	layout := dc.LayoutRichText(spans, width, lineSpacing, AlignLeft)
+
+	return layout.Width, layout.Height
+}
+
+// DrawRichText lays out spans of rich text with LayoutRichText and draws them.
+//
+// Like DrawStringWrapped, the layout is anchored at (x, y) by ax and ay, relative to its width and height.
+func (dc *Context) DrawRichText(spans []TextSpan, x, y, ax, ay, width, lineSpacing float64, align Align) {
+	layout := dc.LayoutRichText(spans, width, lineSpacing, align)
+	dc.DrawRichTextLayout(layout, x-ax*layout.Width, y-ay*layout.Height)
+}
+
+// DrawRichTextLayout draws a rich text layout with its top-left corner at (x, y).
+func (dc *Context) DrawRichTextLayout(layout *RichTextLayout, x, y float64) {
+	for _, line := range layout.Lines {
+		for _, r := range line.Runs {
+			s := layout.spans[r.Span]
+			face := layout.faces[r.Span]
+
+			dc.Push()
+			dc.SetFontFace(face)
+			if s.Color != nil {
+				dc.SetColor(s.Color)
+			}
+			dc.drawRun(r.Text, x+r.X, y+r.Y, s.LetterSpacing, s.Pattern)
+
+			m := face.Metrics()
+			thickness := math.Max(1, unfix(m.Ascent+m.Descent)/16)
+			if s.Underline {
+				dc.decorate(x+r.X, y+r.Y+unfix(m.Descent)/4, r.Width, thickness, s.Pattern)
+			}
+			if s.Strikethrough {
+				xHeight := unfix(m.XHeight)
+				if xHeight <= 0 {
+					xHeight = unfix(m.Ascent) / 2
+				}
+				dc.decorate(x+r.X, y+r.Y-xHeight/2, r.Width, thickness, s.Pattern)
+			}
+			dc.Pop()
+		}
+	}
+}
+
+// spanFace returns the font face of a span.
+func (dc *Context) spanFace(s TextSpan) font.Face {
+	if s.Font != nil && s.Size > 0 {
+		if face, err := FontNewFace(s.Font, s.Size); err == nil {
+			return face
+		}
+	}
+	if s.Face != nil {
+		return s.Face
+	}
+
+	return dc.fontFace
+}
+
+// measureRun returns the advance width of a string drawn with a face and a letter spacing.
+func measureRun(face font.Face, s string, spacing float64) float64 {
+	if spacing == 0 {
+		return unfix(shapeString(face, s).advance)
+	}
+
+	w := 0.0
+	prev := rune(-1)
+	for _, r := range s {
+		if prev >= 0 {
+			w += unfix(face.Kern(prev, r))
+		}
+		if advance, ok := face.GlyphAdvance(r); ok {
+			w += unfix(advance)
+		}
+		w += spacing
+		prev = r
+	}
+
+	return w
+}
+
+// drawRun draws a string with the current font face, character by character if there is a letter spacing. If p is
+// not nil and the face has outlines, the outlines are filled with p.
+func (dc *Context) drawRun(s string, x, y, spacing float64, p Pattern) {
+	outlines := hasOutlines(dc.fontFace)
+	draw := func(s string, x, y float64) {
+		if p == nil || !outlines {
+			dc.DrawString(s, x, y)
+			return
+		}
+		dc.fillWithoutPath(p, func() {
+			dc.TextPath(s, x, y)
+		})
+	}
+
+	if spacing == 0 {
+		draw(s, x, y)
+		return
+	}
+
+	prev := rune(-1)
+	for _, r := range s {
+		if prev >= 0 {
+			x += unfix(dc.fontFace.Kern(prev, r))
+		}
+		draw(string(r), x, y)
+		if advance, ok := dc.fontFace.GlyphAdvance(r); ok {
+			x += unfix(advance)
+		}
+		x += spacing
+		prev = r
+	}
+}
+
+// decorate draws an underline or a strikethrough line centered on y, with the pattern p or the current color.
+func (dc *Context) decorate(x, y, width, thickness float64, p Pattern) {
+	if p == nil {
+		p = NewSolidPattern(dc.color)
+	}
+	dc.fillWithoutPath(p, func() {
+		dc.DrawRectangle(x, y-thickness/2, width, thickness)
+	})
+}
+
+// fillWithoutPath fills the path built by the path function with a pattern, leaving the current path untouched.
+func (dc *Context) fillWithoutPath(p Pattern, path func()) {
+	strokePath, fillPath, current := dc.strokePath, dc.fillPath, dc.path
+	start, point, hasCurrent := dc.start, dc.current, dc.hasCurrent
+	fillPattern := dc.fillPattern
+	dc.strokePath, dc.fillPath, dc.path, dc.hasCurrent = nil, nil, nil, false
+
+	path()
+	dc.fillPattern = p
+	dc.Fill()
+
+	dc.fillPattern = fillPattern
+	dc.strokePath, dc.fillPath, dc.path = strokePath, fillPath, current
+	dc.start, dc.current, dc.hasCurrent = start, point, hasCurrent
+}
+
+// trimSpacePieces removes the spaces at the end of a line of pieces.
+func trimSpacePieces(pieces []richTextPiece) []richTextPiece {
+	pieces = append([]richTextPiece(nil), pieces...)
+	for len(pieces) > 0 {
+		p := &pieces[len(pieces)-1]
+		p.text = strings.TrimRightFunc(p.text, unicode.IsSpace)
+		if p.text != "" || len(pieces) == 1 {
+			break
+		}
+		pieces = pieces[:len(pieces)-1]
+	}
+
+	return pieces
+}
+
+// richText removes the zero width spaces and soft hyphens from a part of a line of rich text.
+func richText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == softHyphen || r == zeroWidthSpace {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,54 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// SetShadow sets a drop shadow that is drawn under every Fill, Stroke, DrawString and DrawImage.
+//
+// Like the shadows of the HTML canvas, the shadow is the shape of what is drawn, painted with the color c, blurred by
+// blurRadius and moved by (offsetX, offsetY) pixels. The offset is not affected by the current transformation. A fully
+// transparent color disables the shadow, which is the default. Shadows are saved and restored by Push and Pop, and are
+// not part of vector output.
+func (dc *Context) SetShadow(offsetX, offsetY, blurRadius float64, c color.Color) {
+	dc.shadowX = offsetX
+	dc.shadowY = offsetY
+	dc.shadowBlur = math.Max(0, blurRadius)
+	dc.shadowColor = c
+}
+
+// hasShadow reports whether drawing operations cast a visible shadow.
+func (dc *Context) hasShadow() bool {
+	if dc.shadowColor == nil {
+		return false
+	}
+	if _, _, _, a := dc.shadowColor.RGBA(); a == 0 {
+		return false
+	}
+
+	return dc.shadowX != 0 || dc.shadowY != 0 || dc.shadowBlur > 0
+}
+
+// shadow returns the shadow cast by a layer: its alpha channel blurred, moved by the shadow offset and painted with
+// the shadow color.
+func (dc *Context) shadow(layer *image.RGBA) *image.RGBA {
+	b := layer.Bounds()
+	mask := image.NewAlpha(b)
+	for i := range mask.Pix {
+		mask.Pix[i] = layer.Pix[i*4+3]
+	}
+	mask = BlurAlpha(mask, dc.shadowBlur)
+
+	offset := image.Pt(int(math.Round(dc.shadowX)), int(math.Round(dc.shadowY)))
+	result := image.NewRGBA(b)
+	draw.DrawMask(result, b.Add(offset), image.NewUniform(dc.shadowColor), image.Point{}, mask, b.Min, draw.Src)
+
+	return result
+}
@@ -0,0 +1,737 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"image"
+	"image/draw"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// shapedGlyph is a glyph of a shaped string.
+type shapedGlyph struct {
+	face       font.Face
+	r          rune            // the rune the glyph is drawn for, or -1 for glyphs that are drawn by index
+	gid        sfnt.GlyphIndex // the glyph index, for faces created by FontNewFace
+	start, end int             // the bytes of the string the glyph comes from
+	dot        fixed.Point26_6 // the origin of the glyph, relative to the origin of the string
+}
+
+// shapedString is a string turned into positioned glyphs, from left to right.
+type shapedString struct {
+	glyphs  []shapedGlyph
+	advance fixed.Int26_6
+}
+
+// shapeString shapes a line of text drawn with a face.
+//
+// The runes are put in display order with the Unicode Bidirectional Algorithm, and every run of a single direction,
+// face and script is shaped on its own. Faces created by FontNewFace are shaped with the GSUB and GPOS tables of their
+// font, which form ligatures, select the contextual forms of scripts like Arabic and Devanagari, kern and place marks.
+// Runes of other faces are drawn one by one, like font.Drawer does.
+func shapeString(face font.Face, s string) shapedString {
+	return shapeText(face, s, false)
+}
+
+// shapeUpright shapes a run of upright glyphs of vertical text, placed down from the top of the run on the center line
+// of the column, with the vertical forms of the font.
+func shapeUpright(face font.Face, s string) shapedString {
+	return shapeText(face, s, true)
+}
+
+// shapeText shapes a string horizontally, see shapeString, or vertically, see shapeUpright. Vertical runs have a
+// single direction.
+func shapeText(face font.Face, s string, vertical bool) shapedString {
+	runes := make([]rune, 0, len(s))
+	offsets := make([]int, 0, len(s)+1)
+	simple := true
+	for i, r := range s {
+		runes = append(runes, r)
+		offsets = append(offsets, i)
+		if r >= 0x0590 {
+			simple = false
+		}
+	}
+	offsets = append(offsets, len(s))
+
+	var levels []int8
+	if simple || vertical {
+		levels = make([]int8, len(runes))
+	} else {
+		levels, _ = bidiLevels(runes)
+	}
+
+	items := shapeItems(face, runes, levels)
+	itemLevels := make([]int8, len(items))
+	for i := range items {
+		items[i].vertical = vertical
+		itemLevels[i] = items[i].level
+	}
+
+	var shaped shapedString
+	for _, i := range visualOrder(itemLevels) {
+		it := items[i]
+		glyphs, advance := it.shape(runes[it.start:it.end], offsets[it.start:it.end+1])
+		for _, g := range glyphs {
+			if vertical {
+				g.dot.Y += shaped.advance
+			} else {
+				g.dot.X += shaped.advance
+			}
+			shaped.glyphs = append(shaped.glyphs, g)
+		}
+		shaped.advance += advance
+	}
+
+	return shaped
+}
+
+// shapeItem is a run of runes with a single embedding level, face and script.
+type shapeItem struct {
+	face       font.Face
+	level      int8
+	script     string
+	start, end int
+	vertical   bool
+}
+
+// shapeItems splits runes into the runs that are shaped on their own.
+func shapeItems(face font.Face, runes []rune, levels []int8) []shapeItem {
+	var items []shapeItem
+	for i, r := range runes {
+		f := leafFace(face, r)
+		script := ""
+		if _, ok := f.(*fontFace); ok {
+			script = runeScript(r)
+		}
+
+		if n := len(items); n > 0 {
+			last := &items[n-1]
+			if last.face == f && last.level == levels[i] && (script == "" || last.script == "" || script == last.script) {
+				if last.script == "" {
+					last.script = script
+				}
+				last.end = i + 1
+				continue
+			}
+		}
+		items = append(items, shapeItem{face: f, level: levels[i], script: script, start: i, end: i + 1})
+	}
+
+	return items
+}
+
+// leafFace returns the face that draws a rune, looking into font families.
+func leafFace(face font.Face, r rune) font.Face {
+	for {
+		f, ok := face.(*fontFamily)
+		if !ok {
+			return face
+		}
+		face = f.face(r)
+	}
+}
+
+// shape shapes the runes of the item, whose byte offsets are given with the end of the last rune, and returns the
+// glyphs from left to right, or from top to bottom for vertical items, and their advance.
+func (it shapeItem) shape(runes []rune, offsets []int) ([]shapedGlyph, fixed.Int26_6) {
+	rtl := it.level%2 == 1
+	if f, ok := it.face.(*fontFace); ok {
+		if layout := f.layout(); layout != nil {
+			return f.shape(layout, it.script, rtl, it.vertical, runes, offsets)
+		}
+	}
+
+	var glyphs []shapedGlyph
+	if it.vertical {
+		// without vertical metrics, every glyph takes the height of the face and is centered on the column
+		m := it.face.Metrics()
+		y := fixed.Int26_6(0)
+		for i, r := range runes {
+			if isDefaultIgnorable(r) {
+				continue
+			}
+			advance, _ := it.face.GlyphAdvance(r)
+			dot := fixed.Point26_6{X: -advance / 2, Y: y + m.Ascent}
+			glyphs = append(glyphs, shapedGlyph{face: it.face, r: r, start: offsets[i], end: offsets[i+1], dot: dot})
+			y += m.Ascent + m.Descent
+		}
+		return glyphs, y
+	}
+
+	x := fixed.Int26_6(0)
+	prev := rune(-1)
+	for k := range runes {
+		i := k
+		r := runes[i]
+		if rtl {
+			i = len(runes) - 1 - k
+			r = bidiMirror(runes[i])
+		}
+		if isDefaultIgnorable(r) {
+			continue
+		}
+		// based on MeasureString() in golang.org/x/image/font/font.go
+		if prev >= 0 {
+			x += it.face.Kern(prev, r)
+		}
+		advance, _ := it.face.GlyphAdvance(r)
+		glyphs = append(glyphs, shapedGlyph{face: it.face, r: r, start: offsets[i], end: offsets[i+1], dot: fixed.Point26_6{X: x}})
+		x += advance
+		prev = r
+	}
+
+	return glyphs, x
+}
+
+// The masks of the glyphs that select the features applied to them.
+const (
+	maskGlobal = 1 << iota
+	maskIsol
+	maskFina
+	maskMedi
+	maskInit
+	maskReph
+	maskHalf
+	maskPost
+)
+
+// The shapers of the scripts that need more than the common features.
+const (
+	shaperDefault = iota
+	shaperArabic
+	shaperIndic
+)
+
+// otScript is the OpenType description of a script: its tags, from the preferred one, and its shaper.
+type otScript struct {
+	tags   []otTag
+	shaper int
+}
+
+// otScripts maps the scripts of runeScript to their OpenType descriptions.
+var otScripts = map[string]otScript{
+	"arab": {[]otTag{makeTag("arab")}, shaperArabic},
+	"syrc": {[]otTag{makeTag("syrc")}, shaperArabic},
+	"deva": {[]otTag{makeTag("dev2"), makeTag("deva")}, shaperIndic},
+	"beng": {[]otTag{makeTag("bng2"), makeTag("beng")}, shaperDefault},
+	"guru": {[]otTag{makeTag("gur2"), makeTag("guru")}, shaperDefault},
+	"gujr": {[]otTag{makeTag("gjr2"), makeTag("gujr")}, shaperDefault},
+	"taml": {[]otTag{makeTag("tml2"), makeTag("taml")}, shaperDefault},
+	"telu": {[]otTag{makeTag("tel2"), makeTag("telu")}, shaperDefault},
+	"knda": {[]otTag{makeTag("knd2"), makeTag("knda")}, shaperDefault},
+	"mlym": {[]otTag{makeTag("mlm2"), makeTag("mlym")}, shaperDefault},
+	"hebr": {[]otTag{makeTag("hebr")}, shaperDefault},
+	"thai": {[]otTag{makeTag("thai")}, shaperDefault},
+	"lao":  {[]otTag{makeTag("lao ")}, shaperDefault},
+	"latn": {[]otTag{makeTag("latn")}, shaperDefault},
+	"cyrl": {[]otTag{makeTag("cyrl")}, shaperDefault},
+	"grek": {[]otTag{makeTag("grek")}, shaperDefault},
+	"armn": {[]otTag{makeTag("armn")}, shaperDefault},
+	"geor": {[]otTag{makeTag("geor")}, shaperDefault},
+	"hani": {[]otTag{makeTag("hani")}, shaperDefault},
+	"kana": {[]otTag{makeTag("kana")}, shaperDefault},
+	"hang": {[]otTag{makeTag("hang")}, shaperDefault},
+}
+
+// unicodeScripts lists the Unicode scripts of runeScript.
+var unicodeScripts = []struct {
+	table *unicode.RangeTable
+	name  string
+}{
+	{unicode.Latin, "latn"},
+	{unicode.Arabic, "arab"},
+	{unicode.Hebrew, "hebr"},
+	{unicode.Cyrillic, "cyrl"},
+	{unicode.Greek, "grek"},
+	{unicode.Devanagari, "deva"},
+	{unicode.Bengali, "beng"},
+	{unicode.Gurmukhi, "guru"},
+	{unicode.Gujarati, "gujr"},
+	{unicode.Tamil, "taml"},
+	{unicode.Telugu, "telu"},
+	{unicode.Kannada, "knda"},
+	{unicode.Malayalam, "mlym"},
+	{unicode.Thai, "thai"},
+	{unicode.Lao, "lao"},
+	{unicode.Syriac, "syrc"},
+	{unicode.Armenian, "armn"},
+	{unicode.Georgian, "geor"},
+	{unicode.Han, "hani"},
+	{unicode.Hiragana, "kana"},
+	{unicode.Katakana, "kana"},
+	{unicode.Hangul, "hang"},
+}
+
+// runeScript returns the script of a rune, or "" for runes that are common to scripts, like digits and punctuation,
+// or that are not listed.
+func runeScript(r rune) string {
+	if r < 0x80 {
+		if 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' {
+			return "latn"
+		}
+		return ""
+	}
+	for _, s := range unicodeScripts {
+		if unicode.Is(s.table, r) {
+			return s.name
+		}
+	}
+
+	return ""
+}
+
+// The GSUB features of the shapers, in stages: the lookups of a stage are applied together, in the order of the
+// lookup list, after the lookups of the previous stages.
+var (
+	commonFeatures = map[otTag]uint32{
+		makeTag("rlig"): maskGlobal, makeTag("calt"): maskGlobal, makeTag("clig"): maskGlobal,
+		makeTag("liga"): maskGlobal, makeTag("rclt"): maskGlobal,
+	}
+	defaultStages = []map[otTag]uint32{
+		{makeTag("rvrn"): maskGlobal},
+		{makeTag("ccmp"): maskGlobal, makeTag("locl"): maskGlobal, makeTag("rlig"): maskGlobal,
+			makeTag("calt"): maskGlobal, makeTag("clig"): maskGlobal, makeTag("liga"): maskGlobal,
+			makeTag("rclt"): maskGlobal},
+	}
+	arabicStages = []map[otTag]uint32{
+		{makeTag("rvrn"): maskGlobal},
+		{makeTag("ccmp"): maskGlobal, makeTag("locl"): maskGlobal},
+		{makeTag("isol"): maskIsol},
+		{makeTag("fina"): maskFina},
+		{makeTag("medi"): maskMedi},
+		{makeTag("init"): maskInit},
+		{makeTag("rlig"): maskGlobal},
+		{makeTag("calt"): maskGlobal},
+		commonFeatures,
+	}
+	indicStages = []map[otTag]uint32{
+		{makeTag("rvrn"): maskGlobal},
+		{makeTag("locl"): maskGlobal, makeTag("ccmp"): maskGlobal},
+		{makeTag("nukt"): maskGlobal},
+		{makeTag("akhn"): maskGlobal},
+		{makeTag("rphf"): maskReph},
+		{makeTag("rkrf"): maskGlobal},
+		{makeTag("pref"): maskPost},
+		{makeTag("blwf"): maskHalf | maskPost},
+		{makeTag("abvf"): maskPost},
+		{makeTag("half"): maskHalf},
+		{makeTag("pstf"): maskPost},
+		{makeTag("vatu"): maskGlobal},
+		{makeTag("cjct"): maskGlobal},
+		{makeTag("pres"): maskGlobal, makeTag("abvs"): maskGlobal, makeTag("blws"): maskGlobal,
+			makeTag("psts"): maskGlobal, makeTag("haln"): maskGlobal, makeTag("rlig"): maskGlobal,
+			makeTag("calt"): maskGlobal, makeTag("clig"): maskGlobal, makeTag("liga"): maskGlobal,
+			makeTag("rclt"): maskGlobal},
+	}
+	positionFeatures = map[otTag]uint32{
+		makeTag("kern"): maskGlobal, makeTag("mark"): maskGlobal, makeTag("mkmk"): maskGlobal,
+		makeTag("dist"): maskGlobal, makeTag("abvm"): maskGlobal, makeTag("blwm"): maskGlobal,
+	}
+
+	// vertical text takes the vertical forms of its glyphs after the other substitutions, and isn't kerned
+	verticalStage            = map[otTag]uint32{makeTag("vert"): maskGlobal, makeTag("vrt2"): maskGlobal}
+	verticalPositionFeatures = map[otTag]uint32{makeTag("mark"): maskGlobal, makeTag("mkmk"): maskGlobal}
+)
+
+// shape shapes runes of a single script and direction with the layout tables of the font of the face, see
+// shapeItem.shape.
+func (f *fontFace) shape(layout *otLayout, script string, rtl, vertical bool, runes []rune, offsets []int) ([]shapedGlyph, fixed.Int26_6) {
+	desc, ok := otScripts[script]
+	if !ok {
+		desc = otScript{tags: nil, shaper: shaperDefault}
+	}
+	tags := append(append([]otTag(nil), desc.tags...), makeTag("DFLT"), makeTag("dflt"), makeTag("latn"))
+
+	glyphs := make([]otGlyph, len(runes))
+	for i, r := range runes {
+		if rtl {
+			if m := bidiMirror(r); m != r {
+				if gid, err := f.font.GlyphIndex(&f.buf, m); err == nil && gid != 0 {
+					r = m
+				}
+			}
+		}
+		gid, _ := f.font.GlyphIndex(&f.buf, r)
+		class := otClassBase
+		if unicode.Is(unicode.Mn, r) {
+			class = otClassMark
+		}
+		glyphs[i] = otGlyph{
+			gid:    uint16(gid),
+			r:      r,
+			start:  offsets[i],
+			end:    offsets[i+1],
+			mask:   maskGlobal,
+			class:  layout.glyphClass(uint16(gid), class),
+			attach: -1,
+		}
+	}
+
+	stages := defaultStages
+	switch desc.shaper {
+	case shaperArabic:
+		stages = arabicStages
+		arabicForms(glyphs)
+	case shaperIndic:
+		stages = indicStages
+		glyphs = indicSyllables(glyphs)
+	}
+	if vertical {
+		stages = append(stages[:len(stages):len(stages)], verticalStage)
+	}
+
+	s := &otShaper{layout: layout, glyphs: glyphs}
+	if s.table = layout.gsub; s.table != nil {
+		langSys := s.table.langSys(tags)
+		for _, stage := range stages {
+			s.applyLookups(s.table.stageLookups(langSys, stage))
+			if _, ok := stage[makeTag("rphf")]; ok {
+				for i := range s.glyphs {
+					g := &s.glyphs[i]
+					g.reph = g.mask&maskReph != 0 && g.r == -1
+				}
+			}
+		}
+	}
+	if desc.shaper == shaperIndic {
+		s.glyphs = placeReph(s.glyphs)
+	}
+
+	// the default ignorables were only needed for joining
+	visible := s.glyphs[:0]
+	for _, g := range s.glyphs {
+		if g.r < 0 || !isDefaultIgnorable(g.r) {
+			visible = append(visible, g)
+		}
+	}
+	s.glyphs = visible
+
+	kerned := false
+	if s.table = layout.gpos; s.table != nil {
+		langSys := s.table.langSys(tags)
+		features := positionFeatures
+		if vertical {
+			features = verticalPositionFeatures
+		}
+		lookups := s.table.stageLookups(langSys, features)
+		kerned = len(s.table.stageLookups(langSys, map[otTag]uint32{makeTag("kern"): maskGlobal})) > 0
+		s.applyLookups(lookups)
+	}
+
+	scale := func(v int32) fixed.Int26_6 {
+		return scaleUnits(fixed.Int26_6(v)*f.scale, f.font.UnitsPerEm())
+	}
+	if vertical {
+		return f.layoutVertical(s.glyphs, scale)
+	}
+
+	// lay the glyphs out from left to right
+	n := len(s.glyphs)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+		if rtl {
+			order[i] = n - 1 - i
+		}
+	}
+
+	shaped := make([]shapedGlyph, n)
+	x := fixed.Int26_6(0)
+	for k, i := range order {
+		g := s.glyphs[i]
+		if k > 0 && !kerned {
+			if kern, err := f.font.Kern(&f.buf, sfnt.GlyphIndex(s.glyphs[order[k-1]].gid), sfnt.GlyphIndex(g.gid), f.scale, f.hinting); err == nil {
+				x += kern
+			}
+		}
+		advance, _ := f.font.GlyphAdvance(&f.buf, sfnt.GlyphIndex(g.gid), f.scale, f.hinting)
+		if g.class == otClassMark {
+			advance = 0
+		}
+		shaped[i] = shapedGlyph{
+			face:  f,
+			r:     g.r,
+			gid:   sfnt.GlyphIndex(g.gid),
+			start: g.start,
+			end:   g.end,
+			dot:   fixed.Point26_6{X: x + scale(g.xOffset), Y: -scale(g.yOffset)},
+		}
+		x += advance + scale(g.xAdvance)
+	}
+
+	// marks are placed relative to the glyphs they are attached to, which come before them
+	for i, g := range s.glyphs {
+		if g.attach >= 0 && g.attach < i {
+			base := shaped[g.attach].dot
+			shaped[i].dot = fixed.Point26_6{X: base.X + scale(g.xOffset), Y: base.Y - scale(g.yOffset)}
+		}
+	}
+
+	result := make([]shapedGlyph, n)
+	for k, i := range order {
+		result[k] = shaped[i]
+	}
+
+	return result, x
+}
+
+// scaleUnits converts a value in font units times the pixels per em to pixels, rounding like sfnt does.
+func scaleUnits(x fixed.Int26_6, unitsPerEm sfnt.Units) fixed.Int26_6 {
+	if x >= 0 {
+		x += fixed.Int26_6(unitsPerEm) / 2
+	} else {
+		x -= fixed.Int26_6(unitsPerEm) / 2
+	}
+
+	return x / fixed.Int26_6(unitsPerEm)
+}
+
+// isDefaultIgnorable tells whether a rune is a default ignorable code point, which is not displayed, like the zero
+// width joiners and the bidirectional formatting characters.
+func isDefaultIgnorable(r rune) bool {
+	switch {
+	case r == softHyphen, r == 0x034f, r == 0x061c, r == 0xfeff:
+		return true
+	case 0x180b <= r && r <= 0x180f, 0x200b <= r && r <= 0x200f, 0x202a <= r && r <= 0x202e,
+		0x2060 <= r && r <= 0x206f, 0xfe00 <= r && r <= 0xfe0f:
+		return true
+	}
+
+	return false
+}
+
+// arabicRightJoining and arabicDualJoining list the Arabic letters that join to the letter before them, and those
+// that also join to the letter after them. Other letters don't join.
+var (
+	arabicRightJoining = &unicode.RangeTable{R16: []unicode.Range16{
+		{0x0622, 0x0625, 1}, {0x0627, 0x0629, 2}, {0x062f, 0x0632, 1}, {0x0648, 0x0671, 41}, {0x0672, 0x0673, 1},
+		{0x0675, 0x0677, 1}, {0x0688, 0x0699, 1}, {0x06c0, 0x06c3, 3}, {0x06c4, 0x06cb, 1}, {0x06cd, 0x06cf, 2},
+		{0x06d2, 0x06d3, 1}, {0x06d5, 0x06ee, 25}, {0x06ef, 0x0759, 106}, {0x075a, 0x075b, 1}, {0x076b, 0x076c, 1},
+		{0x0771, 0x0773, 2}, {0x0774, 0x0778, 4}, {0x0779, 0x0779, 1},
+	}}
+	arabicDualJoining = &unicode.RangeTable{R16: []unicode.Range16{
+		{0x0620, 0x0626, 6}, {0x0628, 0x062a, 2}, {0x062b, 0x062e, 1}, {0x0633, 0x063f, 1}, {0x0641, 0x0647, 1},
+		{0x0649, 0x064a, 1}, {0x066e, 0x066f, 1}, {0x0678, 0x0687, 1}, {0x069a, 0x06bf, 1}, {0x06c1, 0x06c2, 1},
+		{0x06cc, 0x06ce, 2}, {0x06d0, 0x06d1, 1}, {0x06fa, 0x06fc, 1}, {0x06ff, 0x06ff, 1}, {0x0750, 0x0758, 1},
+		{0x075c, 0x076a, 1}, {0x076d, 0x0770, 1}, {0x0772, 0x0775, 3}, {0x0776, 0x0777, 1}, {0x077a, 0x077f, 1},
+	}}
+)
+
+// arabicJoining returns the joining type of a rune: 'R' for right joining, 'D' for dual joining, 'C' for join causing,
+// 'T' for transparent and 'U' for non joining.
+func arabicJoining(r rune) byte {
+	switch {
+	case r == 0x200d || r == 0x0640:
+		return 'C'
+	case r == 0x200c:
+		return 'U'
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 'T'
+	case unicode.Is(arabicRightJoining, r):
+		return 'R'
+	case unicode.Is(arabicDualJoining, r):
+		return 'D'
+	}
+
+	return 'U'
+}
+
+// arabicForms selects the isolated, final, medial or initial form of the letters of Arabic text, in logical order,
+// from the letters they join to.
+func arabicForms(glyphs []otGlyph) {
+	joinsBefore := make([]bool, len(glyphs))
+	joinsAfter := make([]bool, len(glyphs))
+	prev := -1
+	for i, g := range glyphs {
+		t := arabicJoining(g.r)
+		if t == 'T' {
+			continue
+		}
+		if prev >= 0 {
+			p := arabicJoining(glyphs[prev].r)
+			if (p == 'D' || p == 'C') && (t == 'R' || t == 'D' || t == 'C') {
+				joinsBefore[i], joinsAfter[prev] = true, true
+			}
+		}
+		prev = i
+	}
+
+	for i := range glyphs {
+		switch arabicJoining(glyphs[i].r) {
+		case 'D':
+			switch {
+			case joinsBefore[i] && joinsAfter[i]:
+				glyphs[i].mask |= maskMedi
+			case joinsBefore[i]:
+				glyphs[i].mask |= maskFina
+			case joinsAfter[i]:
+				glyphs[i].mask |= maskInit
+			default:
+				glyphs[i].mask |= maskIsol
+			}
+		case 'R':
+			if joinsBefore[i] {
+				glyphs[i].mask |= maskFina
+			} else {
+				glyphs[i].mask |= maskIsol
+			}
+		}
+	}
+}
+
+// The Devanagari characters that the Indic shaper tells apart.
+const (
+	devaRa       = 0x0930
+	devaNukta    = 0x093c
+	devaVirama   = 0x094d
+	devaMatraI   = 0x093f
+	devaMatraPre = 0x094e
+	zeroWidthJ   = 0x200d
+	zeroWidthNJ  = 0x200c
+)
+
+// isDevaConsonant tells whether a rune is a Devanagari consonant.
+func isDevaConsonant(r rune) bool {
+	return 0x0915 <= r && r <= 0x0939 || 0x0958 <= r && r <= 0x095f || 0x0978 <= r && r <= 0x097f
+}
+
+// startsSyllable tells whether a Devanagari rune starts a syllable after the given rune: consonants and independent
+// vowels do, unless they follow a virama or a zero width joiner, and the other runes attach to the syllable before
+// them.
+func startsSyllable(prev, r rune) bool {
+	if prev == devaVirama || prev == zeroWidthJ {
+		return false
+	}
+
+	return isDevaConsonant(r) || 0x0904 <= r && r <= 0x0914 || 0x0960 <= r && r <= 0x0961 ||
+		0x0972 <= r && r <= 0x0977 || !unicode.Is(unicode.Devanagari, r) && r != zeroWidthJ && r != zeroWidthNJ
+}
+
+// indicSyllables splits Devanagari text into syllables, marks the glyphs that can take the reph, half and post-base
+// forms, and moves the pre-base matras before the consonants of their syllable.
+func indicSyllables(glyphs []otGlyph) []otGlyph {
+	syllable := 0
+	for i := range glyphs {
+		if i > 0 && startsSyllable(glyphs[i-1].r, glyphs[i].r) {
+			syllable++
+		}
+		glyphs[i].syllable = syllable
+	}
+
+	for a := 0; a < len(glyphs); {
+		b := a
+		for b < len(glyphs) && glyphs[b].syllable == glyphs[a].syllable {
+			b++
+		}
+
+		start := a
+		if b-a >= 3 && glyphs[a].r == devaRa && glyphs[a+1].r == devaVirama && isDevaConsonant(glyphs[a+2].r) {
+			glyphs[a].mask |= maskReph
+			glyphs[a+1].mask |= maskReph
+			start = a + 2
+		}
+		base := -1
+		for k := start; k < b; k++ {
+			if isDevaConsonant(glyphs[k].r) {
+				base = k
+			}
+		}
+		if base >= 0 {
+			for k := start; k < base; k++ {
+				glyphs[k].mask |= maskHalf
+			}
+			for k := base + 1; k < b; k++ {
+				glyphs[k].mask |= maskPost
+			}
+			for k := base + 1; k < b; k++ {
+				if r := glyphs[k].r; r == devaMatraI || r == devaMatraPre {
+					matra := glyphs[k]
+					copy(glyphs[start+1:k+1], glyphs[start:k])
+					glyphs[start] = matra
+				}
+			}
+		}
+		a = b
+	}
+
+	return glyphs
+}
+
+// placeReph moves the reph forms to the end of their syllables.
+func placeReph(glyphs []otGlyph) []otGlyph {
+	for i := len(glyphs) - 1; i >= 0; i-- {
+		if !glyphs[i].reph {
+			continue
+		}
+		end := i
+		for end+1 < len(glyphs) && glyphs[end+1].syllable == glyphs[i].syllable {
+			end++
+		}
+		reph := glyphs[i]
+		reph.reph = false
+		copy(glyphs[i:end], glyphs[i+1:end+1])
+		glyphs[end] = reph
+	}
+
+	return glyphs
+}
+
+// image returns the mask of a glyph drawn at dot, like font.Face.Glyph. Missing glyphs take space but aren't drawn.
+func (g shapedGlyph) image(dot fixed.Point26_6) (image.Rectangle, image.Image, image.Point, bool) {
+	if g.r >= 0 {
+		dr, mask, maskp, _, ok := g.face.Glyph(dot, g.r)
+		return dr, mask, maskp, ok
+	}
+
+	return g.face.(*fontFace).glyph(dot, g.gid)
+}
+
+// glyph rasterizes a glyph by index, like opentype.Face.Glyph does for the glyph of a rune.
+func (f *fontFace) glyph(dot fixed.Point26_6, gid sfnt.GlyphIndex) (dr image.Rectangle, mask image.Image, maskp image.Point, ok bool) {
+	segments, err := f.font.LoadGlyph(&f.buf, gid, f.scale, nil)
+	if err != nil {
+		return image.Rectangle{}, nil, image.Point{}, false
+	}
+
+	bounds := segments.Bounds().Add(dot)
+	dr = image.Rect(bounds.Min.X.Floor(), bounds.Min.Y.Floor(), bounds.Max.X.Ceil(), bounds.Max.Y.Ceil())
+	if dr.Dx() < 0 || dr.Dy() < 0 {
+		return image.Rectangle{}, nil, image.Point{}, false
+	}
+	biasX := dot.X - fixed.I(dr.Min.X)
+	biasY := dot.Y - fixed.I(dr.Min.Y)
+	pt := func(p fixed.Point26_6) (float32, float32) {
+		return float32(p.X+biasX) / 64, float32(p.Y+biasY) / 64
+	}
+
+	alpha := image.NewAlpha(image.Rect(0, 0, dr.Dx(), dr.Dy()))
+	f.rast.Reset(dr.Dx(), dr.Dy())
+	f.rast.DrawOp = draw.Src
+	for _, seg := range segments {
+		switch seg.Op {
+		case sfnt.SegmentOpMoveTo:
+			f.rast.MoveTo(pt(seg.Args[0]))
+		case sfnt.SegmentOpLineTo:
+			f.rast.LineTo(pt(seg.Args[0]))
+		case sfnt.SegmentOpQuadTo:
+			x1, y1 := pt(seg.Args[0])
+			x2, y2 := pt(seg.Args[1])
+			f.rast.QuadTo(x1, y1, x2, y2)
+		case sfnt.SegmentOpCubeTo:
+			x1, y1 := pt(seg.Args[0])
+			x2, y2 := pt(seg.Args[1])
+			x3, y3 := pt(seg.Args[2])
+			f.rast.CubeTo(x1, y1, x2, y2, x3, y3)
+		}
+	}
+	f.rast.Draw(alpha, alpha.Bounds(), image.Opaque, image.Point{})
+
+	return dr, alpha, image.Point{}, gid != 0
+}
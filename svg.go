@@ -0,0 +1,491 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/sfnt"
+)
+
+// conicWedges is the number of solid wedges used to approximate a conic gradient in SVG output,
+// which has no native conic gradient.
+const conicWedges = 360
+
+// conicRadius returns the length of the wedges of a conic gradient, in the gradient's space, so that they cover a
+// canvas of the given size.
+func conicRadius(g *conicGradient, width, height float64) float64 {
+	if !g.transformed {
+		return 2 * (width + height + math.Abs(g.cx) + math.Abs(g.cy))
+	}
+
+	r := 0.0
+	for _, p := range [][2]float64{{0, 0}, {width, 0}, {0, height}, {width, height}} {
+		x, y := g.inverse.TransformPoint(p[0], p[1])
+		r = math.Max(r, math.Hypot(x-g.cx, y-g.cy))
+	}
+
+	return 2*r + 1
+}
+
+// svgEncoder writes recorded drawing operations as an SVG document.
+type svgEncoder struct {
+	w             *bufio.Writer
+	width, height float64
+	nextID        int
+	clips         map[*recordClip]string
+	patterns      map[Pattern]string
+}
+
+// newSVGEncoder creates an svgEncoder writing to w for a canvas of the given size.
+func newSVGEncoder(w io.Writer, width, height float64) *svgEncoder {
+	return &svgEncoder{
+		w:        bufio.NewWriter(w),
+		width:    width,
+		height:   height,
+		clips:    make(map[*recordClip]string),
+		patterns: make(map[Pattern]string),
+	}
+}
+
+// encode writes the complete SVG document for the given operations.
+func (e *svgEncoder) encode(ops []recordOp) error {
+	fmt.Fprintf(e.w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(e.w, "<svg xmlns=\"http://www.w3.org/2000/svg\" xmlns:xlink=\"http://www.w3.org/1999/xlink\" version=\"1.1\" width=\"%s\" height=\"%s\" viewBox=\"0 0 %s %s\">\n",
+		svgNum(e.width), svgNum(e.height), svgNum(e.width), svgNum(e.height))
+
+	for _, op := range ops {
+		e.op(op)
+	}
+
+	fmt.Fprintf(e.w, "</svg>\n")
+
+	return e.w.Flush()
+}
+
+// id returns a fresh element id with the given prefix.
+func (e *svgEncoder) id(prefix string) string {
+	e.nextID++
+	return prefix + strconv.Itoa(e.nextID)
+}
+
+// op writes a single recorded operation, wrapped in one group per node of its clip chain.
+func (e *svgEncoder) op(op recordOp) {
+	var chain []*recordClip
+	for c := op.clip; c != nil; c = c.parent {
+		chain = append(chain, c)
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		c := chain[i]
+		id := e.clip(c)
+		if c.mask != nil {
+			fmt.Fprintf(e.w, "<g mask=\"url(#%s)\">\n", id)
+		} else {
+			fmt.Fprintf(e.w, "<g clip-path=\"url(#%s)\">\n", id)
+		}
+	}
+
+	var group []string
+	if blend := svgBlendMode(op.operator); blend != "" {
+		group = append(group, fmt.Sprintf("style=\"mix-blend-mode:%s\"", blend))
+	}
+	if op.alpha < 1 {
+		group = append(group, fmt.Sprintf("opacity=\"%s\"", svgNum(op.alpha)))
+	}
+	if len(group) > 0 {
+		fmt.Fprintf(e.w, "<g %s>\n", strings.Join(group, " "))
+	}
+
+	switch op.kind {
+	case recordFill:
+		paint := e.paint(op.pattern)
+		fmt.Fprintf(e.w, "<path d=\"%s\" fill-rule=\"%s\"%s/>\n", svgPathData(op.path), svgFillRule(op.fillRule), svgPaintAttrs("fill", paint))
+	case recordStroke:
+		paint := e.paint(op.pattern)
+		fmt.Fprintf(e.w, "<path d=\"%s\" fill=\"none\"%s%s/>\n", svgPathData(op.path), svgPaintAttrs("stroke", paint), svgStrokeAttrs(op))
+	case recordImage:
+		b := op.image.Bounds()
+		fmt.Fprintf(e.w, "<image transform=\"%s\" x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" xlink:href=\"%s\"/>\n",
+			svgMatrix(op.matrix), b.Min.X, b.Min.Y, b.Dx(), b.Dy(), svgImageData(op.image))
+	case recordText:
+		family, size := svgFont(op)
+		fmt.Fprintf(e.w, "<text transform=\"%s\" x=\"%s\" y=\"%s\" font-family=\"%s\" font-size=\"%s\" xml:space=\"preserve\"%s>%s</text>\n",
+			svgMatrix(op.matrix), svgNum(op.x), svgNum(op.y), svgEscape(family), svgNum(size), svgPaintAttrs("fill", svgColor(op.color)), svgEscape(op.text))
+	}
+
+	if len(group) > 0 {
+		fmt.Fprintf(e.w, "</g>\n")
+	}
+	for range chain {
+		fmt.Fprintf(e.w, "</g>\n")
+	}
+}
+
+// svgBlendMode returns the CSS mix-blend-mode of a composite operator, or an empty string for source-over and
+// the Porter-Duff operators, which SVG can't express.
+func svgBlendMode(op CompositeOperator) string {
+	if op < CompositeMultiply || int(op) >= int(CompositeMultiply)+len(blendModeNames) {
+		return ""
+	}
+
+	return blendModeNames[op-CompositeMultiply]
+}
+
+// clip returns the id of the definition for a clip node, writing the definition first if needed.
+// The parents of the node are handled by op, so only the node's own region is defined here.
+func (e *svgEncoder) clip(c *recordClip) string {
+	if id, ok := e.clips[c]; ok {
+		return id
+	}
+
+	var id string
+	if c.mask != nil {
+		id = e.id("mask")
+		b := c.mask.Bounds()
+		fmt.Fprintf(e.w, "<defs><mask id=\"%s\" maskUnits=\"userSpaceOnUse\" x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\">", id, b.Min.X, b.Min.Y, b.Dx(), b.Dy())
+		fmt.Fprintf(e.w, "<image x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" xlink:href=\"%s\"/>", b.Min.X, b.Min.Y, b.Dx(), b.Dy(), svgImageData(alphaToWhite(c.mask)))
+		fmt.Fprintf(e.w, "</mask></defs>\n")
+	} else {
+		id = e.id("clip")
+		fmt.Fprintf(e.w, "<defs><clipPath id=\"%s\" clipPathUnits=\"userSpaceOnUse\"><path d=\"%s\" clip-rule=\"%s\"/></clipPath></defs>\n",
+			id, svgPathData(c.path), svgFillRule(c.fillRule))
+	}
+
+	e.clips[c] = id
+
+	return id
+}
+
+// svgPaint is a paint server reference or a solid color, ready to be written as attributes.
+type svgPaint struct {
+	value   string
+	opacity float64
+}
+
+// svgColor converts a color into an svgPaint.
+func svgColor(c color.Color) svgPaint {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+
+	return svgPaint{
+		value:   fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B),
+		opacity: float64(n.A) / 255,
+	}
+}
+
+// svgPaintAttrs formats an svgPaint as the given fill or stroke attribute.
+func svgPaintAttrs(attr string, p svgPaint) string {
+	s := fmt.Sprintf(" %s=\"%s\"", attr, p.value)
+	if p.opacity < 1 {
+		s += fmt.Sprintf(" %s-opacity=\"%s\"", attr, svgNum(p.opacity))
+	}
+
+	return s
+}
+
+// paint returns the svgPaint for a pattern, writing a paint server definition first if needed.
+func (e *svgEncoder) paint(p Pattern) svgPaint {
+	if sp, ok := p.(*solidPattern); ok {
+		return svgColor(sp.color)
+	}
+
+	// only the package's own patterns are known to be comparable, so only they are shared
+	cacheable := false
+	switch p.(type) {
+	case *linearGradient, *radialGradient, *conicGradient, *surfacePattern:
+		cacheable = true
+	}
+
+	if cacheable {
+		if id, ok := e.patterns[p]; ok {
+			return svgPaint{value: "url(#" + id + ")", opacity: 1}
+		}
+	}
+
+	var id string
+	switch p := p.(type) {
+	case *linearGradient:
+		spread, ok := svgSpread(p.spread, p.stops)
+		if !ok {
+			id = e.sample(p)
+			break
+		}
+		id = e.id("gradient")
+		fmt.Fprintf(e.w, "<defs><linearGradient id=\"%s\" gradientUnits=\"userSpaceOnUse\" x1=\"%s\" y1=\"%s\" x2=\"%s\" y2=\"%s\"%s%s>%s</linearGradient></defs>\n",
+			id, svgNum(p.x0), svgNum(p.y0), svgNum(p.x1), svgNum(p.y1), spread, svgTransform("gradientTransform", p.patternMatrix), svgStops(srgbStops(p.stops, p.interpolation)))
+	case *radialGradient:
+		spread, ok := svgSpread(p.spread, p.stops)
+		if !ok {
+			id = e.sample(p)
+			break
+		}
+		id = e.id("gradient")
+		fmt.Fprintf(e.w, "<defs><radialGradient id=\"%s\" gradientUnits=\"userSpaceOnUse\" cx=\"%s\" cy=\"%s\" r=\"%s\" fx=\"%s\" fy=\"%s\" fr=\"%s\"%s%s>%s</radialGradient></defs>\n",
+			id, svgNum(p.c1.x), svgNum(p.c1.y), svgNum(p.c1.r), svgNum(p.c0.x), svgNum(p.c0.y), svgNum(p.c0.r), spread, svgTransform("gradientTransform", p.patternMatrix), svgStops(srgbStops(p.stops, p.interpolation)))
+	case *conicGradient:
+		id = e.id("pattern")
+		e.conic(id, p)
+	case *surfacePattern:
+		id = e.id("pattern")
+		b := p.im.Bounds()
+		w, h := float64(b.Dx()), float64(b.Dy())
+		// a tile larger than the canvas stops the image from repeating along that axis
+		cw, ch := e.width, e.height
+		if p.transformed {
+			cw, ch = 0, 0
+			for _, c := range [][2]float64{{0, 0}, {e.width, 0}, {0, e.height}, {e.width, e.height}} {
+				x, y := p.inverse.TransformPoint(c[0], c[1])
+				cw, ch = math.Max(cw, math.Abs(x)), math.Max(ch, math.Abs(y))
+			}
+		}
+		if p.op == RepeatY || p.op == RepeatNone {
+			w = math.Max(w, cw) * 2
+		}
+		if p.op == RepeatX || p.op == RepeatNone {
+			h = math.Max(h, ch) * 2
+		}
+		fmt.Fprintf(e.w, "<defs><pattern id=\"%s\" patternUnits=\"userSpaceOnUse\" x=\"0\" y=\"0\" width=\"%s\" height=\"%s\"%s><image x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" xlink:href=\"%s\"/></pattern></defs>\n",
+			id, svgNum(w), svgNum(h), svgTransform("patternTransform", p.patternMatrix), b.Dx(), b.Dy(), svgImageData(p.im))
+	default:
+		id = e.sample(p)
+	}
+
+	if cacheable {
+		e.patterns[p] = id
+	}
+
+	return svgPaint{value: "url(#" + id + ")", opacity: 1}
+}
+
+// sample writes a pattern without a vector equivalent as an image of its colors over the whole canvas and returns its id.
+func (e *svgEncoder) sample(p Pattern) string {
+	id := e.id("pattern")
+	w, h := int(math.Ceil(e.width)), int(math.Ceil(e.height))
+	im := image.NewRGBA(image.Rect(0, 0, w, h))
+	p = patternOver(p, im.Bounds())
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			im.Set(x, y, p.ColorAt(x, y))
+		}
+	}
+	fmt.Fprintf(e.w, "<defs><pattern id=\"%s\" patternUnits=\"userSpaceOnUse\" x=\"0\" y=\"0\" width=\"%d\" height=\"%d\"><image x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" xlink:href=\"%s\"/></pattern></defs>\n",
+		id, w, h, w, h, svgImageData(im))
+
+	return id
+}
+
+// svgSpread returns the spreadMethod attribute for a gradient spread mode. SVG repeats gradients over the whole
+// gradient vector, so repeating gradients whose stops don't span it exactly have no SVG equivalent.
+func svgSpread(mode SpreadMode, stops stops) (string, bool) {
+	switch mode {
+	case SpreadRepeat, SpreadReflect:
+		if len(stops) == 0 || stops[0].pos != 0 || stops[len(stops)-1].pos != 1 {
+			return "", false
+		}
+		if mode == SpreadRepeat {
+			return ` spreadMethod="repeat"`, true
+		}
+		return ` spreadMethod="reflect"`, true
+	}
+
+	return "", true
+}
+
+// conic writes a conic gradient as a pattern of solid wedges around the gradient center.
+func (e *svgEncoder) conic(id string, g *conicGradient) {
+	r := conicRadius(g, e.width, e.height)
+
+	fmt.Fprintf(e.w, "<defs><pattern id=\"%s\" patternUnits=\"userSpaceOnUse\" x=\"%s\" y=\"%s\" width=\"%s\" height=\"%s\"%s>",
+		id, svgNum(g.cx-r), svgNum(g.cy-r), svgNum(2*r), svgNum(2*r), svgTransform("patternTransform", g.patternMatrix))
+
+	if len(g.stops) > 0 {
+		for i := 0; i < conicWedges; i++ {
+			t0 := float64(i) / conicWedges
+			t1 := float64(i+1) / conicWedges
+			a0 := (t0+g.rotation)*2*math.Pi - math.Pi
+			a1 := (t1+g.rotation)*2*math.Pi - math.Pi
+			p := svgColor(g.colorAt((t0 + t1) / 2))
+			// the wedges are stroked with their own color to hide anti-aliasing seams
+			fmt.Fprintf(e.w, "<path d=\"M%s %sL%s %sL%s %sZ\"%s%s stroke-width=\"0.5\"/>",
+				svgNum(g.cx), svgNum(g.cy),
+				svgNum(g.cx+r*math.Cos(a0)), svgNum(g.cy+r*math.Sin(a0)),
+				svgNum(g.cx+r*math.Cos(a1)), svgNum(g.cy+r*math.Sin(a1)),
+				svgPaintAttrs("fill", p), svgPaintAttrs("stroke", p))
+		}
+	}
+
+	fmt.Fprintf(e.w, "</pattern></defs>\n")
+}
+
+// svgStops formats gradient color stops as SVG stop elements.
+func svgStops(stops stops) string {
+	var b strings.Builder
+
+	for _, s := range stops {
+		p := svgColor(s.color)
+		fmt.Fprintf(&b, "<stop offset=\"%s\" stop-color=\"%s\"", svgNum(s.pos), p.value)
+		if p.opacity < 1 {
+			fmt.Fprintf(&b, " stop-opacity=\"%s\"", svgNum(p.opacity))
+		}
+		b.WriteString("/>")
+	}
+
+	return b.String()
+}
+
+// svgStrokeAttrs formats the stroke style of a recorded stroke as SVG attributes.
+func svgStrokeAttrs(op recordOp) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, " stroke-width=\"%s\"", svgNum(op.lineWidth))
+
+	switch op.lineCap {
+	case LineCapRound:
+		b.WriteString(" stroke-linecap=\"round\"")
+	case LineCapButt:
+		b.WriteString(" stroke-linecap=\"butt\"")
+	case LineCapSquare:
+		b.WriteString(" stroke-linecap=\"square\"")
+	}
+
+	switch op.lineJoin {
+	case LineJoinRound:
+		b.WriteString(" stroke-linejoin=\"round\"")
+	case LineJoinBevel:
+		b.WriteString(" stroke-linejoin=\"bevel\"")
+	case LineJoinMiter:
+		fmt.Fprintf(&b, " stroke-linejoin=\"miter\" stroke-miterlimit=\"%s\"", svgNum(op.miterLimit))
+	}
+
+	if len(op.dashes) > 0 {
+		dashes := make([]string, len(op.dashes))
+		for i, d := range op.dashes {
+			dashes[i] = svgNum(d)
+		}
+		fmt.Fprintf(&b, " stroke-dasharray=\"%s\"", strings.Join(dashes, " "))
+		if op.dashOffset != 0 {
+			fmt.Fprintf(&b, " stroke-dashoffset=\"%s\"", svgNum(op.dashOffset))
+		}
+	}
+
+	return b.String()
+}
+
+// svgFillRule returns the SVG name of a fill rule.
+func svgFillRule(fillRule FillRule) string {
+	if fillRule == FillRuleEvenOdd {
+		return "evenodd"
+	}
+
+	return "nonzero"
+}
+
+// svgPathData formats a recorded path as SVG path data.
+func svgPathData(p Path) string {
+	var b strings.Builder
+
+	for _, s := range p {
+		switch s.Op {
+		case PathMoveTo:
+			fmt.Fprintf(&b, "M%s %s", svgNum(s.Points[0].X), svgNum(s.Points[0].Y))
+		case PathLineTo:
+			fmt.Fprintf(&b, "L%s %s", svgNum(s.Points[0].X), svgNum(s.Points[0].Y))
+		case PathQuadTo:
+			fmt.Fprintf(&b, "Q%s %s %s %s", svgNum(s.Points[0].X), svgNum(s.Points[0].Y), svgNum(s.Points[1].X), svgNum(s.Points[1].Y))
+		case PathCubicTo:
+			fmt.Fprintf(&b, "C%s %s %s %s %s %s", svgNum(s.Points[0].X), svgNum(s.Points[0].Y), svgNum(s.Points[1].X), svgNum(s.Points[1].Y), svgNum(s.Points[2].X), svgNum(s.Points[2].Y))
+		case PathClose:
+			b.WriteString("Z")
+		}
+	}
+
+	return b.String()
+}
+
+// svgTransform returns a transform attribute for the matrix of a pattern, or nothing if it isn't transformed.
+func svgTransform(name string, t patternMatrix) string {
+	if !t.transformed {
+		return ""
+	}
+
+	return fmt.Sprintf(" %s=\"%s\"", name, svgMatrix(t.matrix))
+}
+
+// svgMatrix formats a matrix as an SVG transform.
+func svgMatrix(m Matrix) string {
+	return fmt.Sprintf("matrix(%s %s %s %s %s %s)", svgNum(m.XX), svgNum(m.YX), svgNum(m.XY), svgNum(m.YY), svgNum(m.X0), svgNum(m.Y0))
+}
+
+// svgNum formats a number with at most three decimals.
+func svgNum(v float64) string {
+	v = math.Round(v*1000) / 1000
+	if v == 0 {
+		// avoid "-0"
+		v = 0
+	}
+
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// svgEscaper escapes the XML special characters in text and attribute values.
+var svgEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;", "'", "&apos;")
+
+// svgEscape escapes text for use in SVG character data and attribute values.
+func svgEscape(s string) string {
+	return svgEscaper.Replace(s)
+}
+
+// svgImageData encodes an image as a PNG data URI.
+func svgImageData(im image.Image) string {
+	var b bytes.Buffer
+	if err := png.Encode(&b, im); err != nil {
+		return ""
+	}
+
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(b.Bytes())
+}
+
+// alphaToWhite converts an alpha mask into a white image with the same alpha, which is what an SVG
+// luminance mask needs to reproduce the mask.
+func alphaToWhite(mask *image.Alpha) *image.NRGBA {
+	b := mask.Bounds()
+	im := image.NewNRGBA(b)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			im.SetNRGBA(x, y, color.NRGBA{255, 255, 255, mask.AlphaAt(x, y).A})
+		}
+	}
+
+	return im
+}
+
+// svgFont returns the font family and size used to write a recorded text operation.
+func svgFont(op recordOp) (string, float64) {
+	if f, points, ok := faceFont(op.face); ok {
+		family, err := f.Name(nil, sfnt.NameIDFamily)
+		if err != nil || family == "" {
+			family = "sans-serif"
+		}
+		return family, points
+	}
+
+	if op.face == basicfont.Face7x13 {
+		return "monospace", 13
+	}
+
+	return "sans-serif", float64(op.face.Metrics().Height) / 64
+}
@@ -0,0 +1,235 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"image"
+	"math"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// StringPath returns the outlines of the glyphs of a string as a path, with the font f at the given size in points.
+//
+// The string starts at (x, y) on the baseline and is shaped like the text of DrawString: its runes are reordered for
+// right to left scripts and its glyphs are substituted and placed with the layout tables and the kerning of the font.
+// Y increases down, like in the context. Runes that the font can't map are skipped. Glyphs are read at the resolution
+// of the font's design units, so the outlines are exact at any size.
+func StringPath(f *opentype.Font, points float64, s string, x, y float64) (Path, error) {
+	face, err := FontNewFace(f, points)
+	if err != nil {
+		return nil, err
+	}
+
+	return glyphsPath(shapeString(face, s).glyphs, x, y)
+}
+
+// glyphsPath returns the outlines of shaped glyphs drawn at (x, y). Glyphs of faces without outlines are skipped.
+func glyphsPath(glyphs []shapedGlyph, x, y float64) (Path, error) {
+	var buf sfnt.Buffer
+	var path Path
+	for _, g := range glyphs {
+		f, ok := g.face.(*fontFace)
+		if !ok || g.gid == 0 {
+			continue
+		}
+
+		upem := f.font.UnitsPerEm()
+		segments, err := f.font.LoadGlyph(&buf, g.gid, fixed.I(int(upem)), nil)
+		if err != nil {
+			return nil, err
+		}
+		scale := f.points / float64(upem)
+		// glyphPath flips the outline to point Y up, so flip it back while scaling it down
+		m := Scale(scale, -scale).Multiply(Translate(x+unfix(g.dot.X), y+unfix(g.dot.Y)))
+		path = append(path, glyphPath(segments).Transform(m)...)
+	}
+
+	return path, nil
+}
+
+// TextPath appends the outlines of a string, drawn with the current font face at (x, y), to the current path.
+//
+// The outlines are transformed by the current transformation matrix, so the text can then be filled with any pattern,
+// stroked or used as a clip. Only faces created with FontNewFace, including those of LoadFontFace, have outlines; with
+// other faces nothing is appended. The text is shaped like the text of DrawString, and the text of a font family is
+// appended face by face.
+func (dc *Context) TextPath(s string, x, y float64) {
+	path, err := glyphsPath(shapeString(dc.fontFace, s).glyphs, x, y)
+	if err != nil {
+		return
+	}
+	dc.AppendPath(path)
+}
+
+// DrawStringOnPath draws a string along a path, cluster by cluster, with each cluster turned to follow the path.
+//
+// The path is in user space and its curves are flattened. Like the textPath of SVG, the text is anchored at the
+// distance offset along the path, and align selects whether the text starts, is centered or ends at that point. The
+// string is shaped like the text of DrawString, and its glyphs are placed in clusters: a glyph with the marks attached
+// to it, or the glyphs of a ligature, stay together. Each cluster sits on the path with its baseline along the tangent
+// at the middle of the cluster, and clusters whose middle falls off either end of the path are not drawn. When the
+// optional upright flag is set and the text would mostly be upside down, the path is followed in reverse, starting at
+// its end.
+func (dc *Context) DrawStringOnPath(s string, path Path, offset float64, align Align, upright ...bool) {
+	walker := newPathWalker(path)
+	if walker.length == 0 {
+		return
+	}
+
+	shaped := shapeString(dc.fontFace, s)
+	width := unfix(shaped.advance)
+	switch align {
+	case AlignCenter:
+		offset -= width / 2
+	case AlignRight:
+		offset -= width
+	}
+
+	if len(upright) > 0 && upright[0] {
+		// the text is upside down when the path mostly goes from right to left under it
+		a, _ := walker.at(offset)
+		b, _ := walker.at(offset + width)
+		if b.X < a.X {
+			walker = newPathWalker(path.reverse())
+			offset = walker.length - offset - width
+		}
+	}
+
+	for _, c := range glyphClusters(s, shaped) {
+		d := offset + c.x + c.width/2
+		if d < 0 || d > walker.length {
+			continue
+		}
+		p, angle := walker.at(d)
+		dc.Push()
+		dc.Translate(p.X, p.Y)
+		dc.Rotate(angle)
+		x := -c.x - c.width/2
+		dc.paintText(func(im *image.RGBA) {
+			dc.drawGlyphs(im, c.glyphs, x, 0)
+		})
+		if c.start < c.end {
+			dc.recordText(s[c.start:c.end], -c.width/2, 0)
+		}
+		dc.Pop()
+	}
+}
+
+// glyphCluster is a group of shaped glyphs that are placed together, like a glyph and the marks attached to it, or the
+// glyphs of a ligature.
+type glyphCluster struct {
+	glyphs     []shapedGlyph
+	start, end int     // the bytes of the string the cluster comes from
+	x, width   float64 // the pen position of the cluster and its advance
+}
+
+// glyphClusters groups the glyphs of a shaped string into clusters, from left to right. Glyphs whose runes, extended
+// with the marks that follow them, overlap are in the same cluster. A cluster starts at the first of its glyphs that
+// isn't a mark and ends where the next cluster starts.
+func glyphClusters(s string, shaped shapedString) []glyphCluster {
+	var clusters []glyphCluster
+	for _, g := range shaped.glyphs {
+		start, end := clusterBounds(s, g.start, g.end)
+		if n := len(clusters); n > 0 {
+			last := &clusters[n-1]
+			if start >= end || start < last.end && end > last.start {
+				last.glyphs = append(last.glyphs, g)
+				last.start, last.end = min(last.start, start), max(last.end, end)
+				continue
+			}
+		}
+		clusters = append(clusters, glyphCluster{glyphs: []shapedGlyph{g}, start: start, end: end})
+	}
+
+	for i := range clusters {
+		c := &clusters[i]
+		c.x = unfix(c.glyphs[0].dot.X)
+		for _, g := range c.glyphs {
+			if r, _ := utf8.DecodeRuneInString(s[g.start:]); g.start >= g.end || !unicode.In(r, unicode.Mn, unicode.Me) {
+				c.x = unfix(g.dot.X)
+				break
+			}
+		}
+	}
+	for i := range clusters {
+		next := unfix(shaped.advance)
+		if i+1 < len(clusters) {
+			next = clusters[i+1].x
+		}
+		clusters[i].width = next - clusters[i].x
+	}
+
+	return clusters
+}
+
+// clusterBounds extends the bytes [start, end) of a string back to the rune that the marks at start combine with, and
+// forward over the marks that follow.
+func clusterBounds(s string, start, end int) (int, int) {
+	for start > 0 && start < len(s) {
+		if r, _ := utf8.DecodeRuneInString(s[start:]); !unicode.IsMark(r) {
+			break
+		}
+		_, size := utf8.DecodeLastRuneInString(s[:start])
+		start -= size
+	}
+	for end < len(s) {
+		r, size := utf8.DecodeRuneInString(s[end:])
+		if !unicode.IsMark(r) {
+			break
+		}
+		end += size
+	}
+
+	return start, end
+}
+
+// pathWalker finds points and tangents at given distances along a flattened path.
+type pathWalker struct {
+	lines  [][2]Point
+	starts []float64
+	length float64
+}
+
+// newPathWalker flattens a path into the line segments of a pathWalker, skipping degenerate ones.
+func newPathWalker(path Path) *pathWalker {
+	w := &pathWalker{}
+	for _, points := range path.flatten() {
+		for i := 1; i < len(points); i++ {
+			d := points[i-1].Distance(points[i])
+			if d == 0 {
+				continue
+			}
+			w.lines = append(w.lines, [2]Point{points[i-1], points[i]})
+			w.starts = append(w.starts, w.length)
+			w.length += d
+		}
+	}
+
+	return w
+}
+
+// at returns the point at the distance d along the path and the angle of the path there. Distances outside the
+// path extend its first or last segment.
+func (w *pathWalker) at(d float64) (Point, float64) {
+	i := sort.SearchFloat64s(w.starts, d) - 1
+	if i < 0 {
+		i = 0
+	}
+
+	line := w.lines[i]
+	end := w.length
+	if i+1 < len(w.starts) {
+		end = w.starts[i+1]
+	}
+	t := (d - w.starts[i]) / (end - w.starts[i])
+
+	return line[0].Interpolate(line[1], t), math.Atan2(line[1].Y-line[0].Y, line[1].X-line[0].X)
+}
@@ -0,0 +1,391 @@
+// Copyright 2023 The gg Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gg
+
+import (
+	"image"
+	"math"
+	"sort"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// WritingMode defines the direction of the lines of text.
+type WritingMode int
+
+const (
+	WritingModeHorizontal WritingMode = iota // Lines run from left to right and stack from top to bottom.
+	WritingModeVertical                      // Columns run from top to bottom and stack from right to left.
+)
+
+// SetWritingMode sets the writing mode of text drawing, measuring and wrapping.
+//
+// In vertical mode, used for Japanese and Chinese, DrawString draws a column of text whose top-left corner is at (x,
+// y), and the anchors of DrawStringAnchored are fractions of the width and height of the column from that corner, or
+// of the box of the column selected by its TextBox.
+// Ideographs, kana, Hangul and symbols stand upright, with the vertical metrics of the font when it has them and the
+// vertical forms of its punctuation, while runs of other scripts, like Latin words and numbers, are turned sideways.
+// MeasureString returns the width and the height of the column, and DrawStringWrapped breaks the text into columns
+// no taller than its width argument, from right to left.
+func (dc *Context) SetWritingMode(mode WritingMode) {
+	dc.writingMode = mode
+}
+
+// vertical tells whether the text of the context is vertical.
+func (dc *Context) vertical() bool {
+	return dc.writingMode == WritingModeVertical
+}
+
+// verticalMetrics holds the vertical metrics of a font: its vmtx table, with the number of its full records, and
+// its VORG table if it has one.
+type verticalMetrics struct {
+	vmtx otData
+	long int
+	vorg otData
+}
+
+// parseVerticalMetrics reads the vertical metrics of a font, and returns nil if it has none.
+func parseVerticalMetrics(tables map[string]otData) *verticalMetrics {
+	vhea, vmtx := tables["vhea"], tables["vmtx"]
+	if vhea == nil || vmtx == nil {
+		return nil
+	}
+
+	v := &verticalMetrics{vmtx: vmtx, long: int(vhea.u16(34)), vorg: tables["VORG"]}
+	if v.long == 0 {
+		return nil
+	}
+
+	return v
+}
+
+// metrics returns the vertical advance of a glyph and the space above its outline, in font units.
+func (v *verticalMetrics) metrics(gid sfnt.GlyphIndex) (advance, topBearing int) {
+	i := int(gid)
+	if i < v.long {
+		return int(v.vmtx.u16(4 * i)), int(v.vmtx.i16(4*i + 2))
+	}
+
+	return int(v.vmtx.u16(4 * (v.long - 1))), int(v.vmtx.i16(4*v.long + 2*(i-v.long)))
+}
+
+// origin returns the height of the vertical origin of a glyph above the baseline in font units, if the font has a
+// VORG table.
+func (v *verticalMetrics) origin(gid sfnt.GlyphIndex) (int, bool) {
+	if v.vorg == nil {
+		return 0, false
+	}
+
+	n := int(v.vorg.u16(6))
+	i := sort.Search(n, func(i int) bool {
+		return v.vorg.u16(8+4*i) >= uint16(gid)
+	})
+	if i < n && v.vorg.u16(8+4*i) == uint16(gid) {
+		return int(v.vorg.i16(8 + 4*i + 2)), true
+	}
+
+	return int(v.vorg.i16(4)), true
+}
+
+// verticalGlyph returns the vertical advance of a glyph and the distance from the top of its advance, its vertical
+// origin, down to its baseline. Without vertical metrics, glyphs take the height of the face.
+func (f *fontFace) verticalGlyph(gid sfnt.GlyphIndex) (advance, origin fixed.Int26_6) {
+	m := f.Metrics()
+	v := f.fontTables().vertical
+	if v == nil {
+		return m.Ascent + m.Descent, m.Ascent
+	}
+
+	scale := func(u int) fixed.Int26_6 {
+		return scaleUnits(fixed.Int26_6(u)*f.scale, f.font.UnitsPerEm())
+	}
+	units, topBearing := v.metrics(gid)
+	if y, ok := v.origin(gid); ok {
+		return scale(units), scale(y)
+	}
+	bounds, _, err := f.font.GlyphBounds(&f.buf, gid, f.scale, f.hinting)
+	if err != nil {
+		return scale(units), m.Ascent
+	}
+
+	return scale(units), scale(topBearing) - bounds.Min.Y
+}
+
+// layoutVertical places shaped glyphs from top to bottom, centered on the column, and returns them with their advance.
+func (f *fontFace) layoutVertical(glyphs []otGlyph, scale func(int32) fixed.Int26_6) ([]shapedGlyph, fixed.Int26_6) {
+	shaped := make([]shapedGlyph, len(glyphs))
+	y := fixed.Int26_6(0)
+	base, baseAdvance := fixed.Point26_6{}, fixed.Int26_6(0)
+	for i, g := range glyphs {
+		gid := sfnt.GlyphIndex(g.gid)
+		advance, _ := f.font.GlyphAdvance(&f.buf, gid, f.scale, f.hinting)
+		shaped[i] = shapedGlyph{face: f, r: g.r, gid: gid, start: g.start, end: g.end}
+
+		// marks that aren't attached follow their base, like they do in horizontal text
+		if g.class == otClassMark {
+			shaped[i].dot = fixed.Point26_6{X: base.X + baseAdvance, Y: base.Y}
+			continue
+		}
+		vAdvance, origin := f.verticalGlyph(gid)
+		shaped[i].dot = fixed.Point26_6{X: -advance/2 + scale(g.xOffset), Y: y + origin - scale(g.yOffset)}
+		base, baseAdvance = shaped[i].dot, advance
+		y += vAdvance
+	}
+
+	for i, g := range glyphs {
+		if g.attach >= 0 && g.attach < i {
+			dot := shaped[g.attach].dot
+			shaped[i].dot = fixed.Point26_6{X: dot.X + scale(g.xOffset), Y: dot.Y - scale(g.yOffset)}
+		}
+	}
+
+	return shaped, y
+}
+
+// verticalUpright lists the runes that stand upright in vertical text, after the Vertical_Orientation property of
+// Unicode: ideographs, kana, Hangul, fullwidth forms and most symbols.
+var verticalUpright = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x00a7, 0x00a7, 1}, {0x00a9, 0x00a9, 1}, {0x00ae, 0x00ae, 1}, {0x00b1, 0x00b1, 1}, {0x00bc, 0x00be, 1},
+		{0x00d7, 0x00d7, 1}, {0x00f7, 0x00f7, 1}, {0x02ea, 0x02eb, 1}, {0x1100, 0x11ff, 1}, {0x1401, 0x167f, 1},
+		{0x18b0, 0x18ff, 1}, {0x2016, 0x2016, 1}, {0x2020, 0x2021, 1}, {0x2030, 0x2031, 1}, {0x203b, 0x203c, 1},
+		{0x2042, 0x2042, 1}, {0x2047, 0x2049, 1}, {0x2051, 0x2051, 1}, {0x2065, 0x2065, 1}, {0x20dd, 0x20e0, 1},
+		{0x20e2, 0x20e4, 1}, {0x2100, 0x2101, 1}, {0x2103, 0x2109, 1}, {0x210f, 0x210f, 1}, {0x2113, 0x2114, 1},
+		{0x2116, 0x2117, 1}, {0x211e, 0x2123, 1}, {0x2125, 0x2125, 1}, {0x2127, 0x2127, 1}, {0x2129, 0x2129, 1},
+		{0x212e, 0x212e, 1}, {0x2135, 0x213f, 1}, {0x2145, 0x214a, 1}, {0x214c, 0x214d, 1}, {0x214f, 0x2189, 1},
+		{0x218c, 0x218f, 1}, {0x221e, 0x221e, 1}, {0x2234, 0x2235, 1}, {0x2300, 0x2307, 1}, {0x230c, 0x231f, 1},
+		{0x2324, 0x232b, 1}, {0x237d, 0x239a, 1}, {0x23be, 0x23cd, 1}, {0x23cf, 0x23cf, 1}, {0x23d1, 0x23db, 1},
+		{0x23e2, 0x2422, 1}, {0x2424, 0x24ff, 1}, {0x25a0, 0x2619, 1}, {0x2620, 0x2767, 1}, {0x2776, 0x2793, 1},
+		{0x2b12, 0x2b2f, 1}, {0x2b50, 0x2b59, 1}, {0x2bb8, 0x2bff, 1}, {0x2e80, 0xa4cf, 1}, {0xa960, 0xa97f, 1},
+		{0xac00, 0xd7ff, 1}, {0xe000, 0xfaff, 1}, {0xfe10, 0xfe1f, 1}, {0xfe30, 0xfe48, 1}, {0xfe50, 0xfe6f, 1},
+		{0xff01, 0xffe7, 1}, {0xfff0, 0xfff8, 1}, {0xfffc, 0xfffd, 1},
+	},
+	R32: []unicode.Range32{
+		{0x1f000, 0x1faff, 1}, {0x20000, 0x3fffd, 1},
+	},
+}
+
+// verticalTransformed lists the brackets and punctuation that stand upright in vertical text only with the vertical
+// forms of the font, and are turned sideways otherwise.
+var verticalTransformed = &unicode.RangeTable{R16: []unicode.Range16{
+	{0x2329, 0x232a, 1}, {0x3008, 0x3011, 1}, {0x3014, 0x301f, 1}, {0x3030, 0x3030, 1}, {0x30a0, 0x30a0, 1},
+	{0x30fc, 0x30fc, 1}, {0xfe59, 0xfe5e, 1}, {0xff08, 0xff09, 1}, {0xff0d, 0xff0d, 1}, {0xff1a, 0xff1e, 1},
+	{0xff3b, 0xff3b, 1}, {0xff3d, 0xff3d, 1}, {0xff3f, 0xff3f, 1}, {0xff5b, 0xff60, 1}, {0xffe3, 0xffe3, 1},
+}}
+
+// upright tells whether a rune drawn with a face stands upright in vertical text.
+func upright(face font.Face, r rune) bool {
+	if unicode.Is(verticalTransformed, r) {
+		return hasVerticalForms(leafFace(face, r))
+	}
+
+	return unicode.Is(verticalUpright, r)
+}
+
+// hasVerticalForms tells whether the font of a face has vertical forms for its glyphs.
+func hasVerticalForms(face font.Face) bool {
+	f, ok := face.(*fontFace)
+	if !ok {
+		return false
+	}
+	layout := f.layout()
+
+	return layout != nil && layout.gsub != nil && (layout.gsub.hasFeature(makeTag("vert")) || layout.gsub.hasFeature(makeTag("vrt2")))
+}
+
+// verticalRun is a run of a column of vertical text: upright glyphs, placed relative to the top of the run on the center
+// line of the column, or a sideways run of horizontal text.
+type verticalRun struct {
+	text     string
+	glyphs   []shapedGlyph
+	y        fixed.Int26_6 // the offset of the run down the column
+	sideways bool
+}
+
+// verticalColumn is a line of text shaped for vertical writing.
+type verticalColumn struct {
+	runs    []verticalRun
+	advance fixed.Int26_6
+	spaced  bool // whether the words of the column were moved apart by spread
+}
+
+// spread widens every gap between the words of a column by spacing, down the column.
+func (col *verticalColumn) spread(spacing fixed.Int26_6) {
+	w := wordSpacer{spacing: spacing}
+	for i := range col.runs {
+		run := &col.runs[i]
+		from := w.shift
+		run.y += from
+		w.spread(run.text, run.glyphs, !run.sideways, from)
+	}
+	col.advance += w.shift
+	col.spaced = true
+}
+
+// shapeColumn shapes a line of vertical text, split into its upright and sideways runs. Marks and invisible characters
+// go with the runes before them.
+func shapeColumn(face font.Face, s string) verticalColumn {
+	var col verticalColumn
+	start, up := 0, false
+	flush := func(end int) {
+		if end <= start {
+			return
+		}
+		run := verticalRun{text: s[start:end], y: col.advance, sideways: !up}
+		var shaped shapedString
+		if up {
+			shaped = shapeUpright(face, run.text)
+		} else {
+			shaped = shapeString(face, run.text)
+		}
+		run.glyphs = shaped.glyphs
+		col.runs = append(col.runs, run)
+		col.advance += shaped.advance
+		start = end
+	}
+
+	for i, r := range s {
+		u := up
+		if !unicode.In(r, unicode.Mn, unicode.Me) && !isDefaultIgnorable(r) {
+			u = upright(face, r)
+		}
+		if i > 0 && u != up {
+			flush(i)
+		}
+		up = u
+	}
+	flush(len(s))
+
+	return col
+}
+
+// sidewaysBaseline returns the offset from the center line of a column to the baseline of its sideways runs, which
+// centers them on the column.
+func sidewaysBaseline(face font.Face) float64 {
+	m := face.Metrics()
+
+	return -unfix(m.Ascent-m.Descent) / 2
+}
+
+// drawColumn draws a column of vertical text whose center line is at x, from y down. Sideways runs are turned a
+// quarter turn clockwise, with the tops of their glyphs to the right.
+func (dc *Context) drawColumn(im *image.RGBA, col verticalColumn, x, y float64) {
+	baseline := x + sidewaysBaseline(dc.fontFace)
+	for _, run := range col.runs {
+		if !run.sideways {
+			dc.drawGlyphs(im, run.glyphs, x, y+unfix(run.y))
+			continue
+		}
+		matrix := dc.matrix
+		dc.matrix = matrix.Translate(baseline, y+unfix(run.y)).Rotate(math.Pi / 2)
+		dc.drawGlyphs(im, run.glyphs, 0, 0)
+		dc.matrix = matrix
+	}
+}
+
+// recordColumn records a column of vertical text drawn with drawColumn, glyph by glyph for its upright runs.
+func (dc *Context) recordColumn(col verticalColumn, x, y float64) {
+	if !dc.recording() {
+		return
+	}
+
+	baseline := x + sidewaysBaseline(dc.fontFace)
+	for _, run := range col.runs {
+		if run.sideways {
+			matrix := dc.matrix
+			dc.matrix = matrix.Translate(baseline, y+unfix(run.y)).Rotate(math.Pi / 2)
+			if col.spaced {
+				dc.recordWords(run.text, run.glyphs, 0, 0)
+			} else {
+				dc.recordText(run.text, 0, 0)
+			}
+			dc.matrix = matrix
+			continue
+		}
+		recorded := -1
+		for _, g := range run.glyphs {
+			if g.start == recorded || g.start >= g.end {
+				continue
+			}
+			dc.recordText(run.text[g.start:g.end], x+unfix(g.dot.X), y+unfix(run.y+g.dot.Y))
+			recorded = g.start
+		}
+	}
+}
+
+// columnBox returns a box of a column of vertical text, shaped from s, relative to the top of its center line, see
+// TextBox.
+func (dc *Context) columnBox(s string, col verticalColumn, box TextBox) (x1, y1, x2, y2 float64) {
+	switch box {
+	case TextBoxBaseline:
+		return 0, 0, 0, unfix(col.advance)
+	case TextBoxInk:
+		var ink fixed.Rectangle26_6
+		baseline := fix(sidewaysBaseline(dc.fontFace))
+		for _, run := range col.runs {
+			for _, g := range run.glyphs {
+				b, ok := g.bounds()
+				if !ok {
+					continue
+				}
+				b = b.Add(g.dot)
+				if run.sideways {
+					// sideways glyphs are turned a quarter turn clockwise about the baseline
+					b = fixed.Rectangle26_6{
+						Min: fixed.Point26_6{X: baseline - b.Max.Y, Y: run.y + b.Min.X},
+						Max: fixed.Point26_6{X: baseline - b.Min.Y, Y: run.y + b.Max.X},
+					}
+				} else {
+					b = b.Add(fixed.Point26_6{Y: run.y})
+				}
+				ink = ink.Union(b)
+			}
+		}
+		if ink.Empty() {
+			return 0, 0, 0, 0
+		}
+		return unfix(ink.Min.X), unfix(ink.Min.Y), unfix(ink.Max.X), unfix(ink.Max.Y)
+	case TextBoxEm:
+		m := dc.fontFace.Metrics()
+		half := unfix(m.Ascent+m.Descent) / 2
+		return -half, 0, half, unfix(col.advance)
+	}
+
+	w, h := dc.MeasureString(s)
+
+	return -w / 2, 0, w / 2, h
+}
+
+// columnMeasurer measures strings down the columns of vertical text, for the wrapping functions.
+type columnMeasurer struct {
+	dc *Context
+}
+
+func (m columnMeasurer) MeasureString(s string) (w, h float64) {
+	a := shapeColumn(m.dc.fontFace, s).advance
+
+	return float64(a >> 6), m.dc.fontHeight
+}
+
+// lineMeasurer returns the measurer of the lengths of lines in the writing mode of the context.
+func (dc *Context) lineMeasurer() measureStringer {
+	if dc.vertical() {
+		return columnMeasurer{dc}
+	}
+
+	return dc
+}
+
+// lineAdvance returns the precise length of a line of text in the writing mode of the context.
+func (dc *Context) lineAdvance(s string) float64 {
+	if dc.vertical() {
+		return unfix(shapeColumn(dc.fontFace, s).advance)
+	}
+
+	return unfix(shapeString(dc.fontFace, s).advance)
+}
@@ -7,8 +7,75 @@ package gg
 import (
 	"strings"
 	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/image/math/fixed"
 )
 
+// WrapOptions holds the optional paragraph settings of DrawStringWrapped and LayoutStringWrapped.
+type WrapOptions struct {
+	ParagraphSpacing float64 // Extra space between paragraphs, the parts of the text separated by line breaks.
+	Indent           float64 // Indentation of the first line of every paragraph.
+	MaxLines         int     // Maximum number of lines, or zero for no limit.
+	Ellipsis         string  // Text that ends the last line when lines are cut by MaxLines, "…" if empty.
+
+	// BreakWords breaks words that are wider than a line between their characters, instead of letting them overflow.
+	BreakWords bool
+	// Hyphenate, if it is not nil, returns the byte offsets at which a word can be hyphenated. It is called for words
+	// that don't fit at the end of a line, which are then broken at the last offset that fits, followed by a hyphen.
+	Hyphenate func(word string) []int
+}
+
+// TextLayout is the result of laying out a wrapped string.
+type TextLayout struct {
+	Lines         []TextLine
+	X, Y          float64 // The top-left corner of the layout.
+	Width, Height float64 // The wrapping width and the measured height of the layout.
+	Truncated     bool    // Whether lines were cut by MaxLines.
+}
+
+// TextLine is a line box of a wrapped string. The baseline of the line is at the bottom of its box, at Y + Height, like
+// the text of DrawStringAnchored with ay set to 1. In vertical writing mode, the box is a column as wide as the font
+// height and as tall as its text, which is drawn from its top-left corner.
+type TextLine struct {
+	Text          string
+	X, Y          float64 // The top-left corner of the line box.
+	Width, Height float64 // The advance width of the line and the font height.
+	WordSpacing   float64 // Space added to every gap between words by AlignJustify.
+}
+
+// wordSpacer widens the gaps between the words of a line of shaped glyphs, its runs of spaces in display order, moving
+// the glyphs that follow each gap along the line.
+type wordSpacer struct {
+	spacing fixed.Int26_6
+	shift   fixed.Int26_6 // the space added so far
+	space   bool          // whether the last glyph was a space
+}
+
+// spread moves the glyphs of a run of a line, drawn from s, by the space added before them, minus the space added
+// before the run, from.
+func (w *wordSpacer) spread(s string, glyphs []shapedGlyph, vertical bool, from fixed.Int26_6) {
+	for i := range glyphs {
+		g := &glyphs[i]
+		if vertical {
+			g.dot.Y += w.shift - from
+		} else {
+			g.dot.X += w.shift - from
+		}
+		space := g.start < g.end && strings.TrimSpace(s[g.start:g.end]) == ""
+		if space && !w.space {
+			w.shift += w.spacing
+		}
+		w.space = space
+	}
+}
+
+// spreadWords widens every gap between the words of a line of shaped glyphs, drawn from s, by spacing.
+func spreadWords(s string, glyphs []shapedGlyph, spacing fixed.Int26_6) {
+	w := wordSpacer{spacing: spacing}
+	w.spread(s, glyphs, false, 0)
+}
+
 // measureStringer is an interface for objects that can measure the width and height of a string
 // when rendered with a specific font and style. Implementing this interface allows objects to
 // provide text measurement capabilities for layout and rendering.
@@ -40,9 +107,10 @@ func splitOnSpace(x string) []string {
 
 // wordWrap performs word wrapping on a given input string, breaking it into lines
 // based on a specified maximum width. The function uses a measureStringer to calculate
-// the width of the text. It takes into account spaces and line breaks and ensures that
-// words are not split in the middle.
-func wordWrap(m measureStringer, s string, width float64) []string {
+// the width of the text. It breaks lines at line breaks and at the line break
+// opportunities of the Unicode line breaking algorithm, so words are not split in the
+// middle unless the options allow it, while ideographic text can break between characters.
+func wordWrap(m measureStringer, s string, width float64, opts WrapOptions) []string {
 	var result []string
 
 	for _, line := range strings.Split(s, "\n") {
@@ -51,39 +119,121 @@ func wordWrap(m measureStringer, s string, width float64) []string {
 			continue
 		}
 
-		fields := splitOnSpace(line)
+		result = append(result, wrapParagraph(m, line, width, opts)...)
+	}
+
+	for i, line := range result {
+		result[i] = lineText(line)
+	}
 
-		if len(fields)%2 == 1 {
-			fields = append(fields, "")
+	return result
+}
+
+// wrapParagraph breaks a line of text without line breaks into lines no wider than width, the first of which is
+// narrower by the indent of the options. Words wider than a line get a line of their own, unless the options hyphenate
+// or break them. The lines keep their surrounding spaces and soft hyphens, see lineText.
+func wrapParagraph(m measureStringer, line string, width float64, opts WrapOptions) []string {
+	var result []string
+
+	fits := func(s string) bool {
+		limit := width
+		if len(result) == 0 {
+			limit -= opts.Indent
 		}
+		w, _ := m.MeasureString(lineText(s))
+		return w <= limit
+	}
 
-		x := ""
+	// split breaks a segment at the last of the offsets for which the line x followed by its head fits
+	split := func(x, segment string, offsets []int, hyphen bool) (head, tail string) {
+		for i := len(offsets) - 1; i >= 0; i-- {
+			k := offsets[i]
+			if k <= 0 || k >= len(segment) {
+				continue
+			}
+			head = segment[:k]
+			if hyphen {
+				head += string(softHyphen)
+			}
+			if fits(x + head) {
+				return head, segment[k:]
+			}
+		}
+		return "", segment
+	}
+
+	x := ""
 
-		for i := 0; i < len(fields); i += 2 {
-			w, _ := m.MeasureString(x + fields[i])
+	for _, s := range lineSegments(line) {
+		segment := s.text
 
-			if w > width {
-				if x == "" {
-					result = append(result, fields[i])
-					x = ""
-					continue
-				} else {
-					result = append(result, x)
-					x = ""
+		var hyphens []int
+		if opts.Hyphenate != nil {
+			hyphens = opts.Hyphenate(strings.TrimRightFunc(segment, unicode.IsSpace))
+		}
+
+		if x != "" && !fits(x+segment) {
+			head, tail := split(x, segment, hyphens, true)
+			result = append(result, x+head)
+			x = ""
+			hyphens = shiftOffsets(hyphens, len(segment)-len(tail))
+			segment = tail
+		}
+
+		for x == "" && !fits(segment) {
+			head, tail := split("", segment, hyphens, true)
+			if head == "" && opts.BreakWords {
+				// break between characters, at least one of them per line
+				breaks := clusterBreaks(segment)
+				if head, tail = split("", segment, breaks, false); head == "" && len(breaks) > 0 {
+					head, tail = segment[:breaks[0]], segment[breaks[0]:]
 				}
 			}
-
-			x += fields[i] + fields[i+1]
+			if head == "" {
+				break
+			}
+			result = append(result, head)
+			hyphens = shiftOffsets(hyphens, len(segment)-len(tail))
+			segment = tail
 		}
 
-		if x != "" {
+		x += segment
+
+		if s.mandatory {
 			result = append(result, x)
+			x = ""
 		}
 	}
 
-	for i, line := range result {
-		result[i] = strings.TrimSpace(line)
+	if x != "" {
+		result = append(result, x)
+	}
+
+	return result
+}
+
+// shiftOffsets returns the byte offsets after n, less n, for the rest of a string whose first n bytes were cut.
+func shiftOffsets(offsets []int, n int) []int {
+	var result []int
+	for _, k := range offsets {
+		if k > n {
+			result = append(result, k-n)
+		}
 	}
 
 	return result
 }
+
+// ellipsize shortens a line of text, a character at a time, until it fits in width followed by ellipsis, and appends
+// the ellipsis.
+func ellipsize(m measureStringer, s string, width float64, ellipsis string) string {
+	for s != "" {
+		if w, _ := m.MeasureString(s + ellipsis); w <= width {
+			break
+		}
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = strings.TrimRightFunc(s[:len(s)-size], unicode.IsSpace)
+	}
+
+	return s + ellipsis
+}
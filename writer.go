@@ -5,6 +5,7 @@
 package gg
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
@@ -38,6 +39,64 @@ func EncodePNG(w io.Writer, im image.Image) error {
 	return png.Encode(w, im)
 }
 
+// SaveSVG saves the drawing operations recorded on a context as an SVG file at the specified path.
+func SaveSVG(path string, dc *Context) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = EncodeSVG(file, dc)
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return err
+}
+
+// EncodeSVG encodes the drawing operations recorded on a context as an SVG document and writes it to the
+// provided io.Writer. Recording must have been started with StartRecording before drawing. SVG has no pages,
+// so only the current page is written, or the last page shown if nothing was drawn since.
+func EncodeSVG(w io.Writer, dc *Context) error {
+	if dc.recorder == nil {
+		return errors.New("context is not recording")
+	}
+
+	page := dc.recorder.lastPage()
+
+	return newSVGEncoder(w, page.width, page.height).encode(page.ops)
+}
+
+// SavePDF saves the pages recorded on a context as a PDF file at the specified path.
+func SavePDF(path string, dc *Context) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = EncodePDF(file, dc)
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return err
+}
+
+// EncodePDF encodes the pages recorded on a context as a PDF document and writes it to the provided io.Writer.
+// Recording must have been started with StartRecording before drawing. Every page finished with ShowPage is
+// written, followed by the current page if anything was drawn on it. Text is embedded as TrueType font subsets:
+// faces created by FontNewFace keep the outlines of their font, and the glyphs of other faces, like bitmap faces,
+// are traced from their masks.
+func EncodePDF(w io.Writer, dc *Context) error {
+	if dc.recorder == nil {
+		return errors.New("context is not recording")
+	}
+
+	return newPDFEncoder().encode(w, dc.recorder.allPages())
+}
+
 // SaveJPG saves an image as a JPEG file at the specified path with an optional quality setting.
 func SaveJPG(path string, im image.Image, quality ...int) error {
 	q := jpeg.DefaultQuality